@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+
+	"lc3/vm"
+)
+
+// LC-3 assembly source: laying it out in memory, for reformatting it, the language server and
+// the assembler
+
+var asmOps = map[string]bool{
+	"ADD": true, "AND": true, "NOT": true, "LD": true, "LDI": true, "LDR": true, "LEA": true,
+	"ST": true, "STI": true, "STR": true, "JMP": true, "JSR": true, "JSRR": true, "RET": true,
+	"RTI": true, "TRAP": true, "GETC": true, "OUT": true, "PUTS": true, "IN": true, "PUTSP": true,
+	"HALT": true, "NOP": true,
+	// pseudo-ops for the R6 stack
+	"PUSH": true, "POP": true, "CALL": true,
+	// -ext instructions
+	"MUL": true, "DIV": true, "MOD": true, "LSHF": true, "RSHFL": true, "RSHFA": true,
+}
+
+var asmDirectives = map[string]bool{".ORIG": true, ".FILL": true, ".BLKW": true, ".STRINGZ": true, ".END": true, ".EXTERNAL": true}
+
+func isAsmOp(word string) bool {
+	upper := strings.ToUpper(word)
+	if asmOps[upper] || asmDirectives[upper] {
+		return true
+	}
+	// BR with any combination of n, z, p
+	return strings.HasPrefix(upper, "BR") && strings.Trim(upper[2:], "NZP") == ""
+}
+
+// one line of source, split into its parts
+type asmLine struct {
+	text     string // as written
+	label    string
+	op       string
+	operands []string
+	comment  string // including the leading ';'
+
+	address uint16 // where its first word lands
+	words   int    // how many words it takes up
+}
+
+// splitComment cuts the line at the first ';' that isn't inside a string
+func splitComment(text string) (string, string) {
+	quoted := false
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			if quoted {
+				i++
+			}
+		case '"':
+			quoted = !quoted
+		case ';':
+			if !quoted {
+				return text[:i], text[i:]
+			}
+		}
+	}
+	return text, ""
+}
+
+// cutField splits off the first whitespace separated word
+func cutField(s string) (string, string) {
+	if i := strings.IndexAny(s, " \t"); i >= 0 {
+		return s[:i], strings.TrimSpace(s[i+1:])
+	}
+	return s, ""
+}
+
+func parseAsmLine(text string) asmLine {
+	line := asmLine{text: text}
+	code, comment := splitComment(text)
+	line.comment = strings.TrimSpace(comment)
+
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return line
+	}
+	first, rest := cutField(code)
+	if !isAsmOp(first) {
+		line.label = strings.TrimSuffix(first, ":")
+		if rest == "" {
+			return line
+		}
+		first, rest = cutField(rest)
+	}
+	line.op = first
+	if rest == "" {
+		return line
+	}
+	if strings.EqualFold(line.op, ".STRINGZ") {
+		line.operands = []string{rest}
+		return line
+	}
+	for _, operand := range strings.Split(rest, ",") {
+		if operand = strings.TrimSpace(operand); operand != "" {
+			line.operands = append(line.operands, operand)
+		}
+	}
+	return line
+}
+
+// stringzLength is the number of characters a .STRINGZ literal holds once escapes are resolved
+func stringzLength(literal string) int {
+	if s, err := strconv.Unquote(literal); err == nil {
+		return len([]rune(s))
+	}
+	return len(strings.Trim(literal, `"`))
+}
+
+// isInstruction tells code lines apart from data and directives
+func (l asmLine) isInstruction() bool {
+	return l.op != "" && !strings.HasPrefix(l.op, ".")
+}
+
+// layoutAsm assigns addresses to each line, following .ORIG and the size of each directive
+func layoutAsm(lines []asmLine) {
+	var address uint16
+	ended := false
+	for i := range lines {
+		l := &lines[i]
+		l.address = address
+		if ended || l.op == "" {
+			continue
+		}
+		switch strings.ToUpper(l.op) {
+		case ".ORIG":
+			if len(l.operands) > 0 {
+				if origin, err := parseAsmNumber(l.operands[0]); err == nil {
+					address = origin
+					l.address = origin
+				}
+			}
+		case ".END":
+			ended = true
+		case ".EXTERNAL":
+		case ".BLKW":
+			if len(l.operands) > 0 {
+				if n, err := parseAsmNumber(l.operands[0]); err == nil {
+					l.words = int(n)
+				}
+			}
+		case ".STRINGZ":
+			if len(l.operands) > 0 {
+				l.words = stringzLength(l.operands[0]) + 1
+			}
+		default:
+			l.words = 1
+			if n, ok := asmPseudoWords[strings.ToUpper(l.op)]; ok {
+				l.words = n
+			}
+		}
+		address += uint16(l.words)
+	}
+}
+
+// parseAsmNumber reads x1F / #31 / 31 style numbers
+func parseAsmNumber(s string) (uint16, error) {
+	s = strings.TrimPrefix(s, "#")
+	if n, err := strconv.ParseInt(s, 10, 32); err == nil {
+		return uint16(n), nil
+	}
+	return vm.ParseAddr(s)
+}
+
+func readAsm(path string) ([]asmLine, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var lines []asmLine
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, parseAsmLine(scanner.Text()))
+	}
+	return lines, scanner.Err()
+}
@@ -0,0 +1,507 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"lc3/decode"
+	"lc3/encode"
+	"lc3/vm"
+)
+
+const asmUsage = "lc3 asm [-r] [-o prog.obj] prog.asm"
+
+/*
+besides the LC-3 instructions and directives the assembler knows the stack idioms of the R6
+calling convention as pseudo-ops:
+
+	PUSH R1     ADD R6, R6, #-1     POP R1      LDR R1, R6, #0
+	            STR R1, R6, #0                  ADD R6, R6, #1
+
+	CALL SUB    ADD R6, R6, #-1     saves R7 on the stack around the JSR, so subroutines can call
+	            STR R7, R6, #0      others and still RET. the debugger's backtrace follows the
+	            JSR SUB             return addresses CALL leaves there. CALL R4 does JSRR R4
+	            LDR R7, R6, #0
+	            ADD R6, R6, #1
+
+RET is the instruction, JMP R7, as ever.
+
+with -r it writes a relocatable object instead, for lc3 to load wherever there's room or prog.obj@xADDR
+says, and link with others. .EXTERNAL NAME, ... names labels another object defines, for BR, JSR,
+CALL, LD, LDI, LEA, ST, STI and .FILL to use
+*/
+var asmPseudoWords = map[string]int{"PUSH": 2, "POP": 2, "CALL": 5}
+
+// asmError is what's wrong with a line
+type asmError struct {
+	line int // from 0
+	msg  string
+}
+
+// asmProgram is assembled source
+type asmProgram struct {
+	origin    uint16
+	words     []uint16
+	symbols   map[string]uint16
+	code      [][]uint16 // the words of each line
+	externals map[string]bool
+	relocs    []uint16 // offsets of the words holding one of the program's own addresses
+	refs      []vm.Reference
+}
+
+// assembleAsm turns laid out source into words, an error stops a line but not the rest
+func assembleAsm(lines []asmLine) (*asmProgram, []asmError) {
+	layoutAsm(lines)
+	prog := &asmProgram{symbols: map[string]uint16{}, code: make([][]uint16, len(lines)), externals: map[string]bool{}}
+	var errs []asmError
+	defined := map[string]int{}
+	for i, l := range lines {
+		if strings.EqualFold(l.op, ".EXTERNAL") {
+			for _, name := range l.operands {
+				if first, ok := defined[name]; ok {
+					errs = append(errs, asmError{i, fmt.Sprintf("label %s is already defined on line %d", name, first+1)})
+					continue
+				}
+				prog.externals[name], defined[name] = true, i
+			}
+		}
+		if l.label == "" {
+			continue
+		}
+		if first, ok := defined[l.label]; ok {
+			errs = append(errs, asmError{i, fmt.Sprintf("label %s is already defined on line %d", l.label, first+1)})
+			continue
+		}
+		prog.symbols[l.label], defined[l.label] = l.address, i
+	}
+
+	started, orig, ended := false, false, false
+	for i, l := range lines {
+		if l.op == "" || ended {
+			continue
+		}
+		switch strings.ToUpper(l.op) {
+		case ".ORIG":
+			if orig {
+				errs = append(errs, asmError{i, "only one .ORIG per file"})
+				continue
+			}
+			if len(l.operands) != 1 {
+				errs = append(errs, asmError{i, ".ORIG wants an address"})
+				continue
+			}
+			origin, err := parseAsmNumber(l.operands[0])
+			if err != nil {
+				errs = append(errs, asmError{i, err.Error()})
+			}
+			prog.origin, started, orig = origin, true, true
+			continue
+		case ".END":
+			ended = true
+			continue
+		case ".EXTERNAL":
+			if len(l.operands) == 0 {
+				errs = append(errs, asmError{i, ".EXTERNAL wants the labels it names"})
+			}
+			continue
+		}
+		if !started {
+			errs = append(errs, asmError{i, "code before .ORIG"})
+			started = true
+		}
+		words, err := encodeAsmLine(l, prog)
+		if err != nil {
+			errs = append(errs, asmError{i, err.Error()})
+			words = make([]uint16, l.words) // keeps everything after where the layout says
+		}
+		prog.code[i] = words
+		prog.words = append(prog.words, words...)
+	}
+	sort.SliceStable(errs, func(i, j int) bool { return errs[i].line < errs[j].line })
+	return prog, errs
+}
+
+// asmReg reads R0 to R7
+func asmReg(s string) (int, error) {
+	if len(s) == 2 && (s[0] == 'R' || s[0] == 'r') && s[1] >= '0' && s[1] <= '7' {
+		return int(s[1] - '0'), nil
+	}
+	return 0, fmt.Errorf("want a register, got %q", s)
+}
+
+// asmInt reads a number, #-5, 12 or x1F, as a signed value where it makes sense
+func asmInt(s string) (int, error) {
+	if n, err := strconv.ParseInt(strings.TrimPrefix(s, "#"), 10, 32); err == nil {
+		return int(n), nil
+	}
+	upper := strings.ToUpper(s)
+	for _, prefix := range []string{"0X", "X"} {
+		if strings.HasPrefix(upper, prefix) {
+			if n, err := strconv.ParseInt(upper[len(prefix):], 16, 32); err == nil {
+				return int(n), nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("want a number, got %q", s)
+}
+
+// asmSigned reads a number that has to fit a bits wide field, as a signed or, written in hex, an unsigned one
+func asmSigned(s string, bits int) (int, error) {
+	n, err := asmInt(s)
+	if err != nil {
+		return 0, err
+	}
+	if upper := strings.ToUpper(s); (upper[0] == 'X' || strings.HasPrefix(upper, "0X")) && n >= 1<<(bits-1) && n < 1<<bits {
+		n -= 1 << bits // x1F in an imm5 is -1
+	}
+	if n < -(1<<(bits-1)) || n >= 1<<(bits-1) {
+		return 0, fmt.Errorf("%s doesn't fit in %d bits", s, bits)
+	}
+	return n, nil
+}
+
+// asmOffset is the PC offset to a label or the offset written as a number, from the word at address.
+// an external label's is left to the linker
+func asmOffset(s string, address uint16, bits int, prog *asmProgram) (int, error) {
+	if prog.externals[s] {
+		prog.refs = append(prog.refs, vm.Reference{Name: s, Offset: address - prog.origin, Bits: bits})
+		return 0, nil
+	}
+	target, ok := prog.symbols[s]
+	if !ok {
+		if _, err := asmInt(s); err == nil {
+			return asmSigned(s, bits)
+		}
+		return 0, fmt.Errorf("undefined label %s", s)
+	}
+	offset := int(int16(target - address - 1))
+	if offset < -(1<<(bits-1)) || offset >= 1<<(bits-1) {
+		return 0, fmt.Errorf("%s is too far away, %d words for a %d bit offset", s, offset, bits)
+	}
+	return offset, nil
+}
+
+// asmOperands checks the operand count and reads the registers among them, at the positions in regs
+func asmOperands(l asmLine, want int, regs ...int) ([]int, error) {
+	if len(l.operands) != want {
+		return nil, fmt.Errorf("%s wants %d operand(s), got %d", strings.ToUpper(l.op), want, len(l.operands))
+	}
+	r := make([]int, len(regs))
+	for i, at := range regs {
+		var err error
+		if r[i], err = asmReg(l.operands[at]); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+var asmTraps = map[string]uint16{"GETC": 0x20, "OUT": 0x21, "PUTS": 0x22, "IN": 0x23, "PUTSP": 0x24, "HALT": 0x25}
+
+// encodeAsmLine gives the words of a line holding an instruction, pseudo-op or data directive
+func encodeAsmLine(l asmLine, prog *asmProgram) ([]uint16, error) {
+	op := strings.ToUpper(l.op)
+	if vector, ok := asmTraps[op]; ok {
+		if _, err := asmOperands(l, 0); err != nil {
+			return nil, err
+		}
+		return []uint16{encode.TRAP(vector)}, nil
+	}
+	if strings.HasPrefix(op, "BR") && isAsmOp(op) {
+		cc := uint16(0)
+		for _, flag := range op[2:] {
+			cc |= map[rune]uint16{'N': decode.CC_N, 'Z': decode.CC_Z, 'P': decode.CC_P}[flag]
+		}
+		if cc == 0 {
+			cc = decode.CC_N | decode.CC_Z | decode.CC_P
+		}
+		if _, err := asmOperands(l, 1); err != nil {
+			return nil, err
+		}
+		offset, err := asmOffset(l.operands[0], l.address, 9, prog)
+		if err != nil {
+			return nil, err
+		}
+		return []uint16{encode.BR(cc, offset)}, nil
+	}
+
+	switch op {
+	case "ADD", "AND", "MUL", "DIV", "MOD":
+		r, err := asmOperands(l, 3, 0, 1)
+		if err != nil {
+			return nil, err
+		}
+		if f, ok := map[string]uint16{"MUL": 0, "DIV": 1, "MOD": 2}[op]; ok {
+			sr2, err := asmReg(l.operands[2])
+			if err != nil {
+				return nil, err
+			}
+			return []uint16{0xD000 | uint16(r[0])<<9 | uint16(r[1])<<6 | f<<3 | uint16(sr2)}, nil
+		}
+		src := encode.Reg(0)
+		if sr2, err := asmReg(l.operands[2]); err == nil {
+			src = encode.Reg(sr2)
+		} else if imm, err := asmSigned(l.operands[2], 5); err == nil {
+			src = encode.Imm(imm)
+		} else {
+			return nil, err
+		}
+		if op == "ADD" {
+			return []uint16{encode.ADD(r[0], r[1], src)}, nil
+		}
+		return []uint16{encode.AND(r[0], r[1], src)}, nil
+	case "LSHF", "RSHFL", "RSHFA":
+		r, err := asmOperands(l, 3, 0, 1)
+		if err != nil {
+			return nil, err
+		}
+		amount, err := asmInt(l.operands[2])
+		if err != nil || amount < 0 || amount > 15 {
+			return nil, fmt.Errorf("want a shift of 0 to 15, got %q", l.operands[2])
+		}
+		d := map[string]uint16{"LSHF": 0, "RSHFL": 1, "RSHFA": 3}[op]
+		return []uint16{0xD000 | uint16(r[0])<<9 | uint16(r[1])<<6 | d<<4 | uint16(amount)}, nil
+	case "NOT":
+		r, err := asmOperands(l, 2, 0, 1)
+		if err != nil {
+			return nil, err
+		}
+		return []uint16{encode.NOT(r[0], r[1])}, nil
+	case "JMP", "JSRR":
+		r, err := asmOperands(l, 1, 0)
+		if err != nil {
+			return nil, err
+		}
+		if op == "JMP" {
+			return []uint16{encode.JMP(r[0])}, nil
+		}
+		return []uint16{encode.JSRR(r[0])}, nil
+	case "RET", "RTI", "NOP":
+		if _, err := asmOperands(l, 0); err != nil {
+			return nil, err
+		}
+		return []uint16{map[string]uint16{"RET": encode.RET(), "RTI": encode.RTI(), "NOP": 0}[op]}, nil
+	case "JSR":
+		if _, err := asmOperands(l, 1); err != nil {
+			return nil, err
+		}
+		offset, err := asmOffset(l.operands[0], l.address, 11, prog)
+		if err != nil {
+			return nil, err
+		}
+		return []uint16{encode.JSR(offset)}, nil
+	case "LD", "LDI", "LEA", "ST", "STI":
+		r, err := asmOperands(l, 2, 0)
+		if err != nil {
+			return nil, err
+		}
+		offset, err := asmOffset(l.operands[1], l.address, 9, prog)
+		if err != nil {
+			return nil, err
+		}
+		ops := map[string]func(int, int) uint16{"LD": encode.LD, "LDI": encode.LDI, "LEA": encode.LEA, "ST": encode.ST, "STI": encode.STI}
+		return []uint16{ops[op](r[0], offset)}, nil
+	case "LDR", "STR":
+		r, err := asmOperands(l, 3, 0, 1)
+		if err != nil {
+			return nil, err
+		}
+		offset, err := asmSigned(l.operands[2], 6)
+		if err != nil {
+			return nil, err
+		}
+		if op == "LDR" {
+			return []uint16{encode.LDR(r[0], r[1], offset)}, nil
+		}
+		return []uint16{encode.STR(r[0], r[1], offset)}, nil
+	case "TRAP":
+		if _, err := asmOperands(l, 1); err != nil {
+			return nil, err
+		}
+		vector, err := asmInt(l.operands[0])
+		if err != nil || vector < 0 || vector > 0xFF {
+			return nil, fmt.Errorf("want a trap vector x00 to xFF, got %q", l.operands[0])
+		}
+		return []uint16{encode.TRAP(uint16(vector))}, nil
+
+	case "PUSH", "POP":
+		r, err := asmOperands(l, 1, 0)
+		if err != nil {
+			return nil, err
+		}
+		if op == "PUSH" {
+			return []uint16{encode.ADD(6, 6, encode.Imm(-1)), encode.STR(r[0], 6, 0)}, nil
+		}
+		return []uint16{encode.LDR(r[0], 6, 0), encode.ADD(6, 6, encode.Imm(1))}, nil
+	case "CALL":
+		if _, err := asmOperands(l, 1); err != nil {
+			return nil, err
+		}
+		var jsr uint16
+		if r, err := asmReg(l.operands[0]); err == nil {
+			jsr = encode.JSRR(r)
+		} else {
+			offset, err := asmOffset(l.operands[0], l.address+2, 11, prog)
+			if err != nil {
+				return nil, err
+			}
+			jsr = encode.JSR(offset)
+		}
+		return []uint16{
+			encode.ADD(6, 6, encode.Imm(-1)), encode.STR(7, 6, 0),
+			jsr,
+			encode.LDR(7, 6, 0), encode.ADD(6, 6, encode.Imm(1)),
+		}, nil
+
+	case ".FILL":
+		if _, err := asmOperands(l, 1); err != nil {
+			return nil, err
+		}
+		if address, ok := prog.symbols[l.operands[0]]; ok {
+			prog.relocs = append(prog.relocs, l.address-prog.origin)
+			return []uint16{address}, nil
+		}
+		if prog.externals[l.operands[0]] {
+			prog.refs = append(prog.refs, vm.Reference{Name: l.operands[0], Offset: l.address - prog.origin, Bits: 16})
+			return []uint16{0}, nil
+		}
+		n, err := asmInt(l.operands[0])
+		if err != nil || n < -0x8000 || n > 0xFFFF {
+			return nil, fmt.Errorf("want a label or a 16 bit number, got %q", l.operands[0])
+		}
+		return []uint16{uint16(n)}, nil
+	case ".BLKW":
+		if _, err := asmOperands(l, 1); err != nil {
+			return nil, err
+		}
+		return make([]uint16, l.words), nil
+	case ".STRINGZ":
+		if _, err := asmOperands(l, 1); err != nil {
+			return nil, err
+		}
+		s, err := strconv.Unquote(l.operands[0])
+		if err != nil {
+			return nil, fmt.Errorf("want a string in double quotes, got %s", l.operands[0])
+		}
+		var words []uint16
+		for _, r := range s {
+			words = append(words, uint16(r))
+		}
+		return append(words, 0), nil
+	}
+	return nil, fmt.Errorf("unknown instruction %s", l.op)
+}
+
+// disassemble shows an instruction word in assembly syntax
+func disassemble(word uint16) string {
+	in := decode.Decode(word)
+	return strings.TrimSpace(in.Mnemonic() + " " + strings.Join(in.Operands(), ", "))
+}
+
+// writeObj writes the program as an object file, the origin and then the words, big endian,
+// or a relocatable object
+func writeObj(path string, prog *asmProgram, relocatable bool) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	if relocatable {
+		o := &vm.Object{Origin: prog.origin, Words: prog.words, Relocs: prog.relocs, Symbols: map[string]uint16{}, References: prog.refs}
+		for name, address := range prog.symbols {
+			o.Symbols[name] = address - prog.origin
+		}
+		o.WriteTo(w)
+	} else {
+		binary.Write(w, binary.BigEndian, prog.origin)
+		binary.Write(w, binary.BigEndian, prog.words)
+	}
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// writeSymbols writes the symbol table the way lc3as does, which the debugger reads back
+func writeSymbols(path string, symbols map[string]uint16) error {
+	names := make([]string, 0, len(symbols))
+	for name := range symbols {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return symbols[names[i]] < symbols[names[j]] })
+
+	var b strings.Builder
+	b.WriteString("// Symbol table\n// Scope level 0:\n//\tSymbol Name       Page Address\n//\t----------------  ------------\n")
+	for _, name := range names {
+		fmt.Fprintf(&b, "//\t%-16s  %04X\n", name, symbols[name])
+	}
+	return os.WriteFile(path, []byte(b.String()), 0644)
+}
+
+// readSymbols reads a symbol table written by lc3 asm or lc3as
+func readSymbols(path string) (map[string]uint16, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	symbols := map[string]uint16{}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(strings.TrimPrefix(line, "//"))
+		if len(fields) != 2 {
+			continue
+		}
+		if address, err := strconv.ParseUint(fields[1], 16, 16); err == nil {
+			symbols[fields[0]] = uint16(address)
+		}
+	}
+	return symbols, nil
+}
+
+// asmCommand runs 'lc3 asm', writing prog.obj and its symbol table prog.sym next to the source
+func asmCommand(args []string) int {
+	fs := flag.NewFlagSet("asm", flag.ExitOnError)
+	out := fs.String("o", "", "object file to write, the source's name with .obj by default")
+	relocatable := fs.Bool("r", false, "write a relocatable object, to be loaded anywhere and linked with others")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Println(asmUsage)
+		return 2
+	}
+	source := fs.Arg(0)
+	lines, err := readAsm(source)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	prog, errs := assembleAsm(lines)
+	for _, e := range errs {
+		fmt.Fprintf(os.Stderr, "%s:%d: %s\n", source, e.line+1, e.msg)
+	}
+	if len(errs) > 0 {
+		return 1
+	}
+	if len(prog.externals) > 0 && !*relocatable {
+		fmt.Fprintf(os.Stderr, "%s: .EXTERNAL labels need a relocatable object, -r\n", source)
+		return 1
+	}
+
+	if *out == "" {
+		*out = strings.TrimSuffix(source, ".asm") + ".obj"
+	}
+	if err := writeObj(*out, prog, *relocatable); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := writeSymbols(strings.TrimSuffix(*out, ".obj")+".sym", prog.symbols); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
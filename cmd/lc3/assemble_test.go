@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"lc3/encode"
+	"lc3/vm"
+)
+
+func assembleText(t *testing.T, text string) (*asmProgram, []asmError) {
+	t.Helper()
+	var lines []asmLine
+	for _, line := range strings.Split(text, "\n") {
+		lines = append(lines, parseAsmLine(line))
+	}
+	return assembleAsm(lines)
+}
+
+func TestAssemblePseudoOps(t *testing.T) {
+	prog, errs := assembleText(t, `
+	.ORIG x3000
+	PUSH R1
+	POP R2
+	CALL SUB
+	CALL R4
+SUB	RET
+	.END`)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	want := []uint16{
+		encode.ADD(6, 6, encode.Imm(-1)), encode.STR(1, 6, 0),
+		encode.LDR(2, 6, 0), encode.ADD(6, 6, encode.Imm(1)),
+		encode.ADD(6, 6, encode.Imm(-1)), encode.STR(7, 6, 0), encode.JSR(7), encode.LDR(7, 6, 0), encode.ADD(6, 6, encode.Imm(1)),
+		encode.ADD(6, 6, encode.Imm(-1)), encode.STR(7, 6, 0), encode.JSRR(4), encode.LDR(7, 6, 0), encode.ADD(6, 6, encode.Imm(1)),
+		encode.RET(),
+	}
+	if !slices.Equal(prog.words, want) {
+		t.Errorf("got %04X\nwant %04X", prog.words, want)
+	}
+	if prog.symbols["SUB"] != 0x300E {
+		t.Errorf("SUB at x%04X", prog.symbols["SUB"])
+	}
+}
+
+func TestAssembleAndRun(t *testing.T) {
+	prog, errs := assembleText(t, `
+	.ORIG x3000
+	LD R6, STACK
+	AND R0, R0, #0
+	CALL TWICE
+	HALT
+STACK	.FILL x4000
+TWICE	CALL ONCE
+	CALL ONCE
+	RET
+ONCE	ADD R0, R0, #1
+	RET
+	.END`)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	machine := vm.New()
+	machine.Out = &bytes.Buffer{}
+	for i, word := range prog.words {
+		machine.Poke(prog.origin+uint16(i), word)
+	}
+	if err := machine.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if machine.Reg(vm.R_R0) != 2 || machine.Reg(vm.R_R6) != 0x4000 {
+		t.Errorf("R0 %d, R6 x%04X", machine.Reg(vm.R_R0), machine.Reg(vm.R_R6))
+	}
+}
+
+func TestAssembleErrors(t *testing.T) {
+	_, errs := assembleText(t, `
+	ADD R0, R0, #1
+	.ORIG x3000
+A	ADD R0, R0, #16
+A	BR B
+	.END`)
+	var got []int
+	for _, e := range errs {
+		got = append(got, e.line)
+	}
+	if want := []int{1, 3, 4, 4}; !slices.Equal(got, want) {
+		t.Errorf("errors on lines %v, want %v: %v", got, want, errs)
+	}
+}
+
+func TestSymbols(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prog.sym")
+	symbols := map[string]uint16{"MAIN": 0x3000, "LOOP": 0x3004}
+	if err := writeSymbols(path, symbols); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readSymbols(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got["MAIN"] != 0x3000 || got["LOOP"] != 0x3004 {
+		t.Errorf("got %v", got)
+	}
+}
+
+func TestAssembleRelocatable(t *testing.T) {
+	kernel, errs := assembleText(t, `
+	.ORIG x3000
+	.EXTERNAL USER, COUNT
+	CALL USER
+	LDI R0, COUNTP
+	HALT
+COUNTP	.FILL COUNT
+	.END`)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	user, errs := assembleText(t, `
+	.ORIG x3000
+USER	LD R1, SELF
+	ST R1, COUNT
+	RET
+SELF	.FILL USER
+COUNT	.BLKW 1
+	.END`)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if want := []vm.Reference{{Name: "USER", Offset: 2, Bits: 11}, {Name: "COUNT", Offset: 7, Bits: 16}}; !slices.Equal(kernel.refs, want) {
+		t.Errorf("references %+v, want %+v", kernel.refs, want)
+	}
+	if !slices.Equal(user.relocs, []uint16{3}) {
+		t.Errorf("relocations %v", user.relocs)
+	}
+
+	dir := t.TempDir()
+	var loads []vm.ObjectLoad
+	for _, p := range []struct {
+		name string
+		prog *asmProgram
+		at   int
+	}{{"kernel.obj", kernel, -1}, {"user.obj", user, 0x3200}} {
+		path := filepath.Join(dir, p.name)
+		if err := writeObj(path, p.prog, true); err != nil {
+			t.Fatal(err)
+		}
+		object, err := vm.ReadObjectFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		loads = append(loads, vm.ObjectLoad{Path: path, Object: object, At: p.at})
+	}
+	machine := vm.New()
+	machine.Out = &bytes.Buffer{}
+	machine.SetReg(vm.R_R6, 0x5000)
+	if _, err := machine.LoadObjects(loads); err != nil {
+		t.Fatal(err)
+	}
+	if err := machine.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if machine.Reg(vm.R_R0) != 0x3200 || machine.Peek(0x3204) != 0x3200 {
+		t.Errorf("R0 x%04X, COUNT x%04X", machine.Reg(vm.R_R0), machine.Peek(0x3204))
+	}
+}
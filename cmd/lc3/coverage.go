@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"lc3/vm"
+)
+
+/*
+coverage files are plain text, one executed address per line:
+
+	# lc3 coverage
+	x3000 12
+*/
+func writeCoverage(path string, counts []uint64) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	fmt.Fprintln(w, "# lc3 coverage")
+	for address, n := range counts {
+		if n > 0 {
+			fmt.Fprintf(w, "x%04X %d\n", address, n)
+		}
+	}
+	return w.Flush()
+}
+
+// readCoverage adds the counts in a coverage file to counts
+func readCoverage(path string, counts []uint64) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return fmt.Errorf("%s:%d: want 'address count'", path, line)
+		}
+		address, err := vm.ParseAddr(fields[0])
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		n, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("%s:%d: bad count %q", path, line, fields[1])
+		}
+		counts[address] += n
+	}
+	return scanner.Err()
+}
+
+const covUsage = `lc3 cov merge -o out.cov run1.cov run2.cov ...
+lc3 cov annotate [-html] [-o out] prog.asm run.cov ...`
+
+// covCommand runs 'lc3 cov ...', returns the exit code
+func covCommand(args []string) int {
+	if len(args) < 1 {
+		fmt.Println(covUsage)
+		return 2
+	}
+	switch args[0] {
+	case "merge":
+		return covMerge(args[1:])
+	case "annotate":
+		return covAnnotate(args[1:])
+	}
+	fmt.Println(covUsage)
+	return 2
+}
+
+func covMerge(args []string) int {
+	fs := flag.NewFlagSet("cov merge", flag.ExitOnError)
+	out := fs.String("o", "merged.cov", "file to write the combined coverage to")
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		fmt.Println(covUsage)
+		return 2
+	}
+
+	counts := make([]uint64, vm.MEMORY_MAX)
+	for _, path := range fs.Args() {
+		if err := readCoverage(path, counts); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	if err := writeCoverage(*out, counts); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	covered := 0
+	for _, n := range counts {
+		if n > 0 {
+			covered++
+		}
+	}
+	fmt.Printf("merged %d files: %d addresses executed\n", fs.NArg(), covered)
+	return 0
+}
+
+// covAnnotate maps executed addresses back onto the assembly source.
+// the layout comes from the source itself, so it has to be the source the image was built from
+func covAnnotate(args []string) int {
+	fs := flag.NewFlagSet("cov annotate", flag.ExitOnError)
+	asHTML := fs.Bool("html", false, "write an HTML page instead of text")
+	out := fs.String("o", "", "file to write to (default stdout)")
+	fs.Parse(args)
+	if fs.NArg() < 2 {
+		fmt.Println(covUsage)
+		return 2
+	}
+
+	lines, err := readAsm(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	layoutAsm(lines)
+
+	counts := make([]uint64, vm.MEMORY_MAX)
+	for _, path := range fs.Args()[1:] {
+		if err := readCoverage(path, counts); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	w := os.Stdout
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer file.Close()
+		w = file
+	}
+	if *asHTML {
+		annotateHTML(w, fs.Arg(0), lines, counts)
+	} else {
+		annotateText(w, lines, counts)
+	}
+	return 0
+}
+
+// coverageSummary counts instruction lines, and how many of them ran
+func coverageSummary(lines []asmLine, counts []uint64) (int, int) {
+	total, hit := 0, 0
+	for _, l := range lines {
+		if l.isInstruction() {
+			total++
+			if counts[l.address] > 0 {
+				hit++
+			}
+		}
+	}
+	return total, hit
+}
+
+func annotateText(w io.Writer, lines []asmLine, counts []uint64) {
+	for _, l := range lines {
+		switch {
+		case l.isInstruction() && counts[l.address] > 0:
+			fmt.Fprintf(w, "%8d  x%04X | %s\n", counts[l.address], l.address, l.text)
+		case l.isInstruction():
+			fmt.Fprintf(w, "%8s  x%04X | %s\n", "#####", l.address, l.text)
+		default:
+			fmt.Fprintf(w, "%8s  %5s | %s\n", "", "", l.text)
+		}
+	}
+	total, hit := coverageSummary(lines, counts)
+	fmt.Fprintf(w, "\n%d of %d instruction lines executed\n", hit, total)
+}
+
+func annotateHTML(w io.Writer, title string, lines []asmLine, counts []uint64) {
+	fmt.Fprintf(w, "<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\"><title>%s coverage</title>\n", html.EscapeString(title))
+	fmt.Fprintln(w, "<style>body{font-family:monospace} td{padding:0 8px;white-space:pre} .hit{background:#d4f7d4} .miss{background:#f7d4d4}</style>")
+	fmt.Fprintln(w, "</head><body>")
+	total, hit := coverageSummary(lines, counts)
+	fmt.Fprintf(w, "<h1>%s</h1><p>%d of %d instruction lines executed</p>\n<table>\n", html.EscapeString(title), hit, total)
+	for _, l := range lines {
+		class, count, address := "", "", ""
+		if l.isInstruction() {
+			address = fmt.Sprintf("x%04X", l.address)
+			class, count = "miss", "0"
+			if n := counts[l.address]; n > 0 {
+				class, count = "hit", fmt.Sprint(n)
+			}
+		}
+		fmt.Fprintf(w, "<tr class=\"%s\"><td>%s</td><td>%s</td><td>%s</td></tr>\n", class, count, address, html.EscapeString(l.text))
+	}
+	fmt.Fprintln(w, "</table></body></html>")
+}
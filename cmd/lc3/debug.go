@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"lc3/vm"
+)
+
+const debugHelp = `while the machine is stopped:
+  s [n]         step n instructions (1)
+  c             continue to a breakpoint, a HALT, a fault or Ctrl+C
+  b [where]     set a breakpoint at an address or label, or list them
+  d where       delete a breakpoint
+  r             registers
+  m where [n]   n words of memory (8), disassembled
+  bt            backtrace, following the return addresses CALL saves on the R6 stack
+  stack [n]     n words (8) from R6 up, return addresses marked
+  heap          the MALLOC/FREE heap, block by block
+  int vec [PL]  raise the interrupt at vector vec (e.g. x80) at PL (4), taken by the next step
+  q             quit
+lines typed while the machine runs go to the program`
+
+type debugger struct {
+	machine *vm.VM
+	out     io.Writer
+	symbols map[string]uint16
+	breaks  map[uint16]bool
+	running atomic.Bool // lines read go to the program rather than to the debugger
+	stopped bool        // halted or faulted, there's nothing left to run
+	intr    chan os.Signal
+}
+
+// readSymbolFile gives the labels of an image from the symbol table next to it, prog.sym for
+// prog.obj as lc3 asm and lc3as write them, or none when there isn't one
+func readSymbolFile(image string) map[string]uint16 {
+	symbols, err := readSymbols(strings.TrimSuffix(image, ".obj") + ".sym")
+	if err != nil {
+		return nil
+	}
+	return symbols
+}
+
+// debugMachine runs the machine under the debugger until it's told to quit. commands are read from in
+// a line at a time, and whatever is typed while the machine runs is handed to it. symbols name
+// addresses for the commands to take and show
+func debugMachine(ctx context.Context, machine *vm.VM, symbols map[string]uint16, in io.Reader, out io.Writer) {
+	d := &debugger{machine: machine, out: out, symbols: symbols, breaks: map[uint16]bool{}}
+
+	programIn, programOut := io.Pipe()
+	machine.In = programIn
+	commands := make(chan string)
+	go func() {
+		defer close(commands)
+		scanner := bufio.NewScanner(in)
+		for scanner.Scan() {
+			if d.running.Load() {
+				fmt.Fprintln(programOut, scanner.Text())
+			} else {
+				commands <- scanner.Text()
+			}
+		}
+	}()
+
+	d.intr = make(chan os.Signal, 1)
+	signal.Notify(d.intr, os.Interrupt)
+	defer signal.Stop(d.intr)
+
+	fmt.Fprintf(out, "stopped at %s\n", d.instruction(machine.Reg(vm.R_PC)))
+	for {
+		fmt.Fprint(out, "(lc3) ")
+		var line string
+		select {
+		case l, ok := <-commands:
+			if !ok {
+				fmt.Fprintln(out)
+				return
+			}
+			line = l
+		case <-ctx.Done():
+			return
+		}
+		if !d.command(ctx, strings.Fields(line)) {
+			return
+		}
+	}
+}
+
+// command carries out one command, false means quit
+func (d *debugger) command(ctx context.Context, words []string) bool {
+	if len(words) == 0 {
+		return true
+	}
+	arg := func(i int, fallback int) int {
+		if i < len(words) {
+			if n, err := strconv.Atoi(words[i]); err == nil && n > 0 {
+				return n
+			}
+		}
+		return fallback
+	}
+
+	switch words[0] {
+	case "s", "step":
+		for i := 0; i < arg(1, 1) && d.step(); i++ {
+			fmt.Fprintln(d.out, d.instruction(d.machine.Reg(vm.R_PC)))
+		}
+	case "c", "continue":
+		d.cont(ctx)
+	case "b", "break":
+		if len(words) == 1 {
+			var addresses []int
+			for address := range d.breaks {
+				addresses = append(addresses, int(address))
+			}
+			sort.Ints(addresses)
+			for _, address := range addresses {
+				fmt.Fprintln(d.out, d.where(uint16(address)))
+			}
+			break
+		}
+		if address, ok := d.address(words[1]); ok {
+			d.breaks[address] = true
+		}
+	case "d", "delete":
+		if len(words) > 1 {
+			if address, ok := d.address(words[1]); ok {
+				delete(d.breaks, address)
+			}
+		}
+	case "r", "regs":
+		d.registers()
+	case "m", "mem":
+		if len(words) > 1 {
+			if address, ok := d.address(words[1]); ok {
+				for i := 0; i < arg(2, 8); i++ {
+					fmt.Fprintln(d.out, d.instruction(address+uint16(i)))
+				}
+			}
+		}
+	case "bt", "backtrace":
+		for i, f := range d.machine.Backtrace() {
+			line := fmt.Sprintf("#%d  %s", i, d.where(f.PC))
+			if f.Routine != 0 {
+				line += " in " + d.where(f.Routine)
+			}
+			switch {
+			case f.Slot != 0:
+				line += fmt.Sprintf("  (on the stack at x%04X)", f.Slot)
+			case i > 0:
+				line += "  (R7)"
+			}
+			fmt.Fprintln(d.out, line)
+		}
+	case "stack":
+		returns := map[uint16]bool{}
+		for _, f := range d.machine.Backtrace() {
+			if f.Slot != 0 {
+				returns[f.Slot] = true
+			}
+		}
+		sp := d.machine.Reg(vm.R_R6)
+		for i := 0; i < arg(1, 8); i++ {
+			slot := sp + uint16(i)
+			line := fmt.Sprintf("x%04X  x%04X", slot, d.machine.Peek(slot))
+			if i == 0 {
+				line += "  <- R6"
+			}
+			if returns[slot] {
+				line += "  return address, " + d.where(d.machine.Peek(slot))
+			}
+			fmt.Fprintln(d.out, line)
+		}
+	case "heap":
+		d.machine.PrintHeap(d.out)
+	case "int", "interrupt":
+		d.interrupt(words[1:])
+	case "q", "quit":
+		return false
+	default:
+		fmt.Fprintln(d.out, debugHelp)
+	}
+	return true
+}
+
+// interrupt raises an interrupt for the next step to take
+func (d *debugger) interrupt(args []string) {
+	if len(args) == 0 || len(args) > 2 {
+		fmt.Fprintln(d.out, "want interrupt vector [PL], e.g. interrupt x80 PL4")
+		return
+	}
+	vector, err := vm.ParseAddr(args[0])
+	if err != nil || vector > 0xFF {
+		fmt.Fprintf(d.out, "bad vector %q, want x00 to xFF\n", args[0])
+		return
+	}
+	priority := 4
+	if len(args) == 2 {
+		if priority, err = strconv.Atoi(strings.TrimPrefix(strings.ToUpper(args[1]), "PL")); err != nil {
+			priority = -1
+		}
+	}
+	if err := d.machine.AssertInterrupt(uint8(vector), priority); err != nil {
+		fmt.Fprintln(d.out, err)
+		return
+	}
+	if handler := d.machine.Peek(vm.INTERRUPT_TABLE_START + vector); handler != 0 {
+		fmt.Fprintf(d.out, "interrupt x%02X at PL%d, handled at %s\n", vector, priority, d.where(handler))
+	} else {
+		fmt.Fprintf(d.out, "interrupt x%02X at PL%d, there's no handler in the vector table\n", vector, priority)
+	}
+}
+
+// step runs one instruction, false once the machine can't go on
+func (d *debugger) step() bool {
+	if d.stopped {
+		fmt.Fprintln(d.out, "the machine has stopped")
+		return false
+	}
+	d.running.Store(true)
+	info, err := d.machine.Step()
+	d.running.Store(false)
+	switch {
+	case err != nil:
+		fmt.Fprintln(d.out, err)
+		d.stopped = true
+	case info.Halted:
+		fmt.Fprintln(d.out, "halted")
+		d.stopped = true
+	}
+	return !d.stopped
+}
+
+// cont runs until there's a reason to stop
+func (d *debugger) cont(ctx context.Context) {
+	for {
+		select {
+		case <-d.intr:
+			fmt.Fprintf(d.out, "interrupted at %s\n", d.instruction(d.machine.Reg(vm.R_PC)))
+			return
+		default:
+		}
+		if ctx.Err() != nil || !d.step() {
+			return
+		}
+		if pc := d.machine.Reg(vm.R_PC); d.breaks[pc] {
+			fmt.Fprintf(d.out, "breakpoint at %s\n", d.instruction(pc))
+			return
+		}
+	}
+}
+
+func (d *debugger) registers() {
+	m := d.machine
+	for r := vm.R_R0; r <= vm.R_R7; r++ {
+		fmt.Fprintf(d.out, "R%d x%04X  ", r, m.Reg(r))
+		if r == vm.R_R3 {
+			fmt.Fprintln(d.out)
+		}
+	}
+	psr := m.PSR()
+	mode := "supervisor"
+	if psr&vm.PSR_USER != 0 {
+		mode = "user"
+	}
+	cc := map[uint16]string{vm.FL_NEG: "N", vm.FL_ZRO: "Z", vm.FL_POS: "P"}[psr&7]
+	fmt.Fprintf(d.out, "\nPC x%04X  PSR x%04X (%s, PL%d, %s)\n", m.Reg(vm.R_PC), psr, mode, psr>>8&7, cc)
+}
+
+// address reads an address or a label
+func (d *debugger) address(s string) (uint16, bool) {
+	if address, ok := d.symbols[s]; ok {
+		return address, true
+	}
+	address, err := vm.ParseAddr(s)
+	if err != nil {
+		fmt.Fprintf(d.out, "no label or address %q\n", s)
+		return 0, false
+	}
+	return address, true
+}
+
+// where names an address after the closest label at or below it
+func (d *debugger) where(address uint16) string {
+	best, bestAt := "", -1
+	for name, at := range d.symbols {
+		if at <= address && int(at) > bestAt && address-at < 0x100 {
+			best, bestAt = name, int(at)
+		}
+	}
+	switch {
+	case bestAt < 0:
+		return fmt.Sprintf("x%04X", address)
+	case int(address) == bestAt:
+		return fmt.Sprintf("x%04X %s", address, best)
+	}
+	return fmt.Sprintf("x%04X %s+%d", address, best, int(address)-bestAt)
+}
+
+// instruction shows the word at address, disassembled
+func (d *debugger) instruction(address uint16) string {
+	word := d.machine.Peek(address)
+	return fmt.Sprintf("%-16s x%04X  %s", d.where(address), word, disassemble(word))
+}
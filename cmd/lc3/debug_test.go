@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"lc3/encode"
+	"lc3/vm"
+)
+
+func TestDebugInterrupt(t *testing.T) {
+	machine := vm.New()
+	machine.Out = &bytes.Buffer{}
+	machine.LoadImage(bytes.NewReader([]byte{0x30, 0x00, 0x10, 0x61, 0xF0, 0x25})) // ADD R0, R1, #1; HALT
+	machine.Poke(vm.INTERRUPT_TABLE_START+0x80, 0x1000)
+	machine.Poke(0x1000, encode.ADD(2, 2, encode.Imm(5)))
+	machine.ResetCPU()
+
+	var out bytes.Buffer
+	commands := "int x80 PL5\ns\nr\nint x80 PL9\nint\nq\n"
+	debugMachine(context.Background(), machine, map[string]uint16{"ISR": 0x1000}, strings.NewReader(commands), &out)
+	for _, want := range []string{
+		"interrupt x80 at PL5, handled at x1000 ISR",
+		"R2 x0005",
+		"supervisor, PL5",
+		"priority 9 is not between 0 and 7",
+		"want interrupt vector [PL]",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("no %q in\n%s", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const fmtUsage = "lc3 fmt [-w] [-check] prog.asm ..."
+
+// formatOperand upper cases registers and writes numbers as xHEX or #decimal
+func formatOperand(operand string) string {
+	upper := strings.ToUpper(operand)
+	if len(upper) == 2 && upper[0] == 'R' && upper[1] >= '0' && upper[1] <= '7' {
+		return upper
+	}
+	for _, prefix := range []string{"0X", "X"} {
+		if strings.HasPrefix(upper, prefix) {
+			if _, err := strconv.ParseUint(upper[len(prefix):], 16, 16); err == nil {
+				return "x" + upper[len(prefix):]
+			}
+		}
+	}
+	if n, err := strconv.ParseInt(strings.TrimPrefix(operand, "#"), 10, 32); err == nil {
+		return fmt.Sprintf("#%d", n)
+	}
+	return operand // a label
+}
+
+// formatOp upper cases the opcode, keeping BR's condition codes lower case as usual
+func formatOp(op string) string {
+	upper := strings.ToUpper(op)
+	if strings.HasPrefix(upper, "BR") && !asmOps[upper] && isAsmOp(upper) {
+		return "BR" + strings.ToLower(upper[2:])
+	}
+	return upper
+}
+
+// formatAsm lines labels, opcodes, operands and trailing comments up in columns
+func formatAsm(src []byte) []byte {
+	text := strings.ReplaceAll(string(src), "\r\n", "\n")
+	raw := strings.Split(strings.TrimRight(text, "\n"), "\n")
+
+	lines := make([]asmLine, len(raw))
+	opCol := 8
+	for i, r := range raw {
+		lines[i] = parseAsmLine(r)
+		if n := len(lines[i].label) + 2; lines[i].op != "" && n > opCol {
+			opCol = n
+		}
+	}
+
+	codes := make([]string, len(lines))
+	commentCol := 0
+	for i, l := range lines {
+		if l.label == "" && l.op == "" {
+			continue
+		}
+		code := l.label
+		if l.op != "" {
+			code += strings.Repeat(" ", opCol-len(l.label)) + formatOp(l.op)
+			operands := make([]string, len(l.operands))
+			for j, operand := range l.operands {
+				if strings.EqualFold(l.op, ".STRINGZ") {
+					operands[j] = operand
+				} else {
+					operands[j] = formatOperand(operand)
+				}
+			}
+			if len(operands) > 0 {
+				code += " " + strings.Join(operands, ", ")
+			}
+		}
+		codes[i] = code
+		if l.comment != "" && len(code)+2 > commentCol {
+			commentCol = len(code) + 2
+		}
+	}
+
+	var out bytes.Buffer
+	for i, l := range lines {
+		switch {
+		case codes[i] == "" && l.comment == "":
+			// blank line
+		case codes[i] == "":
+			// a comment on its own keeps to the left margin or the opcode column
+			if strings.TrimSpace(raw[i]) != raw[i] && !strings.HasPrefix(raw[i], ";") {
+				out.WriteString(strings.Repeat(" ", opCol))
+			}
+			out.WriteString(l.comment)
+		case l.comment == "":
+			out.WriteString(codes[i])
+		default:
+			out.WriteString(codes[i] + strings.Repeat(" ", commentCol-len(codes[i])) + l.comment)
+		}
+		out.WriteByte('\n')
+	}
+	return out.Bytes()
+}
+
+// fmtCommand runs 'lc3 fmt', returns the exit code
+func fmtCommand(args []string) int {
+	fs := flag.NewFlagSet("fmt", flag.ExitOnError)
+	write := fs.Bool("w", false, "write the result back to the file instead of stdout")
+	check := fs.Bool("check", false, "only list files that aren't formatted, exit 1 if there are any")
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		fmt.Println(fmtUsage)
+		return 2
+	}
+
+	status := 0
+	for _, path := range fs.Args() {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		formatted := formatAsm(src)
+
+		switch {
+		case *check:
+			if !bytes.Equal(src, formatted) {
+				fmt.Println(path)
+				status = 1
+			}
+		case *write:
+			if !bytes.Equal(src, formatted) {
+				if err := os.WriteFile(path, formatted, 0644); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					return 1
+				}
+			}
+		default:
+			os.Stdout.Write(formatted)
+		}
+	}
+	return status
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// a small language server for LC-3 assembly over stdio. the diagnostics are what lc3 asm would
+// say about the source, and hovering over a line shows the words it assembles to
+
+type lspMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspLocation struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"` // 1 error, 2 warning
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+type lspTextEdit struct {
+	Range   lspRange `json:"range"`
+	NewText string   `json:"newText"`
+}
+
+// the params of every request we answer fit in this
+type lspParams struct {
+	TextDocument struct {
+		URI  string `json:"uri"`
+		Text string `json:"text"`
+	} `json:"textDocument"`
+	ContentChanges []struct {
+		Text string `json:"text"`
+	} `json:"contentChanges"`
+	Position lspPosition `json:"position"`
+	NewName  string      `json:"newName"`
+}
+
+// a word in the source that could be a label
+type asmToken struct {
+	text  string
+	line  int
+	start int // column
+}
+
+func (t asmToken) lspRange() lspRange {
+	return lspRange{Start: lspPosition{t.line, t.start}, End: lspPosition{t.line, t.start + len(t.text)}}
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c == '.' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || (c >= '0' && c <= '9')
+}
+
+// tokenizeAsm finds the words in the code part of a line, skipping strings, numbers and comments
+func tokenizeAsm(text string, line int) []asmToken {
+	code, _ := splitComment(text)
+	var tokens []asmToken
+	for i := 0; i < len(code); {
+		c := code[i]
+		switch {
+		case c == '"':
+			for i++; i < len(code) && code[i] != '"'; i++ {
+				if code[i] == '\\' {
+					i++
+				}
+			}
+			i++
+		case c == '#' || (c >= '0' && c <= '9') || c == '-':
+			for i++; i < len(code) && isIdentChar(code[i]); i++ {
+			}
+		case isIdentStart(c):
+			start := i
+			for i++; i < len(code) && isIdentChar(code[i]); i++ {
+			}
+			tokens = append(tokens, asmToken{text: code[start:i], line: line, start: start})
+		default:
+			i++
+		}
+	}
+	return tokens
+}
+
+// an analysed document
+type asmDoc struct {
+	lines  []asmLine
+	prog   *asmProgram
+	failed map[int]bool // lines with an error, whose words aren't worth showing
+	defs   map[string]asmToken
+	diags  []lspDiagnostic
+	byLine [][]asmToken
+}
+
+// analyseAsm runs the source through the assembler, its errors are the diagnostics
+func analyseAsm(text string) *asmDoc {
+	doc := &asmDoc{defs: map[string]asmToken{}, failed: map[int]bool{}}
+	for i, raw := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		line := parseAsmLine(raw)
+		doc.lines = append(doc.lines, line)
+		tokens := tokenizeAsm(raw, i)
+		doc.byLine = append(doc.byLine, tokens)
+		if _, ok := doc.defs[line.label]; line.label != "" && len(tokens) > 0 && !ok {
+			doc.defs[line.label] = tokens[0]
+		}
+	}
+
+	prog, errs := assembleAsm(doc.lines)
+	doc.prog = prog
+	for _, e := range errs {
+		doc.failed[e.line] = true
+		doc.diags = append(doc.diags, lspDiagnostic{Range: doc.errorRange(e), Severity: 1, Source: "lc3", Message: e.msg})
+	}
+	return doc
+}
+
+// errorRange marks the word an error names, or failing that the code on its line
+func (d *asmDoc) errorRange(e asmError) lspRange {
+	named := map[string]bool{}
+	for _, word := range strings.FieldsFunc(e.msg, func(r rune) bool { return r > 0x7F || !isIdentChar(byte(r)) }) {
+		named[word] = true
+	}
+	for _, t := range d.byLine[e.line] {
+		if named[t.text] {
+			return t.lspRange()
+		}
+	}
+	code, _ := splitComment(d.lines[e.line].text)
+	start := len(code) - len(strings.TrimLeft(code, " \t"))
+	return lspRange{Start: lspPosition{e.line, start}, End: lspPosition{e.line, len(strings.TrimRight(code, " \t"))}}
+}
+
+// tokenAt finds the word under the cursor
+func (d *asmDoc) tokenAt(pos lspPosition) (asmToken, bool) {
+	if pos.Line < 0 || pos.Line >= len(d.byLine) {
+		return asmToken{}, false
+	}
+	for _, t := range d.byLine[pos.Line] {
+		if pos.Character >= t.start && pos.Character <= t.start+len(t.text) {
+			return t, true
+		}
+	}
+	return asmToken{}, false
+}
+
+type lspServer struct {
+	in   *bufio.Reader
+	out  io.Writer
+	docs map[string]*asmDoc
+}
+
+func (s *lspServer) read() (*lspMessage, error) {
+	length := -1
+	for {
+		header, err := s.in.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		header = strings.TrimSpace(header)
+		if header == "" {
+			break
+		}
+		if name, value, ok := strings.Cut(header, ":"); ok && strings.EqualFold(name, "Content-Length") {
+			length, _ = strconv.Atoi(strings.TrimSpace(value))
+		}
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("message without Content-Length")
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(s.in, body); err != nil {
+		return nil, err
+	}
+	var msg lspMessage
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+func (s *lspServer) write(msg any) {
+	body, _ := json.Marshal(msg)
+	fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+func (s *lspServer) reply(id json.RawMessage, result any) {
+	// a null result still has to be sent, so no omitempty here
+	s.write(struct {
+		JSONRPC string          `json:"jsonrpc"`
+		ID      json.RawMessage `json:"id"`
+		Result  any             `json:"result"`
+	}{"2.0", id, result})
+}
+
+func (s *lspServer) publish(uri string, doc *asmDoc) {
+	diags := doc.diags
+	if diags == nil {
+		diags = []lspDiagnostic{}
+	}
+	s.write(map[string]any{
+		"jsonrpc": "2.0",
+		"method":  "textDocument/publishDiagnostics",
+		"params":  map[string]any{"uri": uri, "diagnostics": diags},
+	})
+}
+
+func (s *lspServer) handle(msg *lspMessage) bool {
+	var params lspParams
+	json.Unmarshal(msg.Params, &params)
+	uri := params.TextDocument.URI
+	doc := s.docs[uri]
+
+	switch msg.Method {
+	case "initialize":
+		s.reply(msg.ID, map[string]any{
+			"capabilities": map[string]any{
+				"textDocumentSync":   1, // full text on every change
+				"definitionProvider": true,
+				"hoverProvider":      true,
+				"renameProvider":     true,
+			},
+			"serverInfo": map[string]string{"name": "lc3"},
+		})
+	case "textDocument/didOpen":
+		s.docs[uri] = analyseAsm(params.TextDocument.Text)
+		s.publish(uri, s.docs[uri])
+	case "textDocument/didChange":
+		if n := len(params.ContentChanges); n > 0 {
+			s.docs[uri] = analyseAsm(params.ContentChanges[n-1].Text)
+			s.publish(uri, s.docs[uri])
+		}
+	case "textDocument/didClose":
+		delete(s.docs, uri)
+	case "textDocument/definition":
+		var result any
+		if doc != nil {
+			if t, ok := doc.tokenAt(params.Position); ok {
+				if def, ok := doc.defs[t.text]; ok {
+					result = lspLocation{URI: uri, Range: def.lspRange()}
+				}
+			}
+		}
+		s.reply(msg.ID, result)
+	case "textDocument/hover":
+		s.reply(msg.ID, s.hover(doc, params.Position))
+	case "textDocument/rename":
+		var result any
+		if doc != nil {
+			if t, ok := doc.tokenAt(params.Position); ok {
+				if _, ok := doc.defs[t.text]; ok {
+					var edits []lspTextEdit
+					for _, tokens := range doc.byLine {
+						for _, other := range tokens {
+							if other.text == t.text {
+								edits = append(edits, lspTextEdit{Range: other.lspRange(), NewText: params.NewName})
+							}
+						}
+					}
+					result = map[string]any{"changes": map[string][]lspTextEdit{uri: edits}}
+				}
+			}
+		}
+		s.reply(msg.ID, result)
+	case "shutdown":
+		s.reply(msg.ID, nil)
+	case "exit":
+		return false
+	default:
+		if msg.ID != nil {
+			s.write(map[string]any{"jsonrpc": "2.0", "id": msg.ID,
+				"error": map[string]any{"code": -32601, "message": "method not found: " + msg.Method}})
+		}
+	}
+	return true
+}
+
+// hover shows where a label or the line under the cursor sits in memory, and what an instruction
+// assembles to
+func (s *lspServer) hover(doc *asmDoc, pos lspPosition) any {
+	if doc == nil || pos.Line < 0 || pos.Line >= len(doc.lines) {
+		return nil
+	}
+	var text string
+	if t, ok := doc.tokenAt(pos); ok {
+		if def, ok := doc.defs[t.text]; ok {
+			text = fmt.Sprintf("%s = x%04X", t.text, doc.lines[def.line].address)
+		}
+	}
+	if line := doc.lines[pos.Line]; text == "" && line.words > 0 {
+		text = fmt.Sprintf("x%04X, %d word(s)", line.address, line.words)
+		if line.isInstruction() && !doc.failed[pos.Line] {
+			for i, word := range doc.prog.code[pos.Line] {
+				text += fmt.Sprintf("\nx%04X  x%04X  %s", line.address+uint16(i), word, disassemble(word))
+			}
+		}
+	}
+	if text == "" {
+		return nil
+	}
+	return map[string]any{"contents": map[string]string{"kind": "plaintext", "value": text}}
+}
+
+// lspCommand runs 'lc3 lsp', serving on stdin/stdout until the client says exit
+func lspCommand() int {
+	s := &lspServer{in: bufio.NewReader(os.Stdin), out: os.Stdout, docs: map[string]*asmDoc{}}
+	for {
+		msg, err := s.read()
+		if err != nil {
+			if err != io.EOF {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+			return 0
+		}
+		if !s.handle(msg) {
+			return 0
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const lspSource = `	.ORIG x3000
+LOOP	ADD R1, R1, #-1
+	BRp LOOP
+LOOP	BR DONE
+	HALT
+	.END`
+
+func TestAnalyseAsm(t *testing.T) {
+	doc := analyseAsm(lspSource)
+	want := []struct {
+		line, start int
+		message     string
+	}{
+		{3, 0, "label LOOP is already defined on line 2"},
+		{3, 8, "undefined label DONE"},
+	}
+	if len(doc.diags) != len(want) {
+		t.Fatalf("got %+v", doc.diags)
+	}
+	for i, w := range want {
+		d := doc.diags[i]
+		if d.Range.Start != (lspPosition{w.line, w.start}) || d.Message != w.message {
+			t.Errorf("got %+v, want %+v", d, w)
+		}
+	}
+	if doc := analyseAsm("\t.ORIG x3000\n\tHALT\n\t.END"); len(doc.diags) != 0 {
+		t.Errorf("diagnostics for a good program: %+v", doc.diags)
+	}
+}
+
+// lspCall sends a request to the server and returns the result of its reply
+func lspCall(t *testing.T, s *lspServer, method string, params any) json.RawMessage {
+	t.Helper()
+	raw, _ := json.Marshal(params)
+	out := s.out.(*bytes.Buffer)
+	out.Reset()
+	s.handle(&lspMessage{JSONRPC: "2.0", ID: json.RawMessage("1"), Method: method, Params: raw})
+	_, body, _ := strings.Cut(out.String(), "\r\n\r\n")
+	var reply struct {
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.Unmarshal([]byte(body), &reply); err != nil {
+		t.Fatalf("%s: %v in %q", method, err, out.String())
+	}
+	return reply.Result
+}
+
+func TestLSPRequests(t *testing.T) {
+	const uri = "file:///prog.asm"
+	s := &lspServer{out: &bytes.Buffer{}, docs: map[string]*asmDoc{uri: analyseAsm(lspSource)}}
+	at := func(line, character int) map[string]any {
+		return map[string]any{"textDocument": map[string]string{"uri": uri}, "position": lspPosition{line, character}}
+	}
+
+	var location lspLocation
+	json.Unmarshal(lspCall(t, s, "textDocument/definition", at(2, 6)), &location)
+	if location.Range.Start != (lspPosition{1, 0}) {
+		t.Errorf("definition of LOOP at %+v", location.Range)
+	}
+
+	params := at(1, 1)
+	params["newName"] = "AGAIN"
+	var rename struct {
+		Changes map[string][]lspTextEdit `json:"changes"`
+	}
+	json.Unmarshal(lspCall(t, s, "textDocument/rename", params), &rename)
+	if edits := rename.Changes[uri]; len(edits) != 3 || edits[1].Range.Start != (lspPosition{2, 5}) || edits[1].NewText != "AGAIN" {
+		t.Errorf("rename edits %+v", edits)
+	}
+
+	var hover struct {
+		Contents struct {
+			Value string `json:"value"`
+		} `json:"contents"`
+	}
+	json.Unmarshal(lspCall(t, s, "textDocument/hover", at(1, 10)), &hover)
+	if want := "x3000, 1 word(s)\nx3000  x127F  ADD R1, R1, #-1"; hover.Contents.Value != want {
+		t.Errorf("hover %q, want %q", hover.Contents.Value, want)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// loadMachine sets flags from a machine description, a small part of TOML:
+//
+//	# board.toml
+//	disk = "disk.img"
+//	serial = ":7000"
+//	switches = "x00FF"
+//	device = ["cmd:./uart.py", "cmd:./leds.py"]
+//
+//	[pcm]
+//	rate = 8000
+//	cmd = "aplay -q -t raw -f S16_BE -c 1 -r 8000"
+//
+//	[timer]
+//	address = "xFEC0"
+//
+// a key names a flag, and a key in a [table] the flag table-key, so rate above is -pcm-rate.
+// every built-in device takes an address in its table, -timer-address and so on, which moves its
+// registers. flags given on the command line win over the file
+func loadMachine(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	given := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	table := ""
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if line[0] == '[' {
+			end := strings.IndexByte(line, ']')
+			if end < 0 || !isComment(line[end+1:]) {
+				return fmt.Errorf("%s:%d: want [table]", path, n)
+			}
+			table = strings.TrimSpace(line[1:end])
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return fmt.Errorf("%s:%d: want key = value", path, n)
+		}
+		name := strings.TrimSpace(key)
+		if table != "" {
+			name = table + "-" + name
+		}
+		values, err := parseTOMLValue(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", path, n, err)
+		}
+		if name == "machine" || flag.Lookup(name) == nil {
+			return fmt.Errorf("%s:%d: no flag -%s", path, n, name)
+		}
+		if given[name] {
+			continue
+		}
+		for _, value := range values {
+			if err := flag.Set(name, value); err != nil {
+				return fmt.Errorf("%s:%d: -%s: %v", path, n, name, err)
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// parseTOMLValue reads a string, number, boolean or array of them, and what follows it up to a comment.
+// an array gives each of its values, for flags that can be given more than once
+func parseTOMLValue(s string) ([]string, error) {
+	if strings.HasPrefix(s, "[") {
+		var values []string
+		s = strings.TrimSpace(s[1:])
+		for !strings.HasPrefix(s, "]") {
+			value, rest, err := parseTOMLScalar(s)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+			s = strings.TrimSpace(rest)
+			if strings.HasPrefix(s, ",") {
+				s = strings.TrimSpace(s[1:])
+			} else if !strings.HasPrefix(s, "]") {
+				return nil, fmt.Errorf("want , or ] in the array")
+			}
+		}
+		if !isComment(s[1:]) {
+			return nil, fmt.Errorf("%q after the array", strings.TrimSpace(s[1:]))
+		}
+		return values, nil
+	}
+	value, rest, err := parseTOMLScalar(s)
+	if err != nil {
+		return nil, err
+	}
+	if !isComment(rest) {
+		return nil, fmt.Errorf("%q after the value", strings.TrimSpace(rest))
+	}
+	return []string{value}, nil
+}
+
+// parseTOMLScalar reads a value off the front of s and gives what's left
+func parseTOMLScalar(s string) (string, string, error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		for i := 1; i < len(s); i++ {
+			if s[i] == '\\' {
+				i++
+			} else if s[i] == '"' {
+				value, err := strconv.Unquote(s[:i+1])
+				return value, s[i+1:], err
+			}
+		}
+		return "", "", fmt.Errorf("unterminated string")
+	case strings.HasPrefix(s, "'"):
+		end := strings.IndexByte(s[1:], '\'')
+		if end < 0 {
+			return "", "", fmt.Errorf("unterminated string")
+		}
+		return s[1 : end+1], s[end+2:], nil
+	}
+	end := strings.IndexAny(s, " \t,]#")
+	if end < 0 {
+		end = len(s)
+	}
+	value := s[:end]
+	if value == "" {
+		return "", "", fmt.Errorf("missing value")
+	}
+	if value != "true" && value != "false" {
+		if _, err := strconv.ParseInt(strings.ReplaceAll(value, "_", ""), 0, 64); err != nil {
+			return "", "", fmt.Errorf("%q isn't a string, number or boolean", value)
+		}
+		value = strings.ReplaceAll(value, "_", "")
+	}
+	return value, s[end:], nil
+}
+
+func isComment(s string) bool {
+	s = strings.TrimSpace(s)
+	return s == "" || s[0] == '#'
+}
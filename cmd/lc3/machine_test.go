@@ -0,0 +1,90 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestParseTOMLValue(t *testing.T) {
+	for _, c := range []struct {
+		in   string
+		want []string
+		err  bool
+	}{
+		{in: `"disk.img"`, want: []string{"disk.img"}},
+		{in: `"a \"b\" \\ c"`, want: []string{`a "b" \ c`}},
+		{in: `'C:\lc3\disk.img'`, want: []string{`C:\lc3\disk.img`}},
+		{in: `8000`, want: []string{"8000"}},
+		{in: `1_000_000`, want: []string{"1000000"}},
+		{in: `0xFE08`, want: []string{"0xFE08"}},
+		{in: `-3`, want: []string{"-3"}},
+		{in: `true`, want: []string{"true"}},
+		{in: `"x" # the rest is a comment`, want: []string{"x"}},
+		{in: `"a # not a comment"`, want: []string{"a # not a comment"}},
+		{in: `["cmd:./uart.py", 'cmd:./leds.py']`, want: []string{"cmd:./uart.py", "cmd:./leds.py"}},
+		{in: `[ 1 , 2,3 ] # trailing`, want: []string{"1", "2", "3"}},
+		{in: `[]`, want: nil},
+		{in: `["a",]`, want: []string{"a"}},
+		{in: `"unterminated`, err: true},
+		{in: `'unterminated`, err: true},
+		{in: ``, err: true},
+		{in: `disk.img`, err: true},
+		{in: `"a" "b"`, err: true},
+		{in: `["a" "b"]`, err: true},
+		{in: `["a"] x`, err: true},
+		{in: `["a", yes]`, err: true},
+	} {
+		got, err := parseTOMLValue(c.in)
+		if (err != nil) != c.err || !slices.Equal(got, c.want) {
+			t.Errorf("parseTOMLValue(%s) = %q, %v", c.in, got, err)
+		}
+	}
+}
+
+func TestParseTOMLScalar(t *testing.T) {
+	for _, c := range []struct {
+		in, value, rest string
+		err             bool
+	}{
+		{in: `"a", "b"]`, value: "a", rest: `, "b"]`},
+		{in: `'a'b`, value: "a", rest: "b"},
+		{in: `12]`, value: "12", rest: "]"},
+		{in: `false # off`, value: "false", rest: " # off"},
+		{in: `"\q"`, err: true},
+		{in: `,`, err: true},
+		{in: `nope`, err: true},
+	} {
+		value, rest, err := parseTOMLScalar(c.in)
+		if (err != nil) != c.err || !c.err && (value != c.value || rest != c.rest) {
+			t.Errorf("parseTOMLScalar(%s) = %q, %q, %v", c.in, value, rest, err)
+		}
+	}
+}
+
+func TestLoadMachine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "board.toml")
+	board := "# a board\nswitches = \"x00FF\"\n\n[timer]\naddress = \"xFEC0\" # out of the way\n"
+	if err := os.WriteFile(path, []byte(board), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		flag.Set("switches", "")
+		flag.Set("timer-address", "")
+	})
+	if err := loadMachine(path); err != nil {
+		t.Fatal(err)
+	}
+	if *deviceAddressFlags["timer"] != "xFEC0" || flag.Lookup("switches").Value.String() != "x00FF" {
+		t.Errorf("-timer-address %q, -switches %q", *deviceAddressFlags["timer"], flag.Lookup("switches").Value)
+	}
+
+	if err := os.WriteFile(path, []byte("[radio]\naddress = \"xFEC0\"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadMachine(path); err == nil {
+		t.Error("a table for a device that isn't there was taken")
+	}
+}
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+
+	"github.com/eiannone/keyboard"
+
+	"lc3/vm"
+)
+
+var (
+	verboseFlag      = flag.Bool("v", false, "print what got loaded where")
+	machineFlag      = flag.String("machine", "", "machine description (TOML) setting these flags, e.g. disk = \"disk.img\", or rate = 8000 under [pcm] for -pcm-rate; flags given here win")
+	allowOverlapFlag = flag.Bool("allow-overlap", false, "let images overlap each other and the system areas")
+
+	memSizeFlag     = flag.Int("mem-size", vm.MEMORY_MAX, "words of RAM from x0000 up; the device registers at xFE00-xFFFF are always there")
+	startPCFlag     = flag.String("start-pc", "x3000", "where programs start running, unless a boot ROM says otherwise")
+	banksFlag       = flag.Int("banks", 0, "banks of 4K words behind the -bank-window, picked with the bank register at xFE14 (0 = no banking, 256 = 1M words)")
+	bankWindowFlag  = flag.String("bank-window", "x8000", "the 4K window the selected bank shows through")
+	userFlag        = flag.Bool("user", false, "start programs in user mode rather than supervisor mode")
+	sspFlag         = flag.String("ssp", "x3000", "where the supervisor stack starts, growing down: interrupts, exceptions and -trap-mode spec traps from user mode switch R6 to it")
+	sspLimitFlag    = flag.String("ssp-limit", "x0200", "the lowest address the supervisor stack may grow down to, past it is an overflow")
+	sspOverflowFlag = flag.String("ssp-overflow", "fault", "what a supervisor stack overflow does: fault (stop with an error), or exception (the stack starts over and the routine at x0103 runs)")
+	maxInstrFlag    = flag.Uint64("max-instructions", 0, "stop with an error after this many instructions, for programs that may never halt (0 = no limit)")
+
+	romFlag          = flag.String("rom", "", "boot ROM image (raw big endian words, no origin header); its first word is the reset vector the machine starts at")
+	romAddrFlag      = flag.String("rom-addr", "xF000", "address the boot ROM is mapped at")
+	nvramFlag        = flag.String("nvram", "", "host file backing the non-volatile memory region, loaded at start and saved at halt")
+	nvramRegionFlag  = flag.String("nvram-region", "xE000-xEFFF", "address range kept in the -nvram file")
+	serialFlag       = flag.String("serial", "", "listen on this TCP address (e.g. :7000) for a serial terminal, the port is at xFE40")
+	serial2Flag      = flag.String("serial2", "", "second serial port, at xFE48: 'pty' for a new pseudo terminal, the path of a terminal device, or 'in,out' named pipes")
+	beepCmdFlag      = flag.String("beep-cmd", "", "host command playing the beeper's tones, with {hz}, {ms} and {sec} filled in, e.g. 'play -q -n synth {sec} sine {hz}'; the terminal bell rings without one")
+	pcmRateFlag      = flag.Int("pcm-rate", 0, "turn on the sample device at xFE58 playing this many samples a second (0 = off)")
+	pcmCmdFlag       = flag.String("pcm-cmd", "", "host command the -pcm-rate samples are piped to, signed 16 bit big endian, e.g. 'aplay -q -t raw -f S16_BE -c 1 -r 8000'")
+	nicFlag          = flag.String("nic", "", "attach the network adapter at xFE70 to UDP: listen,peer addresses, e.g. :9000,otherhost:9000")
+	printerFlag      = flag.String("printer", "", "attach the line printer at xFEB0 to this host file, characters are appended to it")
+	printerDelayFlag = flag.Int("printer-delay", 0, "instructions the printer stays busy after each character")
+	diskFlag         = flag.String("disk", "", "host file backing the block device at xFE30, 512 byte sectors; created if it's missing")
+
+	protectFlag        = flag.String("protect", "", "comma separated read-only regions: 'code', 'traps' or ranges like x3000-x30FF")
+	protectActionFlag  = flag.String("protect-action", "fault", "what a store to a -protect region or the -rom does: fault (the access control violation exception, when x0102 has a routine) or ignore")
+	stackFlag          = flag.String("stack", "", "stack region start-end; R6 leaving it is reported (an empty stack has R6 = end+1)")
+	heapFlag           = flag.String("heap", "", "heap region start-end managed by the MALLOC/FREE traps, below the device page; MALLOC returns xFFFF when nothing fits")
+	heapReportFlag     = flag.Bool("heap-report", false, "print the heap layout when the program halts")
+	extFlag            = flag.String("ext", "", "comma separated instruction set extensions: muldiv, shift")
+	prioritiesFlag     = flag.String("priorities", "", "comma separated priorities for device interrupts, e.g. keyboard=6,sensor=2 (keyboard PL4, mailbox, nic and sensor PL3, watchdog PL7 by default)")
+	trapModeFlag       = flag.String("trap-mode", "classic", "how TRAP works: classic (return address in R7) spec (PSR and PC pushed on the supervisor stack, the routine in the trap vector table returns with RTI) or table (classic, but vectors set in the trap vector table jump there)")
+	unhandledFlag      = flag.String("unhandled", "fault", "what an exception with no routine in the vector table does: fault (stop with an error) or halt")
+	watchdogFlag       = flag.Int("watchdog", 0, "instructions the program may run without writing to the watchdog register before it fires (0 = off)")
+	watchdogActionFlag = flag.String("watchdog-action", "halt", "what a fired watchdog does: halt, reset, or interrupt (the routine at x0185 runs at PL7)")
+	watchFlag          = flag.String("watch", "", "comma separated addresses or ranges to log every change of, without stopping")
+	historyFlag        = flag.Int("history", 16, "how many recently executed instructions to keep for fault reports (0 = none)")
+	smcFlag            = flag.String("smc", "off", "what to do when a program writes over code it already ran: off, warn (once per address), log (every time) or stop")
+	encodingFlag       = flag.String("encoding", "latin1", "how output characters above 127 reach the terminal: ascii (raw bytes), latin1 or cp437")
+	gamepadFlag        = flag.Bool("gamepad", false, "the arrow keys, z, x, enter and tab work the gamepad at xFE60 instead of typing")
+	switchesFlag       = flag.String("switches", "", "turn on the board: the toggle switches at xFE90 start out as this word (e.g. x00FF), the LEDs at xFE92 and seven-segment display at xFE94-xFE9E are drawn at the top right")
+	gpioInFlag         = flag.String("gpio-in", "", "turn on the GPIO port: the input pins at xFEA8 follow this CSV script of ms,pins lines, e.g. 250,x0001")
+	gpioOutFlag        = flag.String("gpio-out", "", "turn on the GPIO port: log every change to the output pins at xFEAA to this file, as ms,pins lines")
+	sensorFlag         = flag.String("sensor", "", "turn on the sensor at xFEB8, its reading following a waveform like sine:150,300,10000 (also square, triangle, saw: min,max,ms) or a trace file of ms,value lines")
+	keymapFlag         = flag.String("keymap", "", "comma separated input remaps from=to, e.g. enter=x0A,backspace=x08,ctrl-q=halt")
+	transcriptFlag     = flag.String("transcript", "", "record every console input and output event, with timestamps, to this file (JSON lines)")
+	traceFlag          = flag.String("trace", "", "write every executed instruction, disassembled, and the registers it changed to this file")
+
+	coverageFlag = flag.String("coverage", "", "write how often each address was executed to this file at halt")
+	usageFlag    = flag.Bool("usage", false, "report how many words were read, written and executed, by region, when the program halts")
+	profileFlag  = flag.String("profile", "", "write a JSR/RET based profile in folded stack format (for flamegraph.pl and friends) to this file at halt")
+	statsFlag    = flag.Bool("stats", false, "print a JSON summary of the run to stderr at exit")
+	statusFlag   = flag.Bool("status", false, "keep a live status line (instructions, speed, PC, instructions left) on stderr while running")
+	videoFlag    = flag.String("video", "", "show memory from this address as a character grid on the terminal, e.g. xC000 or xC000,40x12 (80x24 by default); a cell's low byte is the character, bits 8-11 and 12-15 its colours")
+	displayFlag  = flag.Bool("display", false, "show the 128x124 pixel framebuffer at xC000 (RGB555, a word per pixel) on the terminal, which needs true colour and 128x62 characters")
+	windowFlag   = flag.Bool("display-window", false, "show the -display framebuffer in an X11 window, 4 screen pixels to a pixel, instead of on the terminal; needs lc3 built with -tags x11")
+	videoFPSFlag = flag.Int("video-fps", 30, "how many times a second -video or -display may redraw")
+	snapshotFlag = flag.String("snapshot", "", "save the machine state to this file when it stops")
+	resumeFlag   = flag.String("resume", "", "carry on from the machine state saved in this file by -snapshot")
+	exitCodeFlag = flag.Bool("exit-code", false, "exit with the low byte of R0 when the program halts, for scripts checking how it went")
+
+	deterministicFlag = flag.Bool("deterministic", false, "make runs repeatable: input is read as the program asks for it, never from the live keyboard, and randomness is seeded with -seed")
+	debugFlag         = flag.Bool("debug", false, "run under the debugger, which reads its commands from stdin; type help at its prompt for the list")
+	seedFlag          = flag.Int64("seed", 1, "seed for the random number device, which is seeded from the clock unless this or -deterministic is given")
+)
+
+type deviceList []string
+
+func (d *deviceList) String() string     { return strings.Join(*d, ", ") }
+func (d *deviceList) Set(s string) error { *d = append(*d, s); return nil }
+
+var deviceFlags deviceList
+
+// -timer-address and the like, one for each built-in device, by name
+var deviceAddressFlags = map[string]*string{}
+
+func init() {
+	flag.Var(&deviceFlags, "device", "attach an external device process, 'cmd:program args...' (repeatable)")
+	for _, name := range vm.DeviceNames() {
+		deviceAddressFlags[name] = flag.String(name+"-address", "", "move the "+name+" registers to start at this address in the device page")
+	}
+}
+
+// main function
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "cov":
+			os.Exit(covCommand(os.Args[2:]))
+		case "watch":
+			os.Exit(watchCommand(os.Args[2:]))
+		case "lsp":
+			os.Exit(lspCommand())
+		case "fmt":
+			os.Exit(fmtCommand(os.Args[2:]))
+		case "asm":
+			os.Exit(asmCommand(os.Args[2:]))
+		case "serve":
+			os.Exit(serveCommand(os.Args[2:]))
+		}
+	}
+
+	// stop cleanly, putting the terminal back, when asked to. 'lc3 watch' relies on this
+	ctx, cancel := signal.NotifyContext(context.Background(), terminateSignals...)
+	defer cancel()
+
+	flag.Usage = func() {
+		fmt.Println("lc3 [flags] [image-file1[@xADDR]] ... [-- program arguments]")
+		fmt.Println(covUsage)
+		fmt.Println(watchUsage)
+		fmt.Println("lc3 lsp")
+		fmt.Println(fmtUsage)
+		fmt.Println(asmUsage)
+		fmt.Println(serveUsage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *machineFlag != "" {
+		if err := loadMachine(*machineFlag); err != nil {
+			log.Fatalf("-machine: %v", err)
+		}
+	}
+
+	args := flag.Args()
+	var programArgs []string // after --, for the program itself
+	if i := slices.Index(args, "--"); i >= 0 {
+		args, programArgs = args[:i], args[i+1:]
+	}
+	if len(args) < 1 && *romFlag == "" && *resumeFlag == "" {
+		// show usage string
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// with input piped in, a deterministic run or the debugger, the program reads stdin instead of the keyboard
+	var keys <-chan keyboard.KeyEvent
+	if stdin, err := os.Stdin.Stat(); err == nil && stdin.Mode()&os.ModeCharDevice != 0 && !*deterministicFlag && !*debugFlag {
+		var err error
+		if keys, err = keyboard.GetKeys(10); err != nil {
+			log.Fatal(err)
+		}
+		defer keyboard.Close()
+	}
+
+	startPC, err := vm.ParseAddr(*startPCFlag)
+	if err != nil {
+		log.Fatalf("-start-pc: %v", err)
+	}
+	opts := []vm.Option{vm.WithMaxInstructions(*maxInstrFlag)}
+	if *traceFlag != "" {
+		trace, err := os.Create(*traceFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer trace.Close()
+		opts = append(opts, vm.WithTrace(trace))
+	}
+	machine, err := vm.NewWithConfig(vm.Config{MemorySize: *memSizeFlag, StartPC: startPC}, opts...)
+	if err != nil {
+		log.Fatalf("-mem-size: %v", err)
+	}
+	machine.Keys = keys
+
+	window, err := vm.ParseAddr(*bankWindowFlag)
+	if err != nil {
+		log.Fatalf("-bank-window: %v", err)
+	}
+	if err := machine.SetupBanks(window, *banksFlag); err != nil {
+		log.Fatalf("-banks: %v", err)
+	}
+
+	machine.SetupArgs(programArgs)
+
+	// plain images go at their own origin, relocatable objects are linked once they're all known
+	var objects []vm.ObjectLoad
+	symbols := map[string]uint16{}
+	for i := 0; i < len(args); i++ {
+		path, at := args[i], -1
+		if i := strings.LastIndex(path, "@"); i >= 0 {
+			origin, err := vm.ParseAddr(path[i+1:])
+			if err != nil {
+				log.Fatalf("%s: %v", path, err)
+			}
+			path, at = path[:i], int(origin)
+		}
+		object, err := vm.ReadObjectFile(path)
+		switch {
+		case err == nil:
+			objects = append(objects, vm.ObjectLoad{Path: path, Object: object, At: at})
+			continue
+		case !errors.Is(err, vm.ErrNotObject):
+			fmt.Printf("failed to load image: %v", err)
+			os.Exit(1)
+		case at >= 0:
+			log.Fatalf("%s isn't relocatable, it can only go at its own origin", path)
+		}
+		if err := machine.LoadFile(path); err != nil {
+			fmt.Printf("failed to load image: %v", err)
+			os.Exit(1)
+		}
+		maps.Copy(symbols, readSymbolFile(path))
+	}
+	if len(objects) > 0 {
+		linked, err := machine.LoadObjects(objects)
+		if err != nil {
+			fmt.Printf("failed to link: %v", err)
+			os.Exit(1)
+		}
+		maps.Copy(symbols, linked)
+	}
+
+	if *romFlag != "" {
+		origin, err := vm.ParseAddr(*romAddrFlag)
+		if err != nil {
+			log.Fatalf("-rom-addr: %v", err)
+		}
+		if err := machine.LoadROM(*romFlag, origin); err != nil {
+			log.Fatalf("-rom: %v", err)
+		}
+	}
+	if err := machine.LoadNVRAM(*nvramFlag, *nvramRegionFlag); err != nil {
+		log.Fatalf("-nvram: %v", err)
+	}
+
+	if *verboseFlag {
+		machine.PrintSegments(os.Stderr)
+	}
+	if !*allowOverlapFlag {
+		if err := machine.CheckOverlaps(); err != nil {
+			log.Fatalf("%v (use -allow-overlap if that's intended)", err)
+		}
+	}
+
+	if err := machine.SetupProtection(*protectFlag); err != nil {
+		log.Fatalf("-protect: %v", err)
+	}
+	if err := machine.SetupProtectionAction(*protectActionFlag); err != nil {
+		log.Fatalf("-protect-action: %v", err)
+	}
+	if err := machine.SetupStack(*stackFlag); err != nil {
+		log.Fatalf("-stack: %v", err)
+	}
+	if err := machine.SetupHeap(*heapFlag); err != nil {
+		log.Fatalf("-heap: %v", err)
+	}
+	if err := machine.SetupExtensions(*extFlag); err != nil {
+		log.Fatalf("-ext: %v", err)
+	}
+	if err := machine.SetupPriorities(*prioritiesFlag); err != nil {
+		log.Fatalf("-priorities: %v", err)
+	}
+	if err := machine.SetupTrapMode(*trapModeFlag); err != nil {
+		log.Fatalf("-trap-mode: %v", err)
+	}
+	if err := machine.SetupUnhandled(*unhandledFlag); err != nil {
+		log.Fatalf("-unhandled: %v", err)
+	}
+	if err := machine.SetupWatchdog(*watchdogFlag, *watchdogActionFlag); err != nil {
+		log.Fatalf("-watchdog-action: %v", err)
+	}
+	if err := machine.SetupWatches(*watchFlag); err != nil {
+		log.Fatalf("-watch: %v", err)
+	}
+	machine.SetupHistory(*historyFlag)
+	if err := machine.SetupSMC(*smcFlag); err != nil {
+		log.Fatalf("-smc: %v", err)
+	}
+	if err := machine.SetupEncoding(*encodingFlag); err != nil {
+		log.Fatalf("-encoding: %v", err)
+	}
+	machine.SetupUserMode(*userFlag)
+	ssp, err := vm.ParseAddr(*sspFlag)
+	if err != nil {
+		log.Fatalf("-ssp: %v", err)
+	}
+	machine.SetupSupervisorStack(ssp)
+	sspLimit, err := vm.ParseAddr(*sspLimitFlag)
+	if err != nil {
+		log.Fatalf("-ssp-limit: %v", err)
+	}
+	if err := machine.SetupStackOverflow(sspLimit, *sspOverflowFlag); err != nil {
+		log.Fatalf("-ssp-overflow: %v", err)
+	}
+	machine.SetupGamepad(*gamepadFlag)
+	if err := machine.SetupPrinter(*printerFlag, *printerDelayFlag); err != nil {
+		log.Fatalf("-printer: %v", err)
+	}
+	if err := machine.SetupSensor(*sensorFlag); err != nil {
+		log.Fatalf("-sensor: %v", err)
+	}
+	if err := machine.SetupGPIO(*gpioInFlag, *gpioOutFlag); err != nil {
+		log.Fatalf("-gpio: %v", err)
+	}
+	if *switchesFlag != "" {
+		switches, err := vm.ParseAddr(*switchesFlag)
+		if err != nil {
+			log.Fatalf("-switches: %v", err)
+		}
+		machine.SetupBoard(true, switches)
+	}
+	if err := machine.SetupKeymap(*keymapFlag); err != nil {
+		log.Fatalf("-keymap: %v", err)
+	}
+	if err := machine.SetupTranscript(*transcriptFlag); err != nil {
+		log.Fatal(err)
+	}
+	machine.SetupBeeper(*beepCmdFlag)
+	if err := machine.SetupPCM(*pcmRateFlag, *pcmCmdFlag); err != nil {
+		log.Fatalf("-pcm-cmd: %v", err)
+	}
+	if err := machine.SetupNIC(*nicFlag); err != nil {
+		log.Fatalf("-nic: %v", err)
+	}
+	if err := machine.SetupDisk(*diskFlag); err != nil {
+		log.Fatalf("-disk: %v", err)
+	}
+	if err := machine.SetupSerial(*serialFlag); err != nil {
+		log.Fatalf("-serial: %v", err)
+	}
+	if path, err := machine.SetupSerialFile(*serial2Flag); err != nil {
+		log.Fatalf("-serial2: %v", err)
+	} else if *serial2Flag == "pty" {
+		fmt.Fprintf(os.Stderr, "serial port xFE48 is on %s\n", path)
+	}
+	if err := machine.SetupDevices(deviceFlags); err != nil {
+		log.Fatalf("-device %v", err)
+	}
+	for _, name := range vm.DeviceNames() {
+		if *deviceAddressFlags[name] == "" {
+			continue
+		}
+		address, err := vm.ParseAddr(*deviceAddressFlags[name])
+		if err == nil {
+			err = machine.SetupDeviceAddress(name, address)
+		}
+		if err != nil {
+			log.Fatalf("-%s-address: %v", name, err)
+		}
+	}
+	machine.SetupCoverage(*coverageFlag != "")
+	machine.SetupUsage(*usageFlag)
+	machine.SetupStatus(*statusFlag)
+	if err := machine.SetupVideo(*videoFlag, *videoFPSFlag); err != nil {
+		log.Fatalf("-video: %v", err)
+	}
+	if err := machine.SetupDisplay(*displayFlag && !*windowFlag, *videoFPSFlag); err != nil {
+		log.Fatalf("-display: %v", err)
+	}
+	if err := machine.SetupDisplayWindow(*windowFlag, *videoFPSFlag); err != nil {
+		log.Fatalf("-display-window: %v", err)
+	}
+	machine.SetupDeterministic(*deterministicFlag, *seedFlag)
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "seed" {
+			machine.SetupSeed(*seedFlag)
+		}
+	})
+
+	if *resumeFlag != "" {
+		data, err := os.ReadFile(*resumeFlag)
+		if err == nil {
+			err = machine.Restore(data)
+		}
+		if err != nil {
+			log.Fatalf("-resume: %v", err)
+		}
+	} else {
+		machine.ResetCPU()
+	}
+	machine.SetupProfile(*profileFlag != "")
+
+	if *debugFlag {
+		debugMachine(ctx, machine, symbols, os.Stdin, os.Stderr)
+		finish(machine)
+		return
+	}
+	if err := machine.Run(ctx); errors.Is(err, context.Canceled) {
+		finish(machine)
+		keyboard.Close()
+		os.Exit(1)
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		if !errors.Is(err, vm.ErrMaxInstructions) { // nothing faulted, there's no culprit to show
+			machine.DumpHistory(os.Stderr)
+		}
+		finish(machine)
+		keyboard.Close()
+		os.Exit(1)
+	}
+	finish(machine)
+	if *exitCodeFlag {
+		keyboard.Close()
+		os.Exit(machine.ExitCode())
+	}
+}
+
+// finish writes out everything that's due when the machine stops, however it stopped
+func finish(machine *vm.VM) {
+	machine.Close()
+	if *statsFlag {
+		out, _ := json.Marshal(machine.Stats())
+		fmt.Fprintln(os.Stderr, string(out))
+	}
+	if *heapReportFlag {
+		machine.PrintHeap(os.Stderr)
+	}
+	if *usageFlag {
+		machine.PrintUsage(os.Stderr)
+	}
+	if *coverageFlag != "" {
+		if err := writeCoverage(*coverageFlag, machine.Coverage()); err != nil {
+			log.Print(err)
+		}
+	}
+	if *profileFlag != "" {
+		if err := machine.WriteProfile(*profileFlag); err != nil {
+			log.Print(err)
+		}
+	}
+	if err := machine.SaveNVRAM(); err != nil {
+		log.Print(err)
+	}
+	if *snapshotFlag != "" {
+		data, err := machine.Snapshot()
+		if err == nil {
+			err = os.WriteFile(*snapshotFlag, data, 0644)
+		}
+		if err != nil {
+			log.Print(err)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"lc3/encode"
+)
+
+// with LC3_MAIN_ARGS set the test binary is lc3 itself, run with those arguments a line each
+func TestMain(m *testing.M) {
+	if args := os.Getenv("LC3_MAIN_ARGS"); args != "" {
+		os.Args = append([]string{"lc3"}, strings.Split(args, "\n")...)
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runLC3 runs lc3 with args and no input, and gives its exit status
+func runLC3(t *testing.T, args ...string) int {
+	t.Helper()
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), "LC3_MAIN_ARGS="+strings.Join(args, "\n"))
+	cmd.Stdin = strings.NewReader("")
+	err := cmd.Run()
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		return exit.ExitCode()
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return 0
+}
+
+func writeImage(t *testing.T, words ...uint16) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "prog.obj")
+	data := binary.BigEndian.AppendUint16(nil, 0x3000)
+	for _, word := range words {
+		data = binary.BigEndian.AppendUint16(data, word)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestExitCode(t *testing.T) {
+	three := writeImage(t, encode.ADD(0, 0, encode.Imm(3)), encode.HALT())
+	high := writeImage(t, encode.LD(0, 1), encode.HALT(), 0x0105)   // only the low byte counts
+	fault := writeImage(t, encode.ADD(0, 0, encode.Imm(3)), 0xD000) // a reserved opcode with no handler
+
+	for _, tt := range []struct {
+		args []string
+		want int
+	}{
+		{[]string{"-exit-code", three}, 3},
+		{[]string{three}, 0},
+		{[]string{"-exit-code", high}, 5},
+		{[]string{"-exit-code", fault}, 1},
+		{[]string{fault}, 1},
+	} {
+		if got := runLC3(t, tt.args...); got != tt.want {
+			t.Errorf("lc3 %s exited %d, want %d", strings.Join(tt.args, " "), got, tt.want)
+		}
+	}
+}
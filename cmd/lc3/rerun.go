@@ -0,0 +1,112 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const watchUsage = `lc3 watch [-build "lc3as {}"] [-interval 500ms] prog.asm [run flags ...]`
+
+// a run of the emulator in a child process
+type childRun struct {
+	cmd  *exec.Cmd
+	done chan struct{}
+}
+
+func startRun(args []string) (*childRun, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(self, args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	run := &childRun{cmd: cmd, done: make(chan struct{})}
+	go func() {
+		err := cmd.Wait()
+		if err != nil {
+			fmt.Printf("== run ended: %v\n", err)
+		} else {
+			fmt.Println("== run ended")
+		}
+		close(run.done)
+	}()
+	return run, nil
+}
+
+// stop asks the run to finish (so it can put the terminal back) and kills it if it won't
+func (r *childRun) stop() {
+	select {
+	case <-r.done:
+		return
+	default:
+	}
+	if err := r.cmd.Process.Signal(terminateSignals[0]); err != nil {
+		r.cmd.Process.Kill()
+	}
+	select {
+	case <-r.done:
+	case <-time.After(2 * time.Second):
+		r.cmd.Process.Kill()
+		<-r.done
+	}
+}
+
+func build(command, source string) error {
+	fields := strings.Fields(strings.ReplaceAll(command, "{}", source))
+	if len(fields) == 0 {
+		return nil
+	}
+	cmd := exec.Command(fields[0], fields[1:]...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	return cmd.Run()
+}
+
+// watchCommand runs 'lc3 watch', rebuilding and rerunning the program every time the source is saved.
+// there's no assembler in here so building is left to an external one
+func watchCommand(args []string) int {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	buildCmd := fs.String("build", "lc3as {}", "command that assembles the source, {} is replaced by its path")
+	interval := fs.Duration("interval", 500*time.Millisecond, "how often to check the source for changes")
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		fmt.Println(watchUsage)
+		return 2
+	}
+
+	source := fs.Arg(0)
+	image := strings.TrimSuffix(source, ".asm") + ".obj"
+	runArgs := append(append([]string{}, fs.Args()[1:]...), image)
+
+	var last time.Time
+	var run *childRun
+	for {
+		info, err := os.Stat(source)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if !info.ModTime().Equal(last) {
+			last = info.ModTime()
+			if run != nil {
+				run.stop()
+				run = nil
+			}
+
+			fmt.Printf("== building %s\n", source)
+			if err := build(*buildCmd, source); err != nil {
+				fmt.Printf("== build failed: %v\n", err)
+			} else if run, err = startRun(runArgs); err != nil {
+				fmt.Printf("== couldn't start %s: %v\n", image, err)
+			}
+		}
+		time.Sleep(*interval)
+	}
+}
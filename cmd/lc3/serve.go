@@ -0,0 +1,395 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"lc3/vm"
+)
+
+const serveUsage = "lc3 serve [-addr :8080] [-workers n] [-queue n] [-max-instructions n] [-timeout d]"
+
+/*
+lc3 serve is a grading backend. a job is a program and the tests to run it through, posted as a
+multipart form:
+
+	curl -F program=@prog.obj -F tests=@tests.json localhost:8080/jobs
+
+the program is an object image or, named *.asm, source for lc3 asm. tests is a JSON array:
+
+	[{"name": "adds", "input": "34", "args": ["-v"], "output": "7\nHALT\n", "registers": {"R0": 7}, "exit_code": 0}]
+
+where everything but the name is optional: input is what the program reads, and reading past
+its end is a fault, args what it finds at xFEA0, and output (all of it, HALT's message too),
+registers and exit_code what the test expects. the reply is the job, 202 while it's queued and
+running, and GET /jobs/{id} gives it again. ?wait=1 on the POST holds the reply until the job is
+done. a full queue is 503.
+
+every test runs on a machine of its own from a pool of -workers goroutines: deterministic, with
+no devices, no host environment for GETENV, output cut off at SERVE_OUTPUT_MAX and the run
+stopped at -max-instructions or -timeout
+*/
+
+const (
+	SERVE_UPLOAD_MAX = 1 << 20  // bytes in a job's form
+	SERVE_OUTPUT_MAX = 64 << 10 // bytes of output kept per test
+	SERVE_TESTS_MAX  = 100      // tests per job
+	SERVE_JOBS_KEPT  = 1000     // finished jobs kept for GET, the oldest are forgotten
+)
+
+type gradeTest struct {
+	Name      string         `json:"name"`
+	Input     string         `json:"input"`
+	Args      []string       `json:"args"`
+	Output    *string        `json:"output"`
+	Registers map[string]int `json:"registers"`
+	ExitCode  *int           `json:"exit_code"`
+}
+
+type gradeResult struct {
+	Name         string   `json:"name"`
+	Passed       bool     `json:"passed"`
+	Failures     []string `json:"failures,omitempty"`
+	Error        string   `json:"error,omitempty"`
+	Output       string   `json:"output"`
+	Truncated    bool     `json:"output_truncated,omitempty"`
+	HaltReason   string   `json:"halt_reason"`
+	Instructions uint64   `json:"instructions"`
+	ExitCode     int      `json:"exit_code"`
+}
+
+type gradeJob struct {
+	ID      string        `json:"id"`
+	Status  string        `json:"status"` // queued, running or done
+	Results []gradeResult `json:"results,omitempty"`
+
+	image []byte
+	tests []gradeTest
+	done  chan struct{}
+}
+
+type gradeServer struct {
+	queue           chan *gradeJob
+	maxInstructions uint64
+	timeout         time.Duration
+
+	mu       sync.Mutex
+	jobs     map[string]*gradeJob
+	finished []string // IDs, oldest first
+	next     int
+}
+
+func newGradeServer(workers, queue int, maxInstructions uint64, timeout time.Duration) *gradeServer {
+	s := &gradeServer{
+		queue:           make(chan *gradeJob, queue),
+		maxInstructions: maxInstructions,
+		timeout:         timeout,
+		jobs:            map[string]*gradeJob{},
+	}
+	for range workers {
+		go s.work()
+	}
+	return s
+}
+
+func (s *gradeServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /jobs", s.submit)
+	mux.HandleFunc("GET /jobs/{id}", s.status)
+	return mux
+}
+
+// reply writes v as the JSON body
+func reply(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func replyError(w http.ResponseWriter, code int, format string, args ...any) {
+	reply(w, code, map[string]string{"error": fmt.Sprintf(format, args...)})
+}
+
+func (s *gradeServer) submit(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, SERVE_UPLOAD_MAX)
+	if err := r.ParseMultipartForm(SERVE_UPLOAD_MAX); err != nil {
+		replyError(w, http.StatusBadRequest, "want a multipart form with program and tests: %v", err)
+		return
+	}
+	job := &gradeJob{Status: "queued", done: make(chan struct{})}
+
+	file, header, err := r.FormFile("program")
+	if err != nil {
+		replyError(w, http.StatusBadRequest, "program: %v", err)
+		return
+	}
+	program, err := io.ReadAll(file)
+	file.Close()
+	if err != nil {
+		replyError(w, http.StatusBadRequest, "program: %v", err)
+		return
+	}
+	if job.image, err = gradeImage(header.Filename, program); err != nil {
+		replyError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	tests := []byte(r.FormValue("tests"))
+	if file, _, err := r.FormFile("tests"); err == nil {
+		tests, err = io.ReadAll(file)
+		file.Close()
+		if err != nil {
+			replyError(w, http.StatusBadRequest, "tests: %v", err)
+			return
+		}
+	}
+	if err := json.Unmarshal(tests, &job.tests); err != nil {
+		replyError(w, http.StatusBadRequest, "tests: want a JSON array of tests: %v", err)
+		return
+	}
+	if len(job.tests) == 0 || len(job.tests) > SERVE_TESTS_MAX {
+		replyError(w, http.StatusBadRequest, "tests: want 1 to %d tests, got %d", SERVE_TESTS_MAX, len(job.tests))
+		return
+	}
+	for _, test := range job.tests {
+		for name := range test.Registers {
+			if _, err := gradeRegister(name); err != nil {
+				replyError(w, http.StatusBadRequest, "test %s: %v", test.Name, err)
+				return
+			}
+		}
+	}
+
+	s.mu.Lock()
+	s.next++
+	job.ID = strconv.Itoa(s.next)
+	select {
+	case s.queue <- job:
+		s.jobs[job.ID] = job
+	default:
+		s.mu.Unlock()
+		replyError(w, http.StatusServiceUnavailable, "the queue is full, try again later")
+		return
+	}
+	s.mu.Unlock()
+
+	if r.URL.Query().Get("wait") != "" {
+		select {
+		case <-job.done:
+		case <-r.Context().Done():
+			return
+		}
+	}
+	s.replyJob(w, job)
+}
+
+func (s *gradeServer) status(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	job := s.jobs[r.PathValue("id")]
+	s.mu.Unlock()
+	if job == nil {
+		replyError(w, http.StatusNotFound, "no job %s", r.PathValue("id"))
+		return
+	}
+	s.replyJob(w, job)
+}
+
+// replyJob sends the job as it stands, 200 once it's done and 202 before
+func (s *gradeServer) replyJob(w http.ResponseWriter, job *gradeJob) {
+	s.mu.Lock()
+	data, _ := json.Marshal(job)
+	status := job.Status
+	s.mu.Unlock()
+	code := http.StatusAccepted
+	if status == "done" {
+		code = http.StatusOK
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(append(data, '\n'))
+}
+
+// gradeImage gives the object image of an uploaded program, assembling it if it's source
+func gradeImage(name string, program []byte) ([]byte, error) {
+	if filepath.Ext(name) != ".asm" {
+		if _, err := vm.ReadObject(program); err == nil {
+			return nil, fmt.Errorf("program: a relocatable object needs linking, upload its source or a plain image")
+		}
+		if err := vm.New().LoadImage(bytes.NewReader(program)); err != nil {
+			return nil, fmt.Errorf("program: %v", err)
+		}
+		return program, nil
+	}
+	var lines []asmLine
+	for _, line := range strings.Split(strings.ReplaceAll(string(program), "\r\n", "\n"), "\n") {
+		lines = append(lines, parseAsmLine(line))
+	}
+	prog, errs := assembleAsm(lines)
+	if len(errs) > 0 {
+		var msgs []string
+		for _, e := range errs {
+			msgs = append(msgs, fmt.Sprintf("%s:%d: %s", name, e.line+1, e.msg))
+		}
+		return nil, errors.New(strings.Join(msgs, "\n"))
+	}
+	if len(prog.externals) > 0 {
+		return nil, fmt.Errorf("%s: .EXTERNAL labels can't be linked here", name)
+	}
+	image := []byte{byte(prog.origin >> 8), byte(prog.origin)}
+	for _, word := range prog.words {
+		image = append(image, byte(word>>8), byte(word))
+	}
+	return image, nil
+}
+
+// gradeRegister reads R0-R7 or PC
+func gradeRegister(name string) (int, error) {
+	if strings.EqualFold(name, "PC") {
+		return vm.R_PC, nil
+	}
+	if r, err := asmReg(name); err == nil {
+		return vm.R_R0 + r, nil
+	}
+	return 0, fmt.Errorf("no register %q, want R0 to R7 or PC", name)
+}
+
+// work runs jobs off the queue, one test at a time
+func (s *gradeServer) work() {
+	for job := range s.queue {
+		s.mu.Lock()
+		job.Status = "running"
+		s.mu.Unlock()
+
+		var results []gradeResult
+		for _, test := range job.tests {
+			results = append(results, s.runTest(job.image, test))
+		}
+
+		s.mu.Lock()
+		job.Status, job.Results = "done", results
+		s.finished = append(s.finished, job.ID)
+		if len(s.finished) > SERVE_JOBS_KEPT {
+			delete(s.jobs, s.finished[0])
+			s.finished = s.finished[1:]
+		}
+		s.mu.Unlock()
+		close(job.done)
+	}
+}
+
+// limitedBuffer keeps the first max bytes written to it and drops the rest
+type limitedBuffer struct {
+	bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.Len(); len(p) > room {
+		b.Buffer.Write(p[:max(room, 0)])
+		b.truncated = true
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+// runTest runs the program through one test on a machine of its own
+func (s *gradeServer) runTest(image []byte, test gradeTest) gradeResult {
+	result := gradeResult{Name: test.Name}
+	out := &limitedBuffer{max: SERVE_OUTPUT_MAX}
+	machine := vm.New(vm.WithMaxInstructions(s.maxInstructions))
+	machine.In = strings.NewReader(test.Input)
+	machine.Out = out
+	machine.RegisterTrap(vm.TRAP_GETENV, func(v *vm.VM) error { // nothing of the host's is set
+		v.SetReg(vm.R_R0, 0xFFFF)
+		v.SetReg(vm.R_COND, vm.FL_NEG)
+		return nil
+	})
+	machine.RegisterTrap(vm.TRAP_TERMSZ, func(v *vm.VM) error {
+		v.SetReg(vm.R_R0, 80)
+		v.SetReg(vm.R_R1, 24)
+		v.SetReg(vm.R_COND, vm.FL_POS)
+		return nil
+	})
+	if err := machine.LoadImage(bytes.NewReader(image)); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	machine.SetupArgs(test.Args)
+	machine.SetupDeterministic(true, 1)
+	machine.ResetCPU()
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	err := machine.Run(ctx)
+	machine.Close()
+	stats := machine.Stats()
+	result.Output, result.Truncated = out.String(), out.truncated
+	result.HaltReason, result.Instructions, result.ExitCode = stats.HaltReason, stats.Instructions, stats.ExitCode
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = fmt.Errorf("ran out of time after %v", s.timeout)
+	}
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	if test.Output != nil && result.Output != *test.Output {
+		result.Failures = append(result.Failures, fmt.Sprintf("output %q, want %q", result.Output, *test.Output))
+	}
+	for name, want := range test.Registers {
+		r, _ := gradeRegister(name)
+		if got := machine.Reg(r); got != uint16(want) {
+			result.Failures = append(result.Failures, fmt.Sprintf("%s is x%04X, want x%04X", strings.ToUpper(name), got, uint16(want)))
+		}
+	}
+	if test.ExitCode != nil && result.ExitCode != *test.ExitCode {
+		result.Failures = append(result.Failures, fmt.Sprintf("exit code %d, want %d", result.ExitCode, *test.ExitCode))
+	}
+	result.Passed = len(result.Failures) == 0
+	return result
+}
+
+// serveCommand runs 'lc3 serve' until it's interrupted
+func serveCommand(args []string) int {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", ":8080", "address to listen on")
+	workers := fs.Int("workers", 4, "tests run at once")
+	queue := fs.Int("queue", 64, "jobs waiting to run before submissions are turned away")
+	maxInstructions := fs.Uint64("max-instructions", 10_000_000, "instructions a test may run")
+	timeout := fs.Duration("timeout", 10*time.Second, "time a test may run")
+	fs.Parse(args)
+	if fs.NArg() != 0 || *workers < 1 || *queue < 1 {
+		fmt.Println(serveUsage)
+		return 2
+	}
+
+	s := newGradeServer(*workers, *queue, *maxInstructions, *timeout)
+	server := &http.Server{Addr: *addr, Handler: s.handler(), ReadHeaderTimeout: 10 * time.Second}
+	ctx, cancel := signal.NotifyContext(context.Background(), append(terminateSignals, os.Interrupt)...)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		server.Shutdown(context.Background())
+	}()
+	log.Printf("grading on %s with %d workers", *addr, *workers)
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		log.Print(err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// the program prints a line of input back with one added to each character, and halts with R0 the count
+const gradeSource = `
+	.ORIG x3000
+	AND R1, R1, #0
+LOOP	GETC
+	ADD R2, R0, #-10
+	BRz DONE
+	ADD R0, R0, #1
+	OUT
+	ADD R1, R1, #1
+	BR LOOP
+DONE	ADD R0, R1, #0
+	HALT
+	.END`
+
+func postJob(t *testing.T, server *httptest.Server, query, name, program, tests string) (int, gradeJob) {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, _ := form.CreateFormFile("program", name)
+	part.Write([]byte(program))
+	form.WriteField("tests", tests)
+	form.Close()
+
+	resp, err := http.Post(server.URL+"/jobs"+query, form.FormDataContentType(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var job gradeJob
+	json.NewDecoder(resp.Body).Decode(&job)
+	return resp.StatusCode, job
+}
+
+func TestServeGrades(t *testing.T) {
+	s := newGradeServer(2, 4, 100_000, 5*time.Second)
+	server := httptest.NewServer(s.handler())
+	defer server.Close()
+
+	tests := `[
+		{"name": "echo", "input": "abc\n", "output": "bcdHALT\n", "registers": {"R0": 3, "r1": 3}, "exit_code": 3},
+		{"name": "wrong", "input": "a\n", "output": "a", "registers": {"R0": 9}},
+		{"name": "empty", "input": "\n", "output": "HALT\n"},
+		{"name": "no line", "input": "a"}
+	]`
+	code, job := postJob(t, server, "?wait=1", "prog.asm", gradeSource, tests)
+	if code != http.StatusOK || job.Status != "done" || len(job.Results) != 4 {
+		t.Fatalf("got %d %+v", code, job)
+	}
+	if r := job.Results[0]; !r.Passed || r.Output != "bcdHALT\n" || r.HaltReason != "halt" || r.ExitCode != 3 {
+		t.Errorf("echo: %+v", r)
+	}
+	if r := job.Results[1]; r.Passed || len(r.Failures) != 2 {
+		t.Errorf("wrong: %+v", r)
+	}
+	if r := job.Results[2]; !r.Passed {
+		t.Errorf("empty: %+v", r)
+	}
+	if r := job.Results[3]; r.Passed || r.Error == "" || r.Output != "b" {
+		t.Errorf("reading past the input: %+v", r)
+	}
+
+	resp, err := http.Get(server.URL + "/jobs/" + job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var again gradeJob
+	json.NewDecoder(resp.Body).Decode(&again)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || again.ID != job.ID || len(again.Results) != 4 {
+		t.Errorf("GET got %d %+v", resp.StatusCode, again)
+	}
+	resp, _ = http.Get(server.URL + "/jobs/nope")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET of an unknown job got %d", resp.StatusCode)
+	}
+}
+
+func TestServeLimits(t *testing.T) {
+	s := newGradeServer(1, 1, 1000, 5*time.Second)
+	server := httptest.NewServer(s.handler())
+	defer server.Close()
+
+	spin := "\t.ORIG x3000\nLOOP\tBR LOOP\n\t.END"
+	code, job := postJob(t, server, "?wait=1", "spin.asm", spin, `[{"name": "spins"}]`)
+	if code != http.StatusOK || job.Results[0].Passed || job.Results[0].Instructions != 1000 {
+		t.Errorf("spin got %d %+v", code, job)
+	}
+
+	for _, bad := range []struct{ name, program, tests string }{
+		{"bad.asm", "\t.ORIG x3000\n\tADD R9, R0, #0\n\t.END", `[{"name": "x"}]`},
+		{"prog.asm", gradeSource, `{"name": "x"}`},
+		{"prog.asm", gradeSource, `[]`},
+		{"prog.asm", gradeSource, `[{"name": "x", "registers": {"R8": 1}}]`},
+		{"prog.obj", "\x30", `[{"name": "x"}]`},
+	} {
+		if code, _ := postJob(t, server, "", bad.name, bad.program, bad.tests); code != http.StatusBadRequest {
+			t.Errorf("%s %q %s got %d", bad.name, bad.program, bad.tests, code)
+		}
+	}
+
+	// with no workers the first waits in the queue and the second is turned away
+	idle := httptest.NewServer(newGradeServer(0, 1, 1000, time.Second).handler())
+	defer idle.Close()
+	if code, job := postJob(t, idle, "", "spin.asm", spin, `[{"name": "spins"}]`); code != http.StatusAccepted || job.Status != "queued" {
+		t.Errorf("queued got %d %+v", code, job)
+	}
+	if code, _ := postJob(t, idle, "", "spin.asm", spin, `[{"name": "spins"}]`); code != http.StatusServiceUnavailable {
+		t.Errorf("a full queue got %d", code)
+	}
+}
@@ -0,0 +1,12 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// windows delivers Ctrl+C and Ctrl+Break as os.Interrupt and closing the console as SIGTERM.
+// neither can be sent to another process, so 'lc3 watch' falls back to killing its run
+var terminateSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
@@ -0,0 +1,167 @@
+// Package decode splits LC-3 instruction words into their fields. the interpreter, Step and
+// anything printing instructions all go through it
+package decode
+
+import "fmt"
+
+// opcodes, the top 4 bits of an instruction
+const (
+	BR   = iota // branch
+	ADD         // add
+	LD          // load
+	ST          // store
+	JSR         // jump to subroutine, JSRR too
+	AND         // bitwise and
+	LDR         // load register
+	STR         // store register
+	RTI         // return from interrupt
+	NOT         // bitwise not
+	LDI         // load indirect
+	STI         // store indirect
+	JMP         // jump, RET too
+	RES         // reserved
+	LEA         // load effective address
+	TRAP        // execute trap
+)
+
+// BR condition bits, the same as the condition flags they're tested against
+const (
+	CC_P = 1 << 0
+	CC_Z = 1 << 1
+	CC_N = 1 << 2
+)
+
+// OpNames are the opcodes as the ISA names them, JSRR and RET have no opcode of their own
+var OpNames = [16]string{"BR", "ADD", "LD", "ST", "JSR", "AND", "LDR", "STR", "RTI", "NOT", "LDI", "STI", "JMP", "RES", "LEA", "TRAP"}
+
+// Instruction is a decoded instruction word. which fields mean anything depends on Op
+type Instruction struct {
+	Word      uint16
+	Op        int    // BR..TRAP
+	DR        int    // destination register, the source register for ST, STI and STR
+	SR1       int    // first source register, the base register for LDR, STR, JMP and JSRR
+	SR2       int    // second source register of ADD and AND when Immediate is false
+	Immediate bool   // ADD and AND take Imm instead of SR2, JSR takes a PC offset instead of a register (JSRR)
+	Imm       int16  // sign extended imm5, offset6, PCoffset9 or PCoffset11
+	CC        uint16 // the CC_* bits BR tests
+	Vector    uint16 // trapvect8
+}
+
+// Decode splits word into its fields
+func Decode(word uint16) Instruction {
+	in := Instruction{Word: word, Op: int(word >> 12)}
+	dr := int(word>>9) & 0x7
+	sr1 := int(word>>6) & 0x7
+	switch in.Op {
+	case ADD, AND:
+		in.DR, in.SR1 = dr, sr1
+		in.Immediate = word&0x20 != 0
+		if in.Immediate {
+			in.Imm = SignExtend(word, 5)
+		} else {
+			in.SR2 = int(word & 0x7)
+		}
+	case NOT:
+		in.DR, in.SR1 = dr, sr1
+	case BR:
+		in.CC = (word >> 9) & 0x7
+		in.Imm = SignExtend(word, 9)
+	case JMP:
+		in.SR1 = sr1
+	case JSR:
+		in.Immediate = word&0x800 != 0
+		if in.Immediate {
+			in.Imm = SignExtend(word, 11)
+		} else {
+			in.SR1 = sr1
+		}
+	case LD, LDI, LEA, ST, STI:
+		in.DR = dr
+		in.Imm = SignExtend(word, 9)
+	case LDR, STR:
+		in.DR, in.SR1 = dr, sr1
+		in.Imm = SignExtend(word, 6)
+	case TRAP:
+		in.Vector = word & 0xFF
+	}
+	return in
+}
+
+// SignExtend widens the low bits of x, two's complement
+func SignExtend(x uint16, bits int) int16 {
+	x &= (1 << bits) - 1
+	if (x>>(bits-1))&1 != 0 {
+		x |= 0xFFFF << bits
+	}
+	return int16(x)
+}
+
+// Mnemonic is the instruction's name in assembly, e.g. "BRnz", "JSRR" or "RET"
+func (in Instruction) Mnemonic() string {
+	switch {
+	case in.Op == BR:
+		name := "BR"
+		if in.CC&CC_N != 0 {
+			name += "n"
+		}
+		if in.CC&CC_Z != 0 {
+			name += "z"
+		}
+		if in.CC&CC_P != 0 {
+			name += "p"
+		}
+		return name
+	case in.Op == JMP && in.SR1 == 7:
+		return "RET"
+	case in.Op == JSR && !in.Immediate:
+		return "JSRR"
+	}
+	return OpNames[in.Op]
+}
+
+// Operands are the instruction's operands in assembly syntax, e.g. "R1", "#-3" or "x25"
+func (in Instruction) Operands() []string {
+	reg := func(r int) string { return fmt.Sprintf("R%d", r) }
+	imm := fmt.Sprintf("#%d", in.Imm)
+	switch in.Op {
+	case ADD, AND:
+		if in.Immediate {
+			return []string{reg(in.DR), reg(in.SR1), imm}
+		}
+		return []string{reg(in.DR), reg(in.SR1), reg(in.SR2)}
+	case NOT:
+		return []string{reg(in.DR), reg(in.SR1)}
+	case BR:
+		return []string{imm}
+	case JMP:
+		if in.SR1 == 7 {
+			return nil
+		}
+		return []string{reg(in.SR1)}
+	case JSR:
+		if in.Immediate {
+			return []string{imm}
+		}
+		return []string{reg(in.SR1)}
+	case LD, LDI, LEA, ST, STI:
+		return []string{reg(in.DR), imm}
+	case LDR, STR:
+		return []string{reg(in.DR), reg(in.SR1), imm}
+	case TRAP:
+		return []string{fmt.Sprintf("x%02X", in.Vector)}
+	}
+	return nil
+}
+
+// String is the instruction as it would be written in assembly, offsets left relative
+func (in Instruction) String() string {
+	s := in.Mnemonic()
+	for i, operand := range in.Operands() {
+		if i == 0 {
+			s += " " + operand
+		} else {
+			s += ", " + operand
+		}
+	}
+	return s
+}
@@ -0,0 +1,95 @@
+package decode_test
+
+import (
+	"testing"
+
+	"lc3/decode"
+	"lc3/encode"
+)
+
+// each opcode's fields, at the ends of their ranges, come back out of the word encode makes of them
+func TestDecodeEncoded(t *testing.T) {
+	tests := []struct {
+		word uint16
+		want decode.Instruction
+	}{
+		{encode.ADD(7, 0, encode.Imm(-16)), decode.Instruction{Op: decode.ADD, DR: 7, SR1: 0, Immediate: true, Imm: -16}},
+		{encode.ADD(0, 7, encode.Imm(15)), decode.Instruction{Op: decode.ADD, DR: 0, SR1: 7, Immediate: true, Imm: 15}},
+		{encode.ADD(1, 2, encode.Reg(3)), decode.Instruction{Op: decode.ADD, DR: 1, SR1: 2, SR2: 3}},
+		{encode.AND(4, 5, encode.Imm(-1)), decode.Instruction{Op: decode.AND, DR: 4, SR1: 5, Immediate: true, Imm: -1}},
+		{encode.AND(6, 6, encode.Reg(7)), decode.Instruction{Op: decode.AND, DR: 6, SR1: 6, SR2: 7}},
+		{encode.NOT(3, 4), decode.Instruction{Op: decode.NOT, DR: 3, SR1: 4}},
+		{encode.BR(decode.CC_N|decode.CC_Z|decode.CC_P, -256), decode.Instruction{Op: decode.BR, CC: 7, Imm: -256}},
+		{encode.BR(decode.CC_P, 255), decode.Instruction{Op: decode.BR, CC: decode.CC_P, Imm: 255}},
+		{encode.BR(0, 0), decode.Instruction{Op: decode.BR}},
+		{encode.JMP(2), decode.Instruction{Op: decode.JMP, SR1: 2}},
+		{encode.RET(), decode.Instruction{Op: decode.JMP, SR1: 7}},
+		{encode.JSR(-1024), decode.Instruction{Op: decode.JSR, Immediate: true, Imm: -1024}},
+		{encode.JSR(1023), decode.Instruction{Op: decode.JSR, Immediate: true, Imm: 1023}},
+		{encode.JSRR(5), decode.Instruction{Op: decode.JSR, SR1: 5}},
+		{encode.LD(1, -256), decode.Instruction{Op: decode.LD, DR: 1, Imm: -256}},
+		{encode.LDI(2, 255), decode.Instruction{Op: decode.LDI, DR: 2, Imm: 255}},
+		{encode.LEA(3, -1), decode.Instruction{Op: decode.LEA, DR: 3, Imm: -1}},
+		{encode.ST(4, 1), decode.Instruction{Op: decode.ST, DR: 4, Imm: 1}},
+		{encode.STI(5, -255), decode.Instruction{Op: decode.STI, DR: 5, Imm: -255}},
+		{encode.LDR(6, 7, -32), decode.Instruction{Op: decode.LDR, DR: 6, SR1: 7, Imm: -32}},
+		{encode.STR(0, 6, 31), decode.Instruction{Op: decode.STR, DR: 0, SR1: 6, Imm: 31}},
+		{encode.RTI(), decode.Instruction{Op: decode.RTI}},
+		{encode.TRAP(0xFF), decode.Instruction{Op: decode.TRAP, Vector: 0xFF}},
+		{encode.HALT(), decode.Instruction{Op: decode.TRAP, Vector: 0x25}},
+	}
+	for _, test := range tests {
+		got := decode.Decode(test.word)
+		test.want.Word = test.word
+		if got != test.want {
+			t.Errorf("x%04X decoded %+v, want %+v", test.word, got, test.want)
+		}
+		if back := encode.Encode(got); back != test.word {
+			t.Errorf("x%04X encoded back as x%04X", test.word, back)
+		}
+	}
+}
+
+// the muldiv and shift extensions live in RES, which keeps its bits for them whole
+func TestExtensionRoundTrip(t *testing.T) {
+	for _, word := range []uint16{
+		0xD000 | 1<<9 | 2<<6 | 0<<3 | 3,  // MUL R1, R2, R3
+		0xD000 | 7<<9 | 7<<6 | 2<<3 | 7,  // MOD R7, R7, R7
+		0xD000 | 1<<9 | 2<<6 | 3<<4 | 15, // RSHFA R1, R2, #15
+		0xDFFF,
+	} {
+		in := decode.Decode(word)
+		if in.Op != decode.RES || in.Mnemonic() != "RES" || in.Operands() != nil {
+			t.Errorf("x%04X decoded %+v, %s", word, in, in)
+		}
+		if back := encode.Encode(in); back != word {
+			t.Errorf("x%04X encoded back as x%04X", word, back)
+		}
+	}
+}
+
+func TestSignExtend(t *testing.T) {
+	tests := []struct {
+		x    uint16
+		bits int
+		want int16
+	}{
+		{0x0F, 5, 15},
+		{0x10, 5, -16},
+		{0x1F, 5, -1},
+		{0xFFE0, 5, 0}, // bits above the field are ignored
+		{0x1F, 6, 31},
+		{0x20, 6, -32},
+		{0xFF, 9, 255},
+		{0x100, 9, -256},
+		{0x1FF, 9, -1},
+		{0x3FF, 11, 1023},
+		{0x400, 11, -1024},
+		{0x8000, 16, -32768},
+	}
+	for _, test := range tests {
+		if got := decode.SignExtend(test.x, test.bits); got != test.want {
+			t.Errorf("SignExtend(x%04X, %d) = %d, want %d", test.x, test.bits, got, test.want)
+		}
+	}
+}
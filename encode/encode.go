@@ -0,0 +1,146 @@
+// Package encode builds LC-3 instruction words, the inverse of decode. it's for tests and
+// code generators putting programs together without hand assembling them:
+//
+//	program := []uint16{
+//		encode.LD(1, 5),
+//		encode.AND(2, 2, encode.Imm(0)),
+//		encode.ADD(2, 2, encode.Reg(1)),
+//		encode.ADD(1, 1, encode.Imm(-1)),
+//		encode.BR(decode.CC_P, -3),
+//		encode.HALT(),
+//	}
+//
+// a register or immediate that doesn't fit its field is a bug in the caller and panics
+package encode
+
+import (
+	"fmt"
+
+	"lc3/decode"
+)
+
+// Encode packs the fields of in that its opcode uses back into a word. Word is ignored, but for
+// RES, whose low 12 bits it keeps: decode has no fields for the muldiv and shift extensions there
+func Encode(in decode.Instruction) uint16 {
+	word := uint16(in.Op) << 12
+	switch in.Op {
+	case decode.RES:
+		word |= in.Word & 0xFFF
+	case decode.ADD, decode.AND:
+		word |= reg(in.DR)<<9 | reg(in.SR1)<<6
+		if in.Immediate {
+			word |= 0x20 | field(in.Imm, 5)
+		} else {
+			word |= reg(in.SR2)
+		}
+	case decode.NOT:
+		word |= reg(in.DR)<<9 | reg(in.SR1)<<6 | 0x3F
+	case decode.BR:
+		word |= in.CC&0x7<<9 | field(in.Imm, 9)
+	case decode.JMP:
+		word |= reg(in.SR1) << 6
+	case decode.JSR:
+		if in.Immediate {
+			word |= 0x800 | field(in.Imm, 11)
+		} else {
+			word |= reg(in.SR1) << 6
+		}
+	case decode.LD, decode.LDI, decode.LEA, decode.ST, decode.STI:
+		word |= reg(in.DR)<<9 | field(in.Imm, 9)
+	case decode.LDR, decode.STR:
+		word |= reg(in.DR)<<9 | reg(in.SR1)<<6 | field(in.Imm, 6)
+	case decode.TRAP:
+		if in.Vector > 0xFF {
+			panic(fmt.Sprintf("encode: trap vector x%X doesn't fit in 8 bits", in.Vector))
+		}
+		word |= in.Vector
+	}
+	return word
+}
+
+func reg(r int) uint16 {
+	if r < 0 || r > 7 {
+		panic(fmt.Sprintf("encode: no register R%d", r))
+	}
+	return uint16(r)
+}
+
+// field is n as a bits wide two's complement field
+func field(n int16, bits int) uint16 {
+	if int(n) < -(1<<(bits-1)) || int(n) >= 1<<(bits-1) {
+		panic(fmt.Sprintf("encode: %d doesn't fit in %d bits", n, bits))
+	}
+	return uint16(n) & (1<<bits - 1)
+}
+
+// Operand is the last operand of ADD and AND, a register or an immediate
+type Operand struct {
+	imm   bool
+	value int
+}
+
+// Reg is a register operand
+func Reg(r int) Operand { return Operand{value: r} }
+
+// Imm is an immediate operand, imm5 so -16..15
+func Imm(n int) Operand { return Operand{imm: true, value: n} }
+
+func arith(op, dr, sr1 int, src Operand) uint16 {
+	in := decode.Instruction{Op: op, DR: dr, SR1: sr1, Immediate: src.imm}
+	if src.imm {
+		in.Imm = int16(src.value)
+	} else {
+		in.SR2 = src.value
+	}
+	return Encode(in)
+}
+
+func ADD(dr, sr1 int, src Operand) uint16 { return arith(decode.ADD, dr, sr1, src) }
+func AND(dr, sr1 int, src Operand) uint16 { return arith(decode.AND, dr, sr1, src) }
+
+func NOT(dr, sr int) uint16 {
+	return Encode(decode.Instruction{Op: decode.NOT, DR: dr, SR1: sr})
+}
+
+// BR branches by offset words from the next instruction if a flag in cc is set, decode.CC_N|CC_Z|CC_P always does
+func BR(cc uint16, offset int) uint16 {
+	return Encode(decode.Instruction{Op: decode.BR, CC: cc, Imm: int16(offset)})
+}
+
+func JMP(r int) uint16 { return Encode(decode.Instruction{Op: decode.JMP, SR1: r}) }
+func RET() uint16      { return JMP(7) }
+func JSRR(r int) uint16 {
+	return Encode(decode.Instruction{Op: decode.JSR, SR1: r})
+}
+func JSR(offset int) uint16 {
+	return Encode(decode.Instruction{Op: decode.JSR, Immediate: true, Imm: int16(offset)})
+}
+
+func pcRelative(op, r, offset int) uint16 {
+	return Encode(decode.Instruction{Op: op, DR: r, Imm: int16(offset)})
+}
+
+func LD(dr, offset int) uint16  { return pcRelative(decode.LD, dr, offset) }
+func LDI(dr, offset int) uint16 { return pcRelative(decode.LDI, dr, offset) }
+func LEA(dr, offset int) uint16 { return pcRelative(decode.LEA, dr, offset) }
+func ST(sr, offset int) uint16  { return pcRelative(decode.ST, sr, offset) }
+func STI(sr, offset int) uint16 { return pcRelative(decode.STI, sr, offset) }
+
+func LDR(dr, base, offset int) uint16 {
+	return Encode(decode.Instruction{Op: decode.LDR, DR: dr, SR1: base, Imm: int16(offset)})
+}
+func STR(sr, base, offset int) uint16 {
+	return Encode(decode.Instruction{Op: decode.STR, DR: sr, SR1: base, Imm: int16(offset)})
+}
+
+func RTI() uint16 { return Encode(decode.Instruction{Op: decode.RTI}) }
+
+func TRAP(vector uint16) uint16 { return Encode(decode.Instruction{Op: decode.TRAP, Vector: vector}) }
+
+// the standard trap routines
+func GETC() uint16  { return TRAP(0x20) }
+func OUT() uint16   { return TRAP(0x21) }
+func PUTS() uint16  { return TRAP(0x22) }
+func IN() uint16    { return TRAP(0x23) }
+func PUTSP() uint16 { return TRAP(0x24) }
+func HALT() uint16  { return TRAP(0x25) }
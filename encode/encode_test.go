@@ -0,0 +1,43 @@
+package encode
+
+import (
+	"testing"
+
+	"lc3/decode"
+)
+
+// every word decodes to fields that encode back to a word with the same fields,
+// only bits the ISA ignores may differ
+func TestRoundTrip(t *testing.T) {
+	for word := 0; word < 1<<16; word++ {
+		in := decode.Decode(uint16(word))
+		back := decode.Decode(Encode(in))
+		back.Word = in.Word
+		if back != in {
+			t.Fatalf("x%04X: decoded %+v, encoded and decoded again %+v", word, in, back)
+		}
+	}
+}
+
+func TestHelpers(t *testing.T) {
+	tests := []struct {
+		word uint16
+		want string
+	}{
+		{ADD(1, 2, Imm(-3)), "ADD R1, R2, #-3"},
+		{AND(0, 0, Reg(7)), "AND R0, R0, R7"},
+		{NOT(4, 5), "NOT R4, R5"},
+		{BR(decode.CC_N|decode.CC_Z, -4), "BRnz #-4"},
+		{JSR(-1024), "JSR #-1024"},
+		{JSRR(3), "JSRR R3"},
+		{RET(), "RET"},
+		{LDR(6, 6, 31), "LDR R6, R6, #31"},
+		{STI(2, -256), "STI R2, #-256"},
+		{HALT(), "TRAP x25"},
+	}
+	for _, test := range tests {
+		if got := decode.Decode(test.word).String(); got != test.want {
+			t.Errorf("x%04X is %q, want %q", test.word, got, test.want)
+		}
+	}
+}
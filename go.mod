@@ -3,6 +3,6 @@ module lc3
 go 1.23.1
 
 require (
-	github.com/eiannone/keyboard v0.0.0-20220611211555-0d226195f203 // indirect
-	golang.org/x/sys v0.0.0-20220520151302-bc2c85ada10a // indirect
+	github.com/eiannone/keyboard v0.0.0-20220611211555-0d226195f203
+	golang.org/x/sys v0.0.0-20220520151302-bc2c85ada10a
 )
@@ -0,0 +1,38 @@
+package vm
+
+const (
+	MR_ARGC = 0xFEA0 // how many arguments the program was given
+	MR_ARGN = 0xFEA2 // writing n picks argument n, from its first character
+	MR_ARGD = 0xFEA4 // the picked argument's next character, 0 past its end
+)
+
+type arguments struct {
+	args   []string
+	argN   int // the argument picked
+	argPos int // the next character of it
+}
+
+// SetupArgs hands the program arguments, one character at a time through xFEA0-xFEA4
+func (v *VM) SetupArgs(args []string) {
+	v.args = args
+	v.argN, v.argPos = 0, 0
+}
+
+func (v *VM) argRead(address uint16) uint16 {
+	switch address {
+	case MR_ARGC:
+		return uint16(len(v.args))
+	case MR_ARGD:
+		if v.argN >= len(v.args) || v.argPos >= len(v.args[v.argN]) {
+			return 0
+		}
+		v.argPos++
+		return uint16(v.args[v.argN][v.argPos-1])
+	}
+	return v.memory[address]
+}
+
+func (v *VM) argWrite(value uint16) {
+	v.memory[MR_ARGN] = value
+	v.argN, v.argPos = int(value), 0
+}
@@ -0,0 +1,53 @@
+package vm
+
+import "lc3/decode"
+
+// how far above R6 Backtrace looks for return addresses, when there's no -stack region to go by
+const BACKTRACE_SCAN = 256
+
+// Frame is one subroutine in a backtrace
+type Frame struct {
+	PC      uint16 // where it's at: the PC in the innermost frame, the return address into it in the rest
+	Routine uint16 // its first instruction as the JSR into it says, 0 when it's not known
+	Slot    uint16 // where on the stack the return address was found, 0 for the PC and R7
+}
+
+// Backtrace works out the subroutine calls that led to the PC under the R6 calling convention,
+// the one the CALL pseudo-op of lc3 asm follows: a subroutine's own return address is in R7 and
+// every caller's is on the stack, where CALL saves R7 before its JSR. a word counts as a return
+// address when the instruction before the one it points at is a JSR or JSRR, so data on the stack
+// that happens to look like one shows up too. the innermost frame comes first
+func (v *VM) Backtrace() []Frame {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	frames := []Frame{{PC: v.reg[R_PC]}}
+	if v.returnAddress(v.reg[R_R7]) {
+		frames = append(frames, Frame{PC: v.reg[R_R7]})
+	}
+	top := int(v.reg[R_R6]) + BACKTRACE_SCAN
+	if v.stackChecked {
+		top = v.stackBase
+	}
+	for slot := int(v.reg[R_R6]); slot < top && slot < DEVICE_START; slot++ {
+		word := v.memory[slot]
+		if slot == int(v.reg[R_R6]) && word == v.reg[R_R7] {
+			continue // CALL just put it back in R7
+		}
+		if v.returnAddress(word) {
+			frames = append(frames, Frame{PC: word, Slot: uint16(slot)})
+		}
+	}
+	// each frame's routine is where the call out of the frame above it went
+	for i := 1; i < len(frames); i++ {
+		if in := decode.Decode(v.memory[frames[i].PC-1]); in.Immediate {
+			frames[i-1].Routine = frames[i].PC + uint16(in.Imm)
+		}
+	}
+	return frames
+}
+
+// returnAddress says whether address follows a JSR or JSRR
+func (v *VM) returnAddress(address uint16) bool {
+	return address != 0 && v.mapped(address-1) && decode.Decode(v.memory[address-1]).Op == decode.JSR
+}
@@ -0,0 +1,48 @@
+package vm
+
+import "fmt"
+
+const (
+	MR_BANK = 0xFE14 // bank select, which bank of the backing store shows through the window
+
+	BANK_WORDS = 0x1000 // a bank, and the window, is 4K words
+)
+
+type banking struct {
+	bankWindow  uint16   // where the window starts
+	bankCount   int      // 0 when there's no banking
+	bankCurrent int      // the bank in the window now
+	bankStore   []uint16 // every bank, the current one's copy is stale while it's in the window
+}
+
+// SetupBanks maps the 4K window at window onto a backing store of count banks, count*4K words
+// (256 banks is 1M words). the program picks the bank the window shows by writing its number to
+// the bank register at xFE14, and starts out with bank 0
+func (v *VM) SetupBanks(window uint16, count int) error {
+	if count == 0 {
+		return nil
+	}
+	if count < 0 || count > 0x10000 {
+		return fmt.Errorf("want between 1 and 65536 banks, got %d", count)
+	}
+	if window%BANK_WORDS != 0 || int(window)+BANK_WORDS > DEVICE_START {
+		return fmt.Errorf("the window at x%04X has to be on a 4K boundary and below the device page", window)
+	}
+	if err := v.checkMapped(window, BANK_WORDS); err != nil {
+		return err
+	}
+	v.bankWindow, v.bankCount, v.bankCurrent = window, count, 0
+	v.bankStore = make([]uint16, count*BANK_WORDS)
+	return nil
+}
+
+// switchBank swaps the window's contents for those of another bank
+func (v *VM) switchBank(bank uint16) {
+	if int(bank) >= v.bankCount {
+		v.fault("bank %d selected, there are %d (PC=0x%04X)", bank, v.bankCount, v.reg[R_PC]-1)
+	}
+	window := v.memory[v.bankWindow : int(v.bankWindow)+BANK_WORDS]
+	copy(v.bankStore[v.bankCurrent*BANK_WORDS:], window)
+	copy(window, v.bankStore[int(bank)*BANK_WORDS:])
+	v.bankCurrent = int(bank)
+}
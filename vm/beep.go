@@ -0,0 +1,48 @@
+package vm
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+)
+
+const (
+	MR_BEEPF = 0xFE50 // beeper frequency in Hz
+	MR_BEEPD = 0xFE52 // beeper duration in milliseconds, writing it starts the tone
+)
+
+type beeper struct {
+	beepCommand string
+}
+
+// SetupBeeper sets the host command that plays a tone, with {hz}, {ms} and {sec} filled in, e.g.
+// "play -q -n synth {sec} sine {hz}" for sox or "beep -f {hz} -l {ms}". without one the terminal
+// bell rings instead
+func (v *VM) SetupBeeper(command string) {
+	v.beepCommand = command
+}
+
+// beep plays a tone in the background, the program carries on while it sounds
+func (v *VM) beep(ms uint16) {
+	hz := v.memory[MR_BEEPF]
+	if ms == 0 || hz == 0 {
+		return
+	}
+	if v.beepCommand == "" {
+		fmt.Fprint(v.Out, "\a")
+		return
+	}
+	command := strings.NewReplacer(
+		"{hz}", fmt.Sprint(hz),
+		"{ms}", fmt.Sprint(ms),
+		"{sec}", fmt.Sprintf("%.3f", float64(ms)/1000),
+	).Replace(v.beepCommand)
+	args := strings.Fields(command)
+	cmd := exec.Command(args[0], args[1:]...)
+	if err := cmd.Start(); err != nil {
+		log.Printf("beep: %v", err)
+		return
+	}
+	go cmd.Wait()
+}
@@ -0,0 +1,124 @@
+package vm
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	MR_SW  = 0xFE90 // the 16 toggle switches, one bit each, set from the host
+	MR_LED = 0xFE92 // the 16 LEDs, one bit each, lit by the program
+
+	// the 4 digit seven-segment display
+	MR_SEGM = 0xFE94 // mode
+	MR_SEGD = 0xFE96 // the digits in BCD mode, a nibble each, the leftmost in the high nibble
+	MR_SEG0 = 0xFE98 // the segments of each digit in raw mode, left to right
+	MR_SEG1 = 0xFE9A
+	MR_SEG2 = 0xFE9C
+	MR_SEG3 = 0xFE9E
+
+	SEGM_RAW = 1 << 0 // the digits are drawn from SEG0-SEG3 rather than SEGD
+
+	// a digit's segments in raw mode
+	SEG_A  = 1 << 0 // top
+	SEG_B  = 1 << 1 // top right
+	SEG_C  = 1 << 2 // bottom right
+	SEG_D  = 1 << 3 // bottom
+	SEG_E  = 1 << 4 // bottom left
+	SEG_F  = 1 << 5 // top left
+	SEG_G  = 1 << 6 // middle
+	SEG_DP = 1 << 7 // decimal point
+)
+
+// the segments lit for each BCD digit, past 9 it goes on in hex
+var segDigits = [16]uint16{0x3F, 0x06, 0x5B, 0x4F, 0x66, 0x6D, 0x7D, 0x07, 0x7F, 0x6F, 0x77, 0x7C, 0x39, 0x5E, 0x79, 0x71}
+
+type board struct {
+	boardOn    bool
+	switches   atomic.Uint32 // set from any goroutine
+	boardDrawn string        // what's on the terminal
+}
+
+// SetupBoard turns on the switches at xFE90, starting out as switches, the LEDs at xFE92 and the
+// seven-segment display at xFE94-xFE9E. the LEDs and digits are drawn at the top right of the
+// terminal whenever the program changes them
+func (v *VM) SetupBoard(on bool, switches uint16) {
+	v.boardOn = on
+	v.switches.Store(uint32(switches))
+}
+
+// SetSwitches flips the switches, it's safe to call while the machine runs
+func (v *VM) SetSwitches(switches uint16) {
+	v.switches.Store(uint32(switches))
+}
+
+// LEDs gives the LEDs the program last lit
+func (v *VM) LEDs() uint16 {
+	return v.memory[MR_LED]
+}
+
+// Segments gives the segments lit on each digit of the seven-segment display, left to right
+func (v *VM) Segments() [4]uint16 {
+	var digits [4]uint16
+	for i := range digits {
+		if v.memory[MR_SEGM]&SEGM_RAW != 0 {
+			digits[i] = v.memory[MR_SEG0+2*i] & 0xFF
+		} else {
+			digits[i] = segDigits[v.memory[MR_SEGD]>>(12-4*i)&0xF]
+		}
+	}
+	return digits
+}
+
+func (v *VM) boardWrite(address, value uint16) {
+	v.memory[address] = value
+	v.drawBoard()
+}
+
+// drawBoard puts the digits and LEDs on stderr when they change, out of the program's way at the top
+// right when it's a terminal and on a line of their own when it isn't
+func (v *VM) drawBoard() {
+	var rows [3]strings.Builder
+	for _, s := range v.Segments() {
+		lit := func(segment uint16, on string) string {
+			if s&segment != 0 {
+				return on
+			}
+			return " "
+		}
+		rows[0].WriteString(" " + lit(SEG_A, "_") + "  ")
+		rows[1].WriteString(lit(SEG_F, "|") + lit(SEG_G, "_") + lit(SEG_B, "|") + " ")
+		rows[2].WriteString(lit(SEG_E, "|") + lit(SEG_D, "_") + lit(SEG_C, "|") + lit(SEG_DP, "."))
+	}
+	rows[0].WriteString(" ")
+	for i := 15; i >= 0; i-- {
+		if v.memory[MR_LED]&(1<<i) != 0 {
+			rows[0].WriteString("\x1b[31m●\x1b[0m")
+		} else {
+			rows[0].WriteString("○")
+		}
+	}
+	panel := rows[0].String() + "\n" + rows[1].String() + "\n" + rows[2].String()
+	if panel == v.boardDrawn {
+		return
+	}
+	v.boardDrawn = panel
+
+	if info, err := os.Stderr.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		fmt.Fprintf(os.Stderr, "leds %016b  digits %02X\n", v.memory[MR_LED], v.Segments())
+		return
+	}
+	col := 1
+	if w, _, ok := hostTermSize(); ok && w > 33 {
+		col = w - 32
+	}
+	var out strings.Builder
+	out.WriteString("\x1b7")
+	for i := range rows {
+		fmt.Fprintf(&out, "\x1b[%d;%dH%s", i+1, col, rows[i].String())
+	}
+	out.WriteString("\x1b8")
+	fmt.Fprint(os.Stderr, out.String())
+}
@@ -0,0 +1,43 @@
+package vm
+
+import "time"
+
+const (
+	MR_RTCH = 0xFE20 // seconds since the Unix epoch, high word. reading it latches the time for the other two
+	MR_RTCL = 0xFE22 // seconds, low word
+	MR_RTCM = 0xFE24 // milliseconds into the second, 0-999
+)
+
+type clock struct {
+	clockLatched time.Time
+}
+
+// clockRead gives the value of a clock register. reading the high word first
+// and then the others gives one consistent time, the low word can't roll over in between.
+// in deterministic mode the clock starts at the epoch and a thousand instructions take a millisecond
+func (v *VM) clockRead(address uint16) uint16 {
+	if address == MR_RTCH || v.clockLatched.IsZero() {
+		if v.deterministic {
+			v.clockLatched = time.UnixMilli(int64(v.instrCount / 1000))
+		} else {
+			v.clockLatched = time.Now()
+		}
+	}
+	sec := v.clockLatched.Unix()
+	switch address {
+	case MR_RTCH:
+		return uint16(sec >> 16)
+	case MR_RTCL:
+		return uint16(sec)
+	}
+	return uint16(v.clockLatched.Nanosecond() / int(time.Millisecond))
+}
+
+// runMillis is how far into the run the machine is, in milliseconds, for the devices following a
+// script. in deterministic mode a thousand instructions take a millisecond, like the clock's
+func (v *VM) runMillis() uint64 {
+	if v.deterministic {
+		return v.instrCount / 1000
+	}
+	return uint64(time.Since(v.startTime).Milliseconds())
+}
@@ -0,0 +1,95 @@
+package vm
+
+import (
+	"context"
+	"maps"
+	"math/rand"
+	"slices"
+	"sync"
+)
+
+// Clone makes an independent copy of the machine, for trying out "what if" branches or keeping a
+// cheap in-memory checkpoint: memory, registers, the device registers and the state behind them,
+// the counters and every feature's setup. the copy shares the console (Keys, In and Out) until
+// they're set to something else, and leaves out what's tied to the outside world: it has no device
+// plugins (their addresses become plain memory), no disk, serial ports, sound, mailbox or
+// network, no GPIO log, printer or display window, no transcript and no event subscribers.
+// clone a machine that isn't running, or is paused between instructions
+func (v *VM) Clone() *VM {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	c := &VM{
+		memory:          slices.Clone(v.memory),
+		memSize:         v.memSize,
+		reg:             v.reg,
+		Keys:            v.Keys,
+		In:              v.In,
+		Out:             v.Out,
+		segments:        slices.Clone(v.segments),
+		startPC:         v.startPC,
+		trace:           v.trace,
+		maxInstructions: v.maxInstructions,
+		trapHandlers:    maps.Clone(v.trapHandlers),
+		trapSpec:        v.trapSpec,
+		trapTable:       v.trapTable,
+		ctx:             context.Background(),
+		encoding:        v.encoding,
+		coverage:        slices.Clone(v.coverage),
+	}
+	c.ctlCond = sync.NewCond(&c.ctl)
+
+	c.counters = v.counters
+	c.trapCounts = maps.Clone(v.trapCounts)
+	c.scancodeState = v.scancodeState
+	c.scancodeQueue = slices.Clone(v.scancodeQueue)
+	c.keymapState = v.keymapState
+	c.charMap, c.specialMap = maps.Clone(v.charMap), maps.Clone(v.specialMap)
+	c.protection = v.protection
+	c.protected = slices.Clone(v.protected)
+	c.stackCheck = v.stackCheck
+	c.heap = slices.Clone(v.heap)
+	c.extensions = v.extensions
+	c.watchdog = v.watchdog
+	c.timer = v.timer
+	c.clock = v.clock
+	c.watched = slices.Clone(v.watched)
+	c.historyState = v.historyState
+	c.history = slices.Clone(v.history)
+	c.smcState = v.smcState
+	c.executed, c.smcWarned = slices.Clone(v.executed), slices.Clone(v.smcWarned)
+	c.touched = slices.Clone(v.touched)
+	c.profileState = v.profileState
+	c.profileStack, c.profileCounts = slices.Clone(v.profileStack), maps.Clone(v.profileCounts)
+	c.nvram = v.nvram
+	c.statusState = v.statusState
+	c.video = v.video
+	c.videoFrame = slices.Clone(v.videoFrame)
+	c.display = v.display
+	c.beeper = v.beeper
+	c.gamepad = v.gamepad
+	c.boardOn, c.boardDrawn = v.boardOn, v.boardDrawn
+	c.switches.Store(v.switches.Load())
+	c.arguments = v.arguments
+	c.gpioOn, c.gpioScript, c.gpioNext = v.gpioOn, v.gpioScript, v.gpioNext
+	c.sensor = v.sensor
+	c.banking = v.banking
+	c.bankStore = slices.Clone(v.bankStore)
+	c.padQueue = slices.Clone(v.padQueue)
+	c.displayFrame = slices.Clone(v.displayFrame)
+	if v.displayWindow != nil { // the window stays with v
+		c.displayOn, c.displayWindow, c.displayFrame = false, nil, nil
+	}
+	c.deviceMoves, c.deviceBases = maps.Clone(v.deviceMoves), maps.Clone(v.deviceBases)
+	c.determinism = v.determinism
+	c.priority, c.user, c.startUser, c.unhandledHalt = v.priority, v.user, v.startUser, v.unhandledHalt
+	c.savedSSP, c.savedUSP, c.sspStart = v.savedSSP, v.savedUSP, v.sspStart
+	c.sspLimit, c.sspException = v.sspLimit, v.sspException
+	c.devicePriority = v.devicePriority
+	v.intMu.Lock()
+	c.intPending = slices.Clone(v.intPending)
+	c.intHighest.Store(highestPending(c.intPending))
+	v.intMu.Unlock()
+	c.rng = rand.New(rand.NewSource(v.rng.Int63())) // a seeded machine gives a seeded clone
+	return c
+}
@@ -0,0 +1,281 @@
+package vm
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/eiannone/keyboard"
+)
+
+const (
+	CHAR_BACKSPACE = 0x08
+	CHAR_ENTER     = 0x0D
+	CHAR_DELETE    = 0x7F
+)
+
+// keyChar turns a key press into a character code.
+// enter, space, backspace and the ctrl keys come from the keyboard package as a Key with no rune
+func keyChar(ev keyboard.KeyEvent) uint16 {
+	if ev.Rune == 0 && (ev.Key <= keyboard.KeySpace || ev.Key == keyboard.KeyBackspace2) {
+		return uint16(ev.Key)
+	}
+	return uint16(ev.Rune)
+}
+
+// keys is where key presses come from: Keys if it's set, otherwise what's read from In
+func (v *VM) keys() <-chan keyboard.KeyEvent {
+	if v.Keys == nil && v.In != nil {
+		v.Keys = readKeys(v.In)
+	}
+	return v.Keys
+}
+
+// readKeys turns the characters read from r into key presses, the way the keyboard package reports them
+func readKeys(r io.Reader) <-chan keyboard.KeyEvent {
+	keys := make(chan keyboard.KeyEvent, 10)
+	go func() {
+		defer close(keys)
+		in := bufio.NewReader(r)
+		for {
+			char, _, err := in.ReadRune()
+			if err != nil {
+				keys <- keyboard.KeyEvent{Err: err}
+				return
+			}
+			keys <- runeKey(char)
+		}
+	}()
+	return keys
+}
+
+// runeKey is the key press a typed character comes in as
+func runeKey(char rune) keyboard.KeyEvent {
+	if char <= ' ' || char == CHAR_DELETE {
+		return keyboard.KeyEvent{Key: keyboard.Key(char)}
+	}
+	return keyboard.KeyEvent{Rune: char}
+}
+
+// readChar blocks for a key press
+func (v *VM) readChar() (uint16, error) {
+	ev, err := v.readKey()
+	if err != nil {
+		return 0, err
+	}
+	char := v.mapKey(ev)
+	v.recordInput(char)
+	return char, nil
+}
+
+// readKey blocks for a key press meant for the console
+func (v *VM) readKey() (keyboard.KeyEvent, error) {
+	if ev, ok := v.padKey(); ok {
+		return ev, nil
+	}
+	for {
+		ev, err := v.waitKey()
+		if err != nil || !v.padOn || !v.padPress(ev) {
+			return ev, err
+		}
+	}
+}
+
+func (v *VM) waitKey() (keyboard.KeyEvent, error) {
+	var ev keyboard.KeyEvent
+	if v.deterministic {
+		ev = v.scriptKey()
+	} else {
+		keys := v.keys()
+		if keys == nil {
+			return ev, ioError{errors.New("no keyboard")}
+		}
+		var ok bool
+		v.waitingForKey()
+		select {
+		case ev, ok = <-keys:
+		case <-v.ctx.Done():
+			return ev, v.ctx.Err()
+		}
+		v.park() // a key that arrives while paused waits for Resume
+		if !ok {
+			return ev, ioError{errors.New("keyboard closed")}
+		}
+	}
+	if ev.Err != nil {
+		return ev, ioError{ev.Err}
+	}
+	return ev, nil
+}
+
+// pollKey returns a waiting key press meant for the console, if there is one, without blocking
+func (v *VM) pollKey() (keyboard.KeyEvent, bool) {
+	if ev, ok := v.padKey(); ok {
+		return ev, true
+	}
+	for {
+		ev, ok := v.rawKey()
+		if !ok || !v.padOn || !v.padPress(ev) {
+			return ev, ok
+		}
+	}
+}
+
+// rawKey returns a waiting key press, whoever it's for, without blocking
+func (v *VM) rawKey() (keyboard.KeyEvent, bool) {
+	if v.deterministic {
+		ev := v.scriptKey()
+		return ev, ev.Err == nil
+	}
+	select {
+	case ev, ok := <-v.keys():
+		if !ok || ev.Err != nil {
+			return keyboard.KeyEvent{}, false
+		}
+		return ev, true
+	default:
+		return keyboard.KeyEvent{}, false
+	}
+}
+
+func (v *VM) pollChar() (uint16, bool) {
+	ev, ok := v.pollKey()
+	if !ok {
+		return 0, false
+	}
+	char := v.mapKey(ev)
+	v.recordInput(char)
+	return char, true
+}
+
+// readNumber reads a signed decimal number terminated by enter, echoing what is typed.
+// keys that would make it out of range for 16 bits are ignored
+func (v *VM) readNumber() (uint16, error) {
+	var text []byte
+	for {
+		char, err := v.readChar()
+		if err != nil {
+			return 0, err
+		}
+
+		switch {
+		case char == CHAR_ENTER || char == '\n':
+			if n, err := strconv.ParseInt(string(text), 10, 16); err == nil {
+				fmt.Fprintln(v.Out)
+				return uint16(n), nil
+			}
+		case char == CHAR_BACKSPACE || char == CHAR_DELETE:
+			if len(text) > 0 {
+				text = text[:len(text)-1]
+				fmt.Fprint(v.Out, "\b \b")
+			}
+		case char == '-' && len(text) == 0:
+			text = append(text, '-')
+			fmt.Fprint(v.Out, "-")
+		case char >= '0' && char <= '9':
+			next := append(text, byte(char))
+			if _, err := strconv.ParseInt(string(next), 10, 16); err == nil {
+				text = next
+				fmt.Fprintf(v.Out, "%c", rune(char))
+			}
+		}
+	}
+}
+
+// readLine reads characters up to enter, echoing them and handling backspace.
+// anything typed past max characters is dropped
+func (v *VM) readLine(max int) ([]uint16, error) {
+	var line []uint16
+	for {
+		char, err := v.readChar()
+		if err != nil {
+			return nil, err
+		}
+
+		switch char {
+		case CHAR_ENTER, '\n':
+			fmt.Fprintln(v.Out)
+			return line, nil
+		case CHAR_BACKSPACE, CHAR_DELETE:
+			if len(line) > 0 {
+				line = line[:len(line)-1]
+				fmt.Fprint(v.Out, "\b \b")
+			}
+		default:
+			if len(line) < max {
+				line = append(line, char)
+				fmt.Fprintf(v.Out, "%c", rune(char))
+			}
+		}
+	}
+}
+
+// screen control, done with ANSI escape sequences
+
+func (v *VM) clearScreen() {
+	fmt.Fprint(v.Out, "\033[2J\033[H")
+}
+
+func (v *VM) moveCursor(row, col uint16) {
+	fmt.Fprintf(v.Out, "\033[%d;%dH", int(row)+1, int(col)+1) // ANSI counts from 1
+}
+
+const ( // text attribute bits for the COLOR trap
+	ATTR_BOLD      = 1 << 0
+	ATTR_UNDERLINE = 1 << 1
+	ATTR_REVERSE   = 1 << 2
+	ATTR_BLINK     = 1 << 3
+)
+
+// setColor changes the style of everything printed after it.
+// colors 0-7 are the normal ANSI ones, 8-15 their bright versions, anything else keeps the terminal default.
+// it's only for what's printed: -video cells carry their own colours in bits 8-15 and -display
+// pixels are RGB555, so there's no pen for it to set there
+func (v *VM) setColor(fg, bg, attr uint16) {
+	codes := "0" // start from a clean slate
+	if attr&ATTR_BOLD != 0 {
+		codes += ";1"
+	}
+	if attr&ATTR_UNDERLINE != 0 {
+		codes += ";4"
+	}
+	if attr&ATTR_BLINK != 0 {
+		codes += ";5"
+	}
+	if attr&ATTR_REVERSE != 0 {
+		codes += ";7"
+	}
+	if fg < 8 {
+		codes += fmt.Sprintf(";%d", 30+fg)
+	} else if fg < 16 {
+		codes += fmt.Sprintf(";%d", 90+fg-8)
+	}
+	if bg < 8 {
+		codes += fmt.Sprintf(";%d", 40+bg)
+	} else if bg < 16 {
+		codes += fmt.Sprintf(";%d", 100+bg-8)
+	}
+	fmt.Fprintf(v.Out, "\033[%sm", codes)
+}
+
+// termSize is the console's width and height: the -video grid's when it's on, as that's what the
+// program draws on, else the terminal's, falling back on $COLUMNS/$LINES and then 80x24
+func (v *VM) termSize() (int, int) {
+	if v.videoOn {
+		return v.videoCols, v.videoRows
+	}
+	if w, h, ok := hostTermSize(); ok {
+		return w, h
+	}
+	w, h := 80, 24
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 0 {
+		w = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("LINES")); err == nil && n > 0 {
+		h = n
+	}
+	return w, h
+}
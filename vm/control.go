@@ -0,0 +1,102 @@
+package vm
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// the Pause/Resume/Stop handshake with a machine running in another goroutine
+type control struct {
+	ctl       sync.Mutex
+	ctlCond   *sync.Cond
+	ctlPoll   atomic.Bool // a pause or stop is pending, the run loop only takes the lock when it's set
+	pauseReq  bool
+	stopReq   bool
+	running   bool
+	parked    bool // between instructions or waiting for a key, not touching anything
+	cancelRun context.CancelFunc
+}
+
+// Pause stops the machine at the next instruction and returns once it's parked, from then on
+// registers and memory can be looked at and changed until Resume. a machine waiting for a key
+// counts as parked, the key it gets is held until Resume. pausing a machine that isn't running
+// has its next Run start paused
+func (v *VM) Pause() {
+	v.ctl.Lock()
+	defer v.ctl.Unlock()
+	v.pauseReq = true
+	v.ctlPoll.Store(true)
+	for v.running && !v.parked {
+		v.ctlCond.Wait()
+	}
+}
+
+// Resume lets a paused machine carry on
+func (v *VM) Resume() {
+	v.ctl.Lock()
+	defer v.ctl.Unlock()
+	v.pauseReq = false
+	v.ctlPoll.Store(v.stopReq)
+	v.ctlCond.Broadcast()
+}
+
+// Stop ends the run at the next instruction, or straight away if the machine is paused or
+// waiting for a key, and Run returns nil with "stopped" as the halt reason. stopping a machine
+// that isn't running stops its next Run before the first instruction
+func (v *VM) Stop() {
+	v.ctl.Lock()
+	defer v.ctl.Unlock()
+	v.stopReq = true
+	v.ctlPoll.Store(true)
+	if v.cancelRun != nil {
+		v.cancelRun()
+	}
+	v.ctlCond.Broadcast()
+}
+
+func (v *VM) startRun(cancel context.CancelFunc) {
+	v.ctl.Lock()
+	defer v.ctl.Unlock()
+	v.running = true
+	v.cancelRun = cancel
+	if v.stopReq {
+		cancel()
+	}
+}
+
+// endRun turns the cancellation a Stop uses into a normal end of the run
+func (v *VM) endRun(err *error) {
+	v.ctl.Lock()
+	defer v.ctl.Unlock()
+	if v.stopReq && (*err == nil || errors.Is(*err, context.Canceled)) {
+		v.haltReason = "stopped"
+		*err = nil
+	}
+	v.running, v.parked, v.stopReq = false, false, false
+	v.cancelRun = nil
+	v.ctlPoll.Store(v.pauseReq)
+	v.ctlCond.Broadcast()
+}
+
+// park waits out a pause, it says false if the machine has been stopped
+func (v *VM) park() bool {
+	v.ctl.Lock()
+	defer v.ctl.Unlock()
+	for v.pauseReq && !v.stopReq {
+		v.parked = true
+		v.ctlCond.Broadcast()
+		v.ctlCond.Wait()
+	}
+	v.parked = false
+	return !v.stopReq
+}
+
+// waitingForKey marks the machine as parked while it blocks on the keyboard
+func (v *VM) waitingForKey() {
+	v.ctl.Lock()
+	defer v.ctl.Unlock()
+	v.parked = true
+	v.ctlCond.Broadcast()
+}
@@ -0,0 +1,13 @@
+package vm
+
+// SetupCoverage starts counting how often each address is executed
+func (v *VM) SetupCoverage(on bool) {
+	if on {
+		v.coverage = make([]uint64, MEMORY_MAX)
+	}
+}
+
+// Coverage is the execution count per address, nil unless coverage is on
+func (v *VM) Coverage() []uint64 {
+	return v.coverage
+}
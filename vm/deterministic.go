@@ -0,0 +1,42 @@
+package vm
+
+import (
+	"bufio"
+	"errors"
+	"math/rand"
+
+	"github.com/eiannone/keyboard"
+)
+
+type determinism struct {
+	deterministic bool
+	script        *bufio.Reader // In, read as the program asks rather than as keys arrive
+	rng           *rand.Rand    // what devices draw random numbers from
+}
+
+// SetupDeterministic makes two runs of the same image with the same input go exactly the same way.
+// console input is read from In only when the program asks for it, so a KBSR poll finds a key ready
+// whenever In has one left instead of depending on when it was typed, Keys is ignored, transcript
+// timestamps are left at 0, the clock counts instructions and the random source is seeded with seed
+func (v *VM) SetupDeterministic(on bool, seed int64) {
+	v.deterministic = on
+	v.script = nil
+	if on {
+		if v.In != nil {
+			v.script = bufio.NewReader(v.In)
+		}
+		v.SetupSeed(seed)
+	}
+}
+
+// scriptKey reads the next key press from the script
+func (v *VM) scriptKey() keyboard.KeyEvent {
+	if v.script == nil {
+		return keyboard.KeyEvent{Err: errors.New("no keyboard")}
+	}
+	char, _, err := v.script.ReadRune()
+	if err != nil {
+		return keyboard.KeyEvent{Err: err}
+	}
+	return runeKey(char)
+}
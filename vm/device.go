@@ -0,0 +1,252 @@
+package vm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+/*
+device plugins are separate processes that own some device registers.
+everything is one line of text each way over the plugin's stdin/stdout,
+numbers in LC-3 hex. first the plugin says which registers it wants:
+
+	map xFE40 xFE43   (inclusive range, as many lines as needed)
+	int x90 PL5       (an interrupt line, its vector and priority, if it wants one)
+	ready
+
+then every guest access to one of them turns into a request and a reply:
+
+	r xFE40           ->  x0041
+	w xFE42 x0007     ->  ok
+
+replying 'error <message>' to either faults the program. a plugin with an
+interrupt line raises it by writing
+
+	irq
+
+whenever it likes, between replies or before one. stdin is closed
+when the emulator finishes, that's the plugin's cue to exit. stderr is
+passed through so plugins can log.
+*/
+
+type plugin struct {
+	name    string
+	cmd     *exec.Cmd
+	in      io.WriteCloser
+	out     *bufio.Scanner
+	replies chan string   // what the plugin says once it's ready, irq aside
+	done    chan struct{} // closed when the plugin is hung up on
+
+	vector   uint8 // its interrupt line
+	priority int   // -1 when it has none
+}
+
+type devices struct {
+	plugins  []*plugin
+	deviceAt map[uint16]*plugin
+}
+
+// SetupDevices starts an external device process for each 'cmd:program args...' spec
+func (v *VM) SetupDevices(specs []string) error {
+	for _, spec := range specs {
+		command, ok := strings.CutPrefix(spec, "cmd:")
+		args := strings.Fields(command)
+		if !ok || len(args) == 0 {
+			return fmt.Errorf("want cmd:program args..., got %q", spec)
+		}
+		if err := v.startDevice(args); err != nil {
+			return fmt.Errorf("%s: %v", args[0], err)
+		}
+	}
+	return nil
+}
+
+func (v *VM) startDevice(args []string) error {
+	p := &plugin{name: args[0], cmd: exec.Command(args[0], args[1:]...), priority: -1}
+	p.cmd.Stderr = os.Stderr
+	var err error
+	if p.in, err = p.cmd.StdinPipe(); err != nil {
+		return err
+	}
+	stdout, err := p.cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	p.out = bufio.NewScanner(stdout)
+	if err := p.cmd.Start(); err != nil {
+		return err
+	}
+	v.plugins = append(v.plugins, p)
+	if v.deviceAt == nil {
+		v.deviceAt = map[uint16]*plugin{}
+	}
+
+	for {
+		line, err := p.readLine()
+		if err != nil {
+			return err
+		}
+		if line == "ready" {
+			p.replies, p.done = make(chan string), make(chan struct{})
+			go p.listen(v)
+			return nil
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 3 && fields[0] == "int" {
+			if err := v.interruptLine(p, fields[1], fields[2]); err != nil {
+				return err
+			}
+			continue
+		}
+		if len(fields) != 3 || fields[0] != "map" {
+			return fmt.Errorf("expected 'map start end', 'int vector PL' or 'ready', got %q", line)
+		}
+		start, err := ParseAddr(fields[1])
+		if err != nil {
+			return err
+		}
+		end, err := ParseAddr(fields[2])
+		if err != nil {
+			return err
+		}
+		if start < 0xFE00 || end < start {
+			return fmt.Errorf("bad range x%04X-x%04X, devices live in xFE00-xFFFF", start, end)
+		}
+		for address := int(start); address <= int(end); address++ {
+			if err := v.claim(p, uint16(address)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// claim takes an address, unless a built-in device or another plugin has it
+func (v *VM) claim(p *plugin, address uint16) error {
+	switch address {
+	case MR_KBSR, MR_KBDR, MR_DSR, MR_DDR, MR_TMR, MR_TMC, MR_WDT, MR_MPR, MR_ACVA, MR_PSR, MR_BANK,
+		MR_RTCH, MR_RTCL, MR_RTCM, MR_RNG,
+		MR_DSKS, MR_DSKN, MR_DSKA, MR_DSKC, MR_BEEPF, MR_BEEPD,
+		MR_PCMD, MR_PCMF, MR_PAD, MR_MBS, MR_MBD, MR_MBA, MR_MBF,
+		MR_NICS, MR_NICA, MR_NICC, MR_ARGC, MR_ARGN, MR_ARGD,
+		MR_GPI, MR_GPO, MR_LPS, MR_LPD, MR_SENS, MR_SENV, MR_SENT:
+		return fmt.Errorf("x%04X belongs to a built-in device", address)
+	}
+	if p := v.serialAt(address); p != nil {
+		return fmt.Errorf("x%04X belongs to serial port %s", address, p.name)
+	}
+	if address >= MR_SW && address <= MR_SEG3 {
+		return fmt.Errorf("x%04X belongs to the board", address)
+	}
+	if address >= MR_PERF && address <= PERF_END {
+		return fmt.Errorf("x%04X belongs to the performance counters", address)
+	}
+	if other := v.deviceAt[address]; other != nil {
+		return fmt.Errorf("x%04X is already taken by %s", address, other.name)
+	}
+	v.deviceAt[address] = p
+	return nil
+}
+
+// interruptLine gives the plugin the interrupt at vector, raised at priority level
+func (v *VM) interruptLine(p *plugin, vector, level string) error {
+	n, err := ParseAddr(vector)
+	if err != nil || n < 0x80 || n > 0xFF {
+		return fmt.Errorf("want an interrupt vector x80 to xFF, got %q", vector)
+	}
+	priority, err := strconv.Atoi(strings.TrimPrefix(strings.ToUpper(level), "PL"))
+	if err != nil || priority < 0 || priority > 7 {
+		return fmt.Errorf("want a priority PL0 to PL7, got %q", level)
+	}
+	if p.priority >= 0 {
+		return fmt.Errorf("one interrupt line per device")
+	}
+	for name, builtin := range deviceVectors {
+		if uint16(builtin) == n {
+			return fmt.Errorf("vector x%02X belongs to the %s", n, name)
+		}
+	}
+	for _, other := range v.plugins {
+		if other.priority >= 0 && uint16(other.vector) == n {
+			return fmt.Errorf("vector x%02X is already taken by %s", n, other.name)
+		}
+	}
+	p.vector, p.priority = uint8(n), priority
+	return nil
+}
+
+// listen passes the plugin's replies on to request and raises its interrupt when it asks
+func (p *plugin) listen(v *VM) {
+	defer close(p.replies)
+	for {
+		line, err := p.readLine()
+		if err != nil {
+			return
+		}
+		if line == "irq" && p.priority >= 0 {
+			v.AssertInterrupt(p.vector, p.priority)
+			continue
+		}
+		select {
+		case p.replies <- line:
+		case <-p.done:
+			return
+		}
+	}
+}
+
+func (p *plugin) readLine() (string, error) {
+	if !p.out.Scan() {
+		if err := p.out.Err(); err != nil {
+			return "", err
+		}
+		return "", fmt.Errorf("exited")
+	}
+	return strings.TrimSpace(p.out.Text()), nil
+}
+
+// request sends one line and waits for the answer
+func (p *plugin) request(v *VM, format string, args ...any) string {
+	if _, err := fmt.Fprintf(p.in, format+"\n", args...); err != nil {
+		v.fault("device %s: %v", p.name, err)
+	}
+	reply, ok := <-p.replies
+	if !ok {
+		v.fault("device %s: exited", p.name)
+	}
+	if msg, ok := strings.CutPrefix(reply, "error"); ok {
+		v.fault("device %s: %s (PC=0x%04X)", p.name, strings.TrimSpace(msg), v.reg[R_PC]-1)
+	}
+	return reply
+}
+
+func (p *plugin) read(v *VM, address uint16) uint16 {
+	reply := p.request(v, "r x%04X", address)
+	value, err := ParseAddr(reply)
+	if err != nil {
+		v.fault("device %s: bad reply to read of x%04X: %q", p.name, address, reply)
+	}
+	return value
+}
+
+func (p *plugin) write(v *VM, address, value uint16) {
+	if reply := p.request(v, "w x%04X x%04X", address, value); reply != "ok" {
+		v.fault("device %s: bad reply to write of x%04X: %q", p.name, address, reply)
+	}
+}
+
+// closeDevices hangs up on every plugin and waits for it to go
+func (v *VM) closeDevices() {
+	for _, p := range v.plugins {
+		if p.done != nil {
+			close(p.done)
+		}
+		p.in.Close()
+		p.cmd.Wait()
+	}
+	v.plugins, v.deviceAt = nil, nil
+}
@@ -0,0 +1,125 @@
+package vm
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+)
+
+const (
+	MR_DSKS = 0xFE30 // disk status
+	MR_DSKN = 0xFE32 // sector number
+	MR_DSKA = 0xFE34 // memory address of the sector buffer
+	MR_DSKC = 0xFE36 // command, writing one runs it
+
+	DSKS_READY = 1 << 15
+	DSKS_ERROR = 1 << 14 // the last command failed: no disk, a buffer past the end of memory, over protected memory or a host error
+
+	DSKC_READ  = 1 // sector into the buffer
+	DSKC_WRITE = 2 // buffer into the sector
+
+	SECTOR_WORDS = 256 // 512 bytes, big endian like image files
+)
+
+type disk struct {
+	diskFile  *os.File
+	diskError bool
+}
+
+// SetupDisk attaches the disk image at path (created if it's missing) to the block device.
+// sectors past the end of the file read as zeros, writing one grows the file
+func (v *VM) SetupDisk(path string) error {
+	if path == "" {
+		return nil
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	v.diskFile = file
+	return nil
+}
+
+func (v *VM) diskStatus() uint16 {
+	if v.diskError {
+		return DSKS_READY | DSKS_ERROR
+	}
+	return DSKS_READY
+}
+
+// diskCommand runs a command to the end, the device is always ready again by the next instruction.
+// a buffer the program couldn't store to itself, read-only or, in user mode, kept out of by the MPR,
+// fails the command rather than being written behind the protection's back
+func (v *VM) diskCommand(command uint16) {
+	v.diskError = v.diskTransfer(command) != nil
+}
+
+func (v *VM) diskTransfer(command uint16) error {
+	if v.diskFile == nil {
+		return errors.New("no disk")
+	}
+	buffer := int(v.memory[MR_DSKA])
+	if buffer+SECTOR_WORDS > DEVICE_START || buffer+SECTOR_WORDS > v.memSize {
+		return errors.New("buffer past the end of memory")
+	}
+	for address := buffer; address < buffer+SECTOR_WORDS; address++ {
+		if command == DSKC_READ && v.protected[address] {
+			return errors.New("buffer over read-only memory")
+		}
+		if v.user && v.memory[MR_MPR]>>(address>>MPR_PAGE_SHIFT)&1 != 0 {
+			return errors.New("buffer over memory the MPR keeps user mode out of")
+		}
+	}
+	offset := int64(v.memory[MR_DSKN]) * SECTOR_WORDS * 2
+	data := make([]byte, SECTOR_WORDS*2)
+
+	switch command {
+	case DSKC_READ:
+		if _, err := v.diskFile.ReadAt(data, offset); err != nil && err != io.EOF {
+			return err
+		}
+		for i := range SECTOR_WORDS {
+			v.dmaWrite(uint16(buffer+i), binary.BigEndian.Uint16(data[2*i:]))
+		}
+	case DSKC_WRITE:
+		for i := range SECTOR_WORDS {
+			binary.BigEndian.PutUint16(data[2*i:], v.memory[buffer+i])
+		}
+		if _, err := v.diskFile.WriteAt(data, offset); err != nil {
+			return err
+		}
+	default:
+		return errors.New("unknown command")
+	}
+	return nil
+}
+
+// dmaWrite stores a word the disk transferred, seen by everything that watches memory as a store
+// by the instruction that ran the command would be
+func (v *VM) dmaWrite(address, value uint16) {
+	v.memAccesses++
+	if v.touched != nil {
+		v.touched[address] |= TOUCH_WRITE
+	}
+	if v.stepping != nil {
+		v.stepping.Writes = append(v.stepping.Writes, address)
+	}
+	if v.subscribed.Load() {
+		v.emit(Event{Kind: EVENT_MEM, PC: v.reg[R_PC] - 1, Addr: address, Old: v.memory[address], New: value})
+	}
+	if v.watched[address] {
+		v.logWatch(address, value)
+	}
+	if v.executed != nil && v.executed[address] {
+		v.checkSMC(address, value)
+	}
+	v.memory[address] = value
+}
+
+func (v *VM) closeDisk() {
+	if v.diskFile != nil {
+		v.diskFile.Close()
+		v.diskFile = nil
+	}
+}
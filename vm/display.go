@@ -0,0 +1,141 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	DISPLAY_START  = 0xC000 // pixel framebuffer, row by row, one word per pixel
+	DISPLAY_WIDTH  = 128
+	DISPLAY_HEIGHT = 124
+)
+
+// a pixel is RGB555: red in bits 14-10, green in 9-5, blue in 4-0
+type display struct {
+	displayOn       bool
+	displayInterval time.Duration
+	displayLast     time.Time
+	displayFrame    []uint16 // what's on the terminal, nil before the first frame
+	displayWindow   displayWindow
+}
+
+// displayWindow is somewhere other than the terminal to show the framebuffer
+type displayWindow interface {
+	draw(pixels []uint16) error
+	close()
+}
+
+// SetupDisplay shows the framebuffer at xC000 as pixels on the terminal, redrawn up to fps times a
+// second when it changes. there's no window, each character cell is two pixels drawn with a half
+// block in 24 bit colour, so it wants a terminal at least 128x62 that does true colour
+func (v *VM) SetupDisplay(on bool, fps int) error {
+	if !on {
+		return nil
+	}
+	if fps <= 0 {
+		return fmt.Errorf("want a frame rate above 0, got %d", fps)
+	}
+	if v.videoOn {
+		return errors.New("there's one terminal, -video already draws on it")
+	}
+	if err := v.checkMapped(DISPLAY_START, DISPLAY_WIDTH*DISPLAY_HEIGHT); err != nil {
+		return err
+	}
+	v.displayOn = true
+	v.displayInterval = time.Second / time.Duration(fps)
+	return nil
+}
+
+// SetupDisplayWindow is SetupDisplay in a window of its own on the X server, DISPLAY_WINDOW_SCALE
+// screen pixels to a framebuffer pixel, which leaves the terminal to the program. keys are still
+// read from the terminal. it needs lc3 built with -tags x11, and the window stays up until Close
+func (v *VM) SetupDisplayWindow(on bool, fps int) error {
+	if !on {
+		return nil
+	}
+	if fps <= 0 {
+		return fmt.Errorf("want a frame rate above 0, got %d", fps)
+	}
+	if err := v.checkMapped(DISPLAY_START, DISPLAY_WIDTH*DISPLAY_HEIGHT); err != nil {
+		return err
+	}
+	window, err := openWindow("lc3")
+	if err != nil {
+		return err
+	}
+	v.displayOn, v.displayWindow = true, window
+	v.displayInterval = time.Second / time.Duration(fps)
+	return nil
+}
+
+// displayStep redraws the pixels when it's due, the clock is only looked at every 1024 instructions
+func (v *VM) displayStep() {
+	if v.instrCount&0x3FF != 0 {
+		return
+	}
+	if now := time.Now(); now.Sub(v.displayLast) >= v.displayInterval {
+		v.displayLast = now
+		v.drawDisplay()
+	}
+}
+
+// drawDisplay puts the framebuffer on the terminal, or in the window, if it changed since the last frame
+func (v *VM) drawDisplay() {
+	pixels := v.memory[DISPLAY_START : DISPLAY_START+DISPLAY_WIDTH*DISPLAY_HEIGHT]
+	if slices.Equal(pixels, v.displayFrame) {
+		return
+	}
+	if v.displayWindow != nil {
+		// a window that's gone, closed or cut off from its server, takes frames and drops them
+		v.displayWindow.draw(pixels)
+		v.displayFrame = slices.Clone(pixels)
+		return
+	}
+	var out strings.Builder
+	if v.displayFrame == nil {
+		out.WriteString("\x1b[2J\x1b[?25l")
+	}
+	for y := 0; y < DISPLAY_HEIGHT; y += 2 {
+		fmt.Fprintf(&out, "\x1b[%d;1H", y/2+1)
+		for x := 0; x < DISPLAY_WIDTH; x++ {
+			top, bottom := pixels[y*DISPLAY_WIDTH+x], pixels[(y+1)*DISPLAY_WIDTH+x]
+			r, g, b := rgb(top)
+			fmt.Fprintf(&out, "\x1b[38;2;%d;%d;%dm", r, g, b)
+			r, g, b = rgb(bottom)
+			fmt.Fprintf(&out, "\x1b[48;2;%d;%d;%dm▀", r, g, b)
+		}
+		out.WriteString("\x1b[0m")
+	}
+	v.displayFrame = slices.Clone(pixels)
+	fmt.Fprint(v.Out, out.String())
+}
+
+// rgb spreads an RGB555 pixel out to 8 bits a channel
+func rgb(pixel uint16) (uint8, uint8, uint8) {
+	scale := func(c uint16) uint8 { return uint8(c<<3 | c>>2) }
+	return scale(pixel >> 10 & 0x1F), scale(pixel >> 5 & 0x1F), scale(pixel & 0x1F)
+}
+
+// endDisplay draws the last frame when a run ends and gives the cursor back, below the pixels
+func (v *VM) endDisplay() {
+	if !v.displayOn {
+		return
+	}
+	v.drawDisplay()
+	if v.displayWindow != nil {
+		return
+	}
+	fmt.Fprintf(v.Out, "\x1b[%d;1H\x1b[?25h", DISPLAY_HEIGHT/2+1)
+	v.displayFrame = nil
+}
+
+func (v *VM) closeDisplayWindow() {
+	if v.displayWindow != nil {
+		v.displayWindow.close()
+		v.displayWindow = nil
+	}
+}
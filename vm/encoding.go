@@ -0,0 +1,37 @@
+package vm
+
+import "fmt"
+
+// the upper half of code page 437, box drawing and all. xFF is the no-break space
+var cp437 = [128]rune{
+	'Ç', 'ü', 'é', 'â', 'ä', 'à', 'å', 'ç', 'ê', 'ë', 'è', 'ï', 'î', 'ì', 'Ä', 'Å',
+	'É', 'æ', 'Æ', 'ô', 'ö', 'ò', 'û', 'ù', 'ÿ', 'Ö', 'Ü', '¢', '£', '¥', '₧', 'ƒ',
+	'á', 'í', 'ó', 'ú', 'ñ', 'Ñ', 'ª', 'º', '¿', '⌐', '¬', '½', '¼', '¡', '«', '»',
+	'░', '▒', '▓', '│', '┤', '╡', '╢', '╖', '╕', '╣', '║', '╗', '╝', '╜', '╛', '┐',
+	'└', '┴', '┬', '├', '─', '┼', '╞', '╟', '╚', '╔', '╩', '╦', '╠', '═', '╬', '╧',
+	'╨', '╤', '╥', '╙', '╘', '╒', '╓', '╫', '╪', '┘', '┌', '█', '▄', '▌', '▐', '▀',
+	'α', 'ß', 'Γ', 'π', 'Σ', 'σ', 'µ', 'τ', 'Φ', 'Θ', 'Ω', 'δ', '∞', 'φ', 'ε', '∩',
+	'≡', '±', '≥', '≤', '⌠', '⌡', '÷', '≈', '°', '∙', '·', '√', 'ⁿ', '²', '■', '\u00A0',
+}
+
+// SetupEncoding picks how output characters above 127 reach the terminal: ascii (raw bytes), latin1 (the default) or cp437
+func (v *VM) SetupEncoding(name string) error {
+	switch name {
+	case "ascii", "latin1", "cp437":
+		v.encoding = name
+		return nil
+	}
+	return fmt.Errorf("want ascii, latin1 or cp437, got %q", name)
+}
+
+// putChar writes one character of guest output
+func (v *VM) putChar(char uint16) {
+	switch {
+	case v.encoding == "ascii":
+		v.Out.Write([]byte{byte(char)})
+	case v.encoding == "cp437" && char >= 0x80 && char <= 0xFF:
+		fmt.Fprintf(v.Out, "%c", cp437[char-0x80])
+	default: // latin1 lines up with the first 256 unicode code points
+		fmt.Fprintf(v.Out, "%c", rune(char))
+	}
+}
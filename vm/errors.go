@@ -0,0 +1,65 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+)
+
+// errors Run and Step return for faults in the program, match them with errors.Is and errors.As
+var (
+	ErrBadOpcode     = errors.New("bad opcode") // an instruction the machine doesn't have
+	ErrIO            = errors.New("console I/O failed")
+	ErrPrivilege     = errors.New("privilege violation")       // RTI in user mode, with no routine for the exception
+	ErrACV           = errors.New("access control violation")  // user mode going where the MPR says no, with no routine for the exception
+	ErrStackOverflow = errors.New("supervisor stack overflow") // state pushed for an interrupt, exception or trap below the stack limit
+
+	ErrMaxInstructions = errors.New("instruction limit reached") // not a fault, the budget set by WithMaxInstructions ran out
+)
+
+// ErrMemFault is an access to memory that isn't there, or a store to memory that is protected
+type ErrMemFault struct {
+	Addr      uint16
+	PC        uint16 // of the instruction making the access
+	Access    string // "read", "write" or "execute"
+	Protected bool   // the write went to a -protect region rather than unmapped memory
+}
+
+func (e ErrMemFault) Error() string {
+	switch {
+	case e.Protected:
+		return fmt.Sprintf("write to protected memory at 0x%04X (PC=0x%04X)", e.Addr, e.PC)
+	case e.Access == "execute":
+		return fmt.Sprintf("execution of unmapped address 0x%04X", e.Addr)
+	case e.Access == "write":
+		return fmt.Sprintf("write to unmapped address 0x%04X (PC=0x%04X)", e.Addr, e.PC)
+	}
+	return fmt.Sprintf("read of unmapped address 0x%04X (PC=0x%04X)", e.Addr, e.PC)
+}
+
+// ioError is a console read that failed, it matches ErrIO as well as the error underneath
+type ioError struct {
+	err error
+}
+
+func (e ioError) Error() string {
+	return e.err.Error()
+}
+
+func (e ioError) Unwrap() []error {
+	return []error{ErrIO, e.err}
+}
+
+// a fault in the running program, raised with panic by fault and turned back into an error by Run
+type faultError struct {
+	err error
+}
+
+// fault stops the program over a fatal problem in it, the error can wrap one of the Err values with %w
+func (v *VM) fault(format string, args ...any) {
+	panic(faultError{fmt.Errorf(format, args...)})
+}
+
+// memFault stops the program over a bad memory access
+func (v *VM) memFault(f ErrMemFault) {
+	panic(faultError{f})
+}
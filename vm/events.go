@@ -0,0 +1,86 @@
+package vm
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// what an Event is about
+const (
+	EVENT_REG   = iota // a general purpose register was written
+	EVENT_MEM          // a store went to memory, device registers included
+	EVENT_FLAGS        // the condition flags changed
+	EVENT_TRAP         // a TRAP instruction ran
+)
+
+// Event is one change the running program made
+type Event struct {
+	Kind     int
+	PC       uint16 // of the instruction that made the change
+	Count    uint64 // instructions executed, that one included
+	Reg      int    // EVENT_REG: which register
+	Addr     uint16 // EVENT_MEM: where the store went, EVENT_TRAP: the vector
+	Old, New uint16 // EVENT_REG, EVENT_MEM and EVENT_FLAGS: the value before and after
+}
+
+type eventState struct {
+	eventMu       sync.Mutex
+	subscribers   []chan Event // guarded by eventMu
+	subscribed    atomic.Bool  // there are subscribers, for the run loop to check without the lock
+	eventsDropped atomic.Uint64
+}
+
+// Subscribe returns a channel getting an Event for every register write, store, flag change
+// and trap from now on, in the order the machine made them. it can be called from any goroutine,
+// while the machine runs too. the machine never waits on a reader: an event that finds the
+// channel full (buffer says how far the machine can get ahead) is dropped and counted in
+// DroppedEvents, so give a reader that must see everything a buffer to match. Close closes
+// the channel
+func (v *VM) Subscribe(buffer int) <-chan Event {
+	ch := make(chan Event, buffer)
+	v.eventMu.Lock()
+	v.subscribers = append(v.subscribers, ch)
+	v.subscribed.Store(true)
+	v.eventMu.Unlock()
+	return ch
+}
+
+// DroppedEvents counts the events that found a subscriber's channel full, over all of them
+func (v *VM) DroppedEvents() uint64 {
+	return v.eventsDropped.Load()
+}
+
+func (v *VM) emit(ev Event) {
+	ev.Count = v.instrCount
+	v.eventMu.Lock()
+	defer v.eventMu.Unlock()
+	for _, ch := range v.subscribers {
+		select {
+		case ch <- ev:
+		default:
+			v.eventsDropped.Add(1)
+		}
+	}
+}
+
+// emitRegisters reports the registers the instruction at pc changed, the PC itself aside
+func (v *VM) emitRegisters(pc uint16, before [R_COUNT]uint16) {
+	for r := R_R0; r <= R_R7; r++ {
+		if v.reg[r] != before[r] {
+			v.emit(Event{Kind: EVENT_REG, PC: pc, Reg: r, Old: before[r], New: v.reg[r]})
+		}
+	}
+	if v.reg[R_COND] != before[R_COND] {
+		v.emit(Event{Kind: EVENT_FLAGS, PC: pc, Old: before[R_COND], New: v.reg[R_COND]})
+	}
+}
+
+func (v *VM) closeSubscribers() {
+	v.eventMu.Lock()
+	defer v.eventMu.Unlock()
+	for _, ch := range v.subscribers {
+		close(ch)
+	}
+	v.subscribers = nil
+	v.subscribed.Store(false)
+}
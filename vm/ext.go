@@ -0,0 +1,100 @@
+package vm
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+muldiv lives in the reserved opcode:
+
+	1101 DR SR1 F SR2    F: 000 MUL, 001 DIV, 010 MOD
+
+all signed, DR = SR1 op SR2, flags set on DR. the other functions are illegal instructions
+*/
+const (
+	EXT_MUL = 0x0
+	EXT_DIV = 0x1
+	EXT_MOD = 0x2
+)
+
+/*
+shift is the LC-3b SHF instruction, also in the reserved opcode:
+
+	1101 DR SR D1 D0 amount4    D: 00 LSHF, 01 RSHFL, 11 RSHFA, 10 is an illegal instruction
+*/
+const (
+	EXT_LSHF  = 0x0
+	EXT_RSHFL = 0x1
+	EXT_RSHFA = 0x3
+)
+
+type extensions struct {
+	extMulDiv bool
+	extShift  bool
+}
+
+// SetupExtensions turns on comma separated instruction set extensions: muldiv, shift
+func (v *VM) SetupExtensions(spec string) error {
+	if spec == "" {
+		return nil
+	}
+	for _, name := range strings.Split(spec, ",") {
+		switch strings.TrimSpace(name) {
+		case "muldiv":
+			v.extMulDiv = true
+		case "shift":
+			v.extShift = true
+		default:
+			return fmt.Errorf("unknown extension %q", name)
+		}
+	}
+	if v.extMulDiv && v.extShift {
+		return fmt.Errorf("muldiv and shift both use the reserved opcode, pick one")
+	}
+	return nil
+}
+
+func (v *VM) execMulDiv(instr uint16, pc uint16) {
+	r0 := (instr >> 9) & 0x7
+	r1 := (instr >> 6) & 0x7
+	r2 := instr & 0x7
+	a, b := int16(v.reg[r1]), int16(v.reg[r2])
+
+	switch (instr >> 3) & 0x7 {
+	case EXT_MUL:
+		v.reg[r0] = uint16(a * b)
+	case EXT_DIV, EXT_MOD:
+		if b == 0 {
+			v.fault("division by zero (PC=0x%04X)", pc)
+		}
+		if (instr>>3)&0x7 == EXT_DIV {
+			v.reg[r0] = uint16(a / b)
+		} else {
+			v.reg[r0] = uint16(a % b)
+		}
+	default: // a reserved encoding, which an OS can catch like the opcode
+		v.exception(EXC_ILLEGAL, fmt.Errorf("%w 0x%04X, no muldiv function %d (PC=0x%04X)", ErrBadOpcode, instr, (instr>>3)&0x7, pc))
+		return
+	}
+	v.updateFlags(int(r0))
+}
+
+func (v *VM) execShift(instr uint16, pc uint16) {
+	r0 := (instr >> 9) & 0x7
+	r1 := (instr >> 6) & 0x7
+	amount := instr & 0xF
+
+	switch (instr >> 4) & 0x3 {
+	case EXT_LSHF:
+		v.reg[r0] = v.reg[r1] << amount
+	case EXT_RSHFL:
+		v.reg[r0] = v.reg[r1] >> amount
+	case EXT_RSHFA:
+		v.reg[r0] = uint16(int16(v.reg[r1]) >> amount)
+	default: // D 10 is reserved
+		v.exception(EXC_ILLEGAL, fmt.Errorf("%w 0x%04X, no shift direction %d (PC=0x%04X)", ErrBadOpcode, instr, (instr>>4)&0x3, pc))
+		return
+	}
+	v.updateFlags(int(r0))
+}
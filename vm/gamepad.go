@@ -0,0 +1,111 @@
+package vm
+
+import (
+	"time"
+
+	"github.com/eiannone/keyboard"
+)
+
+const (
+	MR_PAD = 0xFE60 // gamepad buttons held down, one bit each
+
+	PAD_UP     = 1 << 0
+	PAD_DOWN   = 1 << 1
+	PAD_LEFT   = 1 << 2
+	PAD_RIGHT  = 1 << 3
+	PAD_A      = 1 << 4
+	PAD_B      = 1 << 5
+	PAD_START  = 1 << 6
+	PAD_SELECT = 1 << 7
+
+	// a terminal only reports key presses, so a button counts as held until this long after
+	// the last one. it covers the pause before the host's key repeat kicks in
+	PAD_HOLD = 600 * time.Millisecond
+)
+
+// the keys standing in for the buttons
+var padKeys = map[keyboard.Key]uint16{
+	keyboard.KeyArrowUp:    PAD_UP,
+	keyboard.KeyArrowDown:  PAD_DOWN,
+	keyboard.KeyArrowLeft:  PAD_LEFT,
+	keyboard.KeyArrowRight: PAD_RIGHT,
+	keyboard.KeyEnter:      PAD_START,
+	keyboard.KeyTab:        PAD_SELECT,
+}
+
+var padRunes = map[rune]uint16{'z': PAD_A, 'x': PAD_B}
+
+type gamepad struct {
+	padOn      bool
+	padPressed [8]time.Time        // when each button's key was last pressed
+	padHeld    uint16              // the buttons pressed since the last read, for deterministic mode
+	padQueue   []keyboard.KeyEvent // other keys that came in, still to be read from the console
+}
+
+// SetupGamepad turns the arrow keys, z, x, enter and tab into the gamepad at xFE60 rather than
+// console input. other keys still reach the console
+func (v *VM) SetupGamepad(on bool) {
+	v.padOn = on
+}
+
+func padButton(ev keyboard.KeyEvent) uint16 {
+	if ev.Rune != 0 {
+		return padRunes[ev.Rune]
+	}
+	return padKeys[ev.Key]
+}
+
+// padRead takes in every key press waiting and gives the buttons held down
+func (v *VM) padRead() uint16 {
+	for {
+		ev, ok := v.rawKey()
+		if !ok {
+			break
+		}
+		if !v.padPress(ev) {
+			v.padQueue = append(v.padQueue, ev)
+		} else if v.deterministic {
+			break
+		}
+	}
+
+	if v.deterministic { // no clock to go by, each read takes in up to one press and shows it once
+		held := v.padHeld
+		v.padHeld = 0
+		return held
+	}
+	now := time.Now()
+	var held uint16
+	for i, at := range v.padPressed {
+		if !at.IsZero() && now.Sub(at) < PAD_HOLD {
+			held |= 1 << i
+		}
+	}
+	v.padHeld = 0
+	return held
+}
+
+// padPress notes the press if it's one of the gamepad's keys
+func (v *VM) padPress(ev keyboard.KeyEvent) bool {
+	button := padButton(ev)
+	if button == 0 {
+		return false
+	}
+	v.padHeld |= button
+	for i := range v.padPressed {
+		if button == 1<<i {
+			v.padPressed[i] = time.Now()
+		}
+	}
+	return true
+}
+
+// padKey takes the next console key the gamepad held back
+func (v *VM) padKey() (keyboard.KeyEvent, bool) {
+	if len(v.padQueue) == 0 {
+		return keyboard.KeyEvent{}, false
+	}
+	ev := v.padQueue[0]
+	v.padQueue = v.padQueue[1:]
+	return ev, true
+}
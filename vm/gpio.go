@@ -0,0 +1,125 @@
+package vm
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	MR_GPI = 0xFEA8 // the input pins, driven by the script
+	MR_GPO = 0xFEAA // the output pins, every change is logged
+)
+
+// one line of a GPIO script or log, or a sensor trace: from ms milliseconds into the run the value holds
+type traceStep struct {
+	ms    uint64
+	value uint16
+}
+
+type gpio struct {
+	gpioOn     bool
+	gpioScript []traceStep
+	gpioNext   int // the script's first step still to come
+	gpioLog    *bufio.Writer
+	gpioFile   *os.File
+}
+
+// SetupGPIO turns on the GPIO port. the input pins at xFEA8 follow the script at in, CSV lines of
+// milliseconds into the run and the pins' value from then on, like
+//
+//	# ms,pins
+//	0,x0000
+//	250,x0001
+//	1500,x0003
+//
+// and every change the program makes to the output pins at xFEAA is written to out in the same
+// form. either can be "". in deterministic mode a thousand instructions take a millisecond
+func (v *VM) SetupGPIO(in, out string) error {
+	if in == "" && out == "" {
+		return nil
+	}
+	if in != "" {
+		script, err := readTrace(in)
+		if err != nil {
+			return err
+		}
+		v.gpioScript = script
+	}
+	if out != "" {
+		file, err := os.Create(out)
+		if err != nil {
+			return err
+		}
+		v.gpioFile = file
+		v.gpioLog = bufio.NewWriter(file)
+	}
+	v.gpioOn = true
+	return nil
+}
+
+// readTrace reads CSV lines of ms,value, a value can be negative
+func readTrace(path string) ([]traceStep, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var script []traceStep
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		ms, value, ok := strings.Cut(line, ",")
+		step := traceStep{}
+		if ok {
+			step.ms, err = strconv.ParseUint(strings.TrimSpace(ms), 10, 64)
+		}
+		if ok && err == nil {
+			step.value, err = parseWord(value)
+		}
+		if !ok || err != nil {
+			return nil, fmt.Errorf("%s:%d: want ms,value", path, n+1)
+		}
+		if len(script) > 0 && step.ms < script[len(script)-1].ms {
+			return nil, fmt.Errorf("%s:%d: goes back in time", path, n+1)
+		}
+		script = append(script, step)
+	}
+	return script, nil
+}
+
+// parseWord reads an address style number, or a negative decimal one
+func parseWord(s string) (uint16, error) {
+	if n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 16); err == nil {
+		return uint16(n), nil
+	}
+	return ParseAddr(s)
+}
+
+// gpioRead gives the input pins as the script has them now
+func (v *VM) gpioRead() uint16 {
+	now := v.runMillis()
+	for v.gpioNext < len(v.gpioScript) && v.gpioScript[v.gpioNext].ms <= now {
+		v.memory[MR_GPI] = v.gpioScript[v.gpioNext].value
+		v.gpioNext++
+	}
+	return v.memory[MR_GPI]
+}
+
+func (v *VM) gpioWrite(value uint16) {
+	if value != v.memory[MR_GPO] && v.gpioLog != nil {
+		fmt.Fprintf(v.gpioLog, "%d,x%04X\n", v.runMillis(), value)
+	}
+	v.memory[MR_GPO] = value
+}
+
+func (v *VM) closeGPIO() {
+	if v.gpioFile != nil {
+		v.gpioLog.Flush()
+		v.gpioFile.Close()
+		v.gpioFile, v.gpioLog = nil, nil
+	}
+}
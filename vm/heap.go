@@ -0,0 +1,95 @@
+package vm
+
+import (
+	"fmt"
+	"io"
+)
+
+// what MALLOC hands back when nothing fits. the heap stays below the device page, so it's never
+// an address a block can start at, and ADD R0, R0, #1 turns it into zero for a BRz
+const HEAP_FAIL = 0xFFFF
+
+// a run of heap words, either handed out or free
+type heapBlock struct {
+	start uint16
+	size  int
+	free  bool
+}
+
+type heapState struct {
+	heap []heapBlock // kept on the host side so a buggy program can't corrupt the bookkeeping
+}
+
+func (b heapBlock) end() int {
+	return int(b.start) + b.size - 1
+}
+
+// SetupHeap hands the region start-end to the MALLOC/FREE traps
+func (v *VM) SetupHeap(spec string) error {
+	if spec == "" {
+		return nil
+	}
+	start, end, err := ParseRange(spec)
+	if err != nil {
+		return err
+	}
+	if end >= DEVICE_START {
+		return fmt.Errorf("the heap x%04X-x%04X runs into the device page", start, end)
+	}
+	v.heap = []heapBlock{{start: start, size: int(end) - int(start) + 1, free: true}}
+	return nil
+}
+
+// heapAlloc is a first fit allocator, returns HEAP_FAIL when nothing fits
+func (v *VM) heapAlloc(size int) uint16 {
+	if size <= 0 {
+		return HEAP_FAIL
+	}
+	for i, b := range v.heap {
+		if !b.free || b.size < size {
+			continue
+		}
+		if b.size > size { // split off the rest
+			rest := heapBlock{start: b.start + uint16(size), size: b.size - size, free: true}
+			v.heap = append(v.heap[:i+1], append([]heapBlock{rest}, v.heap[i+1:]...)...)
+		}
+		v.heap[i] = heapBlock{start: b.start, size: size}
+		return b.start
+	}
+	return HEAP_FAIL
+}
+
+func (v *VM) heapFree(address uint16) error {
+	for i, b := range v.heap {
+		if b.start != address {
+			continue
+		}
+		if b.free {
+			return fmt.Errorf("double free of heap block at 0x%04X", address)
+		}
+		v.heap[i].free = true
+		// merge with free neighbours
+		if i+1 < len(v.heap) && v.heap[i+1].free {
+			v.heap[i].size += v.heap[i+1].size
+			v.heap = append(v.heap[:i+1], v.heap[i+2:]...)
+		}
+		if i > 0 && v.heap[i-1].free {
+			v.heap[i-1].size += v.heap[i].size
+			v.heap = append(v.heap[:i], v.heap[i+1:]...)
+		}
+		return nil
+	}
+	return fmt.Errorf("free of 0x%04X which is not an allocated heap block", address)
+}
+
+// PrintHeap lists the heap blocks and whether they're in use
+func (v *VM) PrintHeap(w io.Writer) {
+	fmt.Fprintln(w, "heap layout:")
+	for _, b := range v.heap {
+		state := "used"
+		if b.free {
+			state = "free"
+		}
+		fmt.Fprintf(w, "  0x%04X-0x%04X  %5d words  %s\n", b.start, b.end(), b.size, state)
+	}
+}
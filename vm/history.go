@@ -0,0 +1,84 @@
+package vm
+
+import (
+	"fmt"
+	"io"
+
+	"lc3/decode"
+)
+
+var regNames = [R_COUNT]string{"R0", "R1", "R2", "R3", "R4", "R5", "R6", "R7", "PC", "CC"}
+
+// one executed instruction and the registers around it
+type historyEntry struct {
+	pc, instr     uint16
+	before, after [R_COUNT]uint16
+}
+
+// a flight recorder of the last few instructions, always on since it only costs a couple of copies
+type historyState struct {
+	history     []historyEntry
+	historyNext int // slot the current instruction goes in
+	historyLen  int // filled slots, not counting the current one
+}
+
+// SetupHistory sets how many recently executed instructions to keep for fault reports (0 = none), 16 by default
+func (v *VM) SetupHistory(size int) {
+	v.history, v.historyNext, v.historyLen = nil, 0, 0
+	if size > 0 {
+		v.history = make([]historyEntry, size)
+	}
+}
+
+// historyBegin notes the instruction about to run
+func (v *VM) historyBegin(pc, instr uint16) {
+	if v.history == nil {
+		return
+	}
+	e := &v.history[v.historyNext]
+	e.pc, e.instr, e.before = pc, instr, v.reg
+}
+
+// historyEnd completes the entry once the instruction has run
+func (v *VM) historyEnd() {
+	if v.history == nil {
+		return
+	}
+	v.history[v.historyNext].after = v.reg
+	v.historyNext = (v.historyNext + 1) % len(v.history)
+	if v.historyLen < len(v.history) {
+		v.historyLen++
+	}
+}
+
+func printHistoryEntry(w io.Writer, e historyEntry, done bool) {
+	fmt.Fprintf(w, "  0x%04X  0x%04X  %-4s", e.pc, e.instr, decode.OpNames[e.instr>>12])
+	if !done {
+		fmt.Fprintln(w, "  <- faulted here")
+		return
+	}
+	for r := 0; r < R_COUNT; r++ {
+		if r != R_PC && e.before[r] != e.after[r] {
+			fmt.Fprintf(w, "  %s: 0x%04X->0x%04X", regNames[r], e.before[r], e.after[r])
+		}
+	}
+	fmt.Fprintln(w)
+}
+
+// DumpHistory prints the recorded instructions oldest first, ending with the one that was running
+// when the last run faulted
+func (v *VM) DumpHistory(w io.Writer) {
+	if v.history == nil {
+		return
+	}
+	fmt.Fprintln(w, "recent instructions:")
+	// the current slot, if full, is overwritten by the running instruction so there's one less to show
+	start := v.historyNext - v.historyLen
+	if v.historyLen == len(v.history) {
+		start++
+	}
+	for i := start; i < v.historyNext; i++ {
+		printHistoryEntry(w, v.history[(i+len(v.history))%len(v.history)], true)
+	}
+	printHistoryEntry(w, v.history[v.historyNext], false)
+}
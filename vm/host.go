@@ -0,0 +1,35 @@
+package vm
+
+import "os"
+
+// readString collects the null terminated string at address, one char per word
+func (v *VM) readString(address uint16) string {
+	var s []rune
+	for i := address; v.memory[i] != 0; i++ {
+		s = append(s, rune(v.memory[i]))
+		if i == 0xFFFF {
+			break
+		}
+	}
+	return string(s)
+}
+
+// getEnv copies the host variable named at nameAddr into buf, null terminated and cut to max chars.
+// returns the number of chars copied, or 0xFFFF (-1) when the variable isn't set
+func (v *VM) getEnv(nameAddr, buf uint16, max int) uint16 {
+	value, ok := os.LookupEnv(v.readString(nameAddr))
+	if !ok {
+		return 0xFFFF
+	}
+
+	n := 0
+	for _, char := range value {
+		if n == max {
+			break
+		}
+		v.memWrite(buf+uint16(n), uint16(char))
+		n++
+	}
+	v.memWrite(buf+uint16(n), 0)
+	return uint16(n)
+}
@@ -0,0 +1,297 @@
+package vm
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	INTERRUPT_TABLE_START = 0x0100 // interrupt vector table, the address of the service routine for each vector
+	INTERRUPT_TABLE_END   = 0x01FF
+
+	// exceptions go through the first entries of the same table
+	EXC_PRIVILEGE = 0x00
+	EXC_ILLEGAL   = 0x01
+	EXC_ACV       = 0x02 // access control violation
+	EXC_STACK     = 0x03 // supervisor stack overflow, taken on a fresh stack, with -ssp-overflow exception
+
+	MR_PSR   = 0xFFFC  // the processor status register, for system code: writes set the priority and condition codes
+	PSR_USER = 1 << 15 // running in user mode rather than supervisor mode
+
+	SSP_START = 0x3000 // the supervisor stack grows down from here until system code moves it
+	SSP_LIMIT = 0x0200 // and must stay above the vector tables
+)
+
+// the built-in devices that interrupt, by name, and the priority they do it at unless told otherwise
+var deviceVectors = map[string]uint8{"keyboard": KEYBOARD_VECTOR, "mailbox": MAILBOX_VECTOR, "nic": NIC_VECTOR, "sensor": SENSOR_VECTOR, "watchdog": WATCHDOG_VECTOR}
+
+var defaultPriorities = map[uint8]int{KEYBOARD_VECTOR: KEYBOARD_PRIORITY, MAILBOX_VECTOR: MAILBOX_PRIORITY, NIC_VECTOR: NIC_PRIORITY, SENSOR_VECTOR: SENSOR_PRIORITY, WATCHDOG_VECTOR: WATCHDOG_PRIORITY}
+
+// an interrupt waiting to be taken
+type pendingInterrupt struct {
+	vector   uint8
+	priority int
+}
+
+type interrupts struct {
+	user       bool // in user mode, PSR[15]
+	startUser  bool // programs start out in user mode
+	priority   int  // of what's running now, PL0 to PL7
+	intMu      sync.Mutex
+	intPending []pendingInterrupt // asserted and not taken yet, guarded by intMu
+	intHighest atomic.Int32       // highest priority in intPending or -1, the run loop only takes the lock when it beats the running one
+
+	devicePriority map[uint8]int // priorities given to built-in devices' interrupts by vector, the rest use defaultPriorities
+
+	excRaised     bool // the instruction running caused an exception, it's taken once the instruction is undone
+	inInstruction bool // executeOp is running, an exception raised now unwinds the instruction
+	excVector     uint8
+	excUnhandled  bool // there's no routine for it, the program stops
+	unhandledHalt bool // an exception with no routine halts rather than faults
+
+	// R6 of the mode not running: the supervisor stack pointer while in user mode and the other way round
+	savedSSP, savedUSP uint16
+	sspStart           uint16 // savedSSP at power on
+	sspLimit           uint16 // the lowest address a push onto the supervisor stack may write
+	sspException       bool   // an overflow takes EXC_STACK rather than stopping the program
+}
+
+// AssertInterrupt raises an interrupt through vector at priority (0-7). it can be called from any
+// goroutine: the machine takes it between instructions, as soon as it's running at a lower priority,
+// by pushing the PSR and PC on the supervisor stack and jumping to the address in the interrupt vector
+// table at x0100+vector. until then it stays pending
+func (v *VM) AssertInterrupt(vector uint8, priority int) error {
+	if priority < 0 || priority > 7 {
+		return fmt.Errorf("priority %d is not between 0 and 7", priority)
+	}
+	v.intMu.Lock()
+	defer v.intMu.Unlock()
+	v.intPending = append(v.intPending, pendingInterrupt{vector, priority})
+	if int32(priority) > v.intHighest.Load() {
+		v.intHighest.Store(int32(priority))
+	}
+	return nil
+}
+
+// RaiseInterrupt is AssertInterrupt for tests and debuggers driving the machine with Step: the
+// next Step takes the interrupt, if it beats the priority running, and runs the first instruction
+// of its service routine, so a handler can be tried without waiting on a device. it panics on a
+// priority outside 0-7
+func (v *VM) RaiseInterrupt(vector uint8, priority int) {
+	if err := v.AssertInterrupt(vector, priority); err != nil {
+		panic(err)
+	}
+}
+
+// SetupPriorities gives built-in devices the priority their interrupts are raised at, spec is
+// comma separated name=PL, e.g. "keyboard=6,sensor=2". the devices are keyboard (PL4 unless told
+// otherwise), mailbox, nic and sensor (PL3) and watchdog (PL7), when it interrupts
+func (v *VM) SetupPriorities(spec string) error {
+	if spec == "" {
+		return nil
+	}
+	priorities := map[uint8]int{}
+	for _, item := range strings.Split(spec, ",") {
+		name, level, _ := strings.Cut(strings.TrimSpace(item), "=")
+		vector, ok := deviceVectors[name]
+		if !ok {
+			return fmt.Errorf("no device %q interrupts, want keyboard, mailbox, nic, sensor or watchdog", name)
+		}
+		priority, err := strconv.Atoi(strings.TrimPrefix(strings.ToUpper(level), "PL"))
+		if err != nil || priority < 0 || priority > 7 {
+			return fmt.Errorf("want %s=0 to 7, got %q", name, item)
+		}
+		priorities[vector] = priority
+	}
+	v.devicePriority = priorities
+	return nil
+}
+
+// deviceInterrupt raises a built-in device's interrupt at the priority it's been given
+func (v *VM) deviceInterrupt(vector uint8) {
+	priority, ok := v.devicePriority[vector]
+	if !ok {
+		priority = defaultPriorities[vector]
+	}
+	v.AssertInterrupt(vector, priority)
+}
+
+// SetupSupervisorStack moves where the supervisor stack starts, at power on and after a reset,
+// from SSP_START (x3000)
+func (v *VM) SetupSupervisorStack(start uint16) {
+	v.sspStart, v.savedSSP = start, start
+}
+
+// SetupStackOverflow sets how low the supervisor stack may grow, pushing the PSR and PC any lower is an
+// overflow. action "fault" stops the program with an error saying where, "exception" starts the stack over from where it
+// started at power on and takes the exception at x03 on it, like a double fault
+func (v *VM) SetupStackOverflow(limit uint16, action string) error {
+	if action != "fault" && action != "exception" {
+		return fmt.Errorf("want fault or exception, got %q", action)
+	}
+	v.sspLimit, v.sspException = limit, action == "exception"
+	return nil
+}
+
+// SavedSP gives the Saved_SSP and Saved_USP registers, the stack pointers of the modes not running.
+// the one of the mode running is R6, the other one is stale
+func (v *VM) SavedSP() (ssp, usp uint16) {
+	return v.savedSSP, v.savedUSP
+}
+
+// SetupUserMode starts programs in user mode, where RTI and, with the MPR, some of memory are off
+// limits. interrupts and exceptions still run their routines in supervisor mode
+func (v *VM) SetupUserMode(on bool) {
+	v.startUser, v.user = on, on
+}
+
+// PSR gives the processor status register: the mode in bit 15, priority in bits 10-8,
+// condition codes in 2-0
+func (v *VM) PSR() uint16 {
+	return v.psr()
+}
+
+// psr puts the processor status register together
+func (v *VM) psr() uint16 {
+	psr := uint16(v.priority)<<8 | v.reg[R_COND]&0x7
+	if v.user {
+		psr |= PSR_USER
+	}
+	return psr
+}
+
+// SetupUnhandled picks what an exception whose vector table entry is still 0 does: "fault" stops the
+// run with an error saying what happened, "halt" prints that and halts the way HALT would
+func (v *VM) SetupUnhandled(action string) error {
+	if action != "fault" && action != "halt" {
+		return fmt.Errorf("want fault or halt, got %q", action)
+	}
+	v.unhandledHalt = action == "halt"
+	return nil
+}
+
+// exception raises the exception at vector for the instruction running, err says what went wrong
+// for when there's no routine to handle it
+func (v *VM) exception(vector uint8, err error) {
+	if v.memory[INTERRUPT_TABLE_START+uint16(vector)] == 0 {
+		if !v.unhandledHalt {
+			panic(faultError{err})
+		}
+		if !v.excRaised {
+			log.Printf("%v, no handler for exception x%02X", err, vector)
+			v.excUnhandled = true
+		}
+	}
+	v.raiseException(vector)
+}
+
+// setPSR takes a write to the PSR register, the mode only changes through RTI and the service routines
+func (v *VM) setPSR(value uint16) {
+	v.priority = int(value >> 8 & 0x7)
+	if cc := value & 0x7; cc == FL_NEG || cc == FL_ZRO || cc == FL_POS {
+		v.reg[R_COND] = cc
+	}
+}
+
+// exceptionUnwind is what an exception panics with to stop the instruction that raised it
+type exceptionUnwind struct{}
+
+// raiseException marks the instruction running as having caused an exception and stops it
+func (v *VM) raiseException(vector uint8) {
+	if !v.excRaised {
+		v.excRaised, v.excVector = true, vector
+	}
+	if v.inInstruction {
+		panic(exceptionUnwind{})
+	}
+}
+
+// enterSupervisor pushes the PSR and PC on the supervisor stack and switches to supervisor mode.
+// coming from user mode R6 is swapped for the supervisor stack pointer first. it's false when the
+// stack overflowed and the state went on a fresh stack instead, for the overflow exception
+func (v *VM) enterSupervisor() bool {
+	psr := v.psr()
+	if v.user {
+		v.savedUSP, v.reg[R_R6] = v.reg[R_R6], v.savedSSP
+		v.user = false
+	}
+	ok := true
+	if sp := v.reg[R_R6]; sp != 0 && sp < v.sspLimit+2 { // R6 at 0 wraps around to the top of memory
+		err := fmt.Errorf("%w: pushing the PSR and PC at R6=x%04X goes below x%04X (PC=0x%04X)", ErrStackOverflow, sp, v.sspLimit, v.reg[R_PC])
+		if !v.sspException || v.memory[INTERRUPT_TABLE_START+EXC_STACK] == 0 {
+			panic(faultError{err})
+		}
+		log.Print(err)
+		v.reg[R_R6], ok = v.sspStart, false
+	}
+	v.reg[R_R6]--
+	v.memWrite(v.reg[R_R6], psr)
+	v.reg[R_R6]--
+	v.memWrite(v.reg[R_R6], v.reg[R_PC])
+	return ok
+}
+
+// serviceRoutine starts the routine the vector table has for vector, in supervisor mode at priority
+func (v *VM) serviceRoutine(vector uint8, priority int) {
+	if !v.enterSupervisor() {
+		vector = EXC_STACK
+	}
+	v.priority = priority
+	v.reg[R_PC] = v.memRead(INTERRUPT_TABLE_START + uint16(vector))
+}
+
+// rti returns from a service routine: the PC and PSR come off the supervisor stack, and going back
+// to user mode R6 is swapped for the user stack pointer
+func (v *VM) rti() {
+	if v.user { // only system code can
+		v.exception(EXC_PRIVILEGE, fmt.Errorf("%w, RTI in user mode (PC=0x%04X)", ErrPrivilege, v.reg[R_PC]-1))
+		return
+	}
+	pc := v.memRead(v.reg[R_R6])
+	v.reg[R_R6]++
+	psr := v.memRead(v.reg[R_R6])
+	v.reg[R_R6]++
+
+	v.reg[R_PC] = pc
+	v.reg[R_COND] = psr & 0x7
+	v.priority = int(psr >> 8 & 0x7)
+	if psr&PSR_USER != 0 {
+		v.savedSSP, v.reg[R_R6] = v.reg[R_R6], v.savedUSP
+		v.user = true
+	}
+}
+
+// takeInterrupt starts the service routine of the highest priority pending interrupt,
+// if it's above the priority the machine is running at. that can be in the middle of another
+// service routine: its PSR and PC are pushed over the first one's and RTI unwinds them in turn
+func (v *VM) takeInterrupt() {
+	v.intMu.Lock()
+	best := -1
+	for i, in := range v.intPending {
+		if in.priority > v.priority && (best < 0 || in.priority > v.intPending[best].priority) {
+			best = i
+		}
+	}
+	if best < 0 {
+		v.intMu.Unlock()
+		return
+	}
+	in := v.intPending[best]
+	v.intPending = append(v.intPending[:best], v.intPending[best+1:]...)
+	v.intHighest.Store(highestPending(v.intPending))
+	v.intMu.Unlock()
+
+	v.serviceRoutine(in.vector, in.priority)
+}
+
+func highestPending(pending []pendingInterrupt) int32 {
+	highest := int32(-1)
+	for _, in := range pending {
+		highest = max(highest, int32(in.priority))
+	}
+	return highest
+}
@@ -0,0 +1,26 @@
+package vm
+
+const (
+	KEYBOARD_VECTOR   = 0x80 // the keyboard's entry in the interrupt vector table
+	KEYBOARD_PRIORITY = 4
+)
+
+// keyboardInterrupt is checked between instructions while KBSR's interrupt enable bit is set:
+// a key press is put in KBDR, KBSR shows ready and the keyboard interrupt is raised through x80, at
+// PL4 unless SetupPriorities says otherwise. the key stays there, with no more interrupts, until the
+// program reads KBDR
+func (v *VM) keyboardInterrupt() {
+	if v.kbdLatched {
+		return
+	}
+	if v.kbsrControl&KBSR_SCANCODE != 0 {
+		v.pollScancode()
+	} else if char, ok := v.pollChar(); ok {
+		v.memory[MR_KBSR] = KBSR_READY | v.kbsrControl
+		v.memory[MR_KBDR] = char
+	}
+	if v.memory[MR_KBSR]&KBSR_READY != 0 {
+		v.kbdLatched = true
+		v.deviceInterrupt(KEYBOARD_VECTOR)
+	}
+}
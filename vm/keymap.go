@@ -0,0 +1,103 @@
+package vm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/eiannone/keyboard"
+)
+
+// what a remapped key turns into
+type keyTarget struct {
+	char uint16
+	halt bool // stop the machine instead
+}
+
+type keymapState struct {
+	charMap    map[uint16]keyTarget       // keys that produce a character code
+	specialMap map[keyboard.Key]keyTarget // arrows, function keys and the like, which don't
+
+	haltRequested bool // a key bound to halt was pressed
+}
+
+var specialKeyNames = map[string]keyboard.Key{
+	"up": keyboard.KeyArrowUp, "down": keyboard.KeyArrowDown, "left": keyboard.KeyArrowLeft, "right": keyboard.KeyArrowRight,
+	"home": keyboard.KeyHome, "end": keyboard.KeyEnd, "pgup": keyboard.KeyPgup, "pgdn": keyboard.KeyPgdn,
+	"insert": keyboard.KeyInsert, "delete": keyboard.KeyDelete,
+	"f1": keyboard.KeyF1, "f2": keyboard.KeyF2, "f3": keyboard.KeyF3, "f4": keyboard.KeyF4, "f5": keyboard.KeyF5, "f6": keyboard.KeyF6,
+	"f7": keyboard.KeyF7, "f8": keyboard.KeyF8, "f9": keyboard.KeyF9, "f10": keyboard.KeyF10, "f11": keyboard.KeyF11, "f12": keyboard.KeyF12,
+}
+
+var charKeyNames = map[string][]uint16{
+	"enter": {CHAR_ENTER}, "tab": {0x09}, "esc": {0x1B}, "space": {' '},
+	"backspace": {CHAR_BACKSPACE, CHAR_DELETE}, // terminals disagree on which one the key sends
+}
+
+// parseKeyChar reads a character code written as xNN, a key name or the character itself
+func parseKeyChar(s string) ([]uint16, error) {
+	if codes, ok := charKeyNames[strings.ToLower(s)]; ok {
+		return codes, nil
+	}
+	if name := strings.ToLower(s); strings.HasPrefix(name, "ctrl-") && len(name) == 6 && name[5] >= 'a' && name[5] <= 'z' {
+		return []uint16{uint16(name[5]-'a') + 1}, nil
+	}
+	if runes := []rune(s); len(runes) == 1 {
+		return []uint16{uint16(runes[0])}, nil
+	}
+	code, err := ParseAddr(s)
+	if err != nil {
+		return nil, fmt.Errorf("unknown key %q", s)
+	}
+	return []uint16{code}, nil
+}
+
+// SetupKeymap takes comma separated input remaps from=to, e.g. enter=x0A,backspace=x08,ctrl-q=halt
+func (v *VM) SetupKeymap(spec string) error {
+	if spec == "" {
+		return nil
+	}
+	for _, part := range strings.Split(spec, ",") {
+		from, to, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return fmt.Errorf("want from=to, got %q", part)
+		}
+
+		target := keyTarget{halt: strings.EqualFold(to, "halt")}
+		if !target.halt {
+			codes, err := parseKeyChar(to)
+			if err != nil {
+				return err
+			}
+			target.char = codes[0]
+		}
+
+		if key, ok := specialKeyNames[strings.ToLower(from)]; ok {
+			v.specialMap[key] = target
+			continue
+		}
+		codes, err := parseKeyChar(from)
+		if err != nil {
+			return err
+		}
+		for _, code := range codes {
+			v.charMap[code] = target
+		}
+	}
+	return nil
+}
+
+// mapKey turns a key press into the character the program sees, after the remaps
+func (v *VM) mapKey(ev keyboard.KeyEvent) uint16 {
+	target, ok := v.charMap[keyChar(ev)]
+	if ev.Rune == 0 && ev.Key > keyboard.KeySpace && ev.Key != keyboard.KeyBackspace2 {
+		target, ok = v.specialMap[ev.Key]
+	}
+	if !ok {
+		return keyChar(ev)
+	}
+	if target.halt {
+		v.haltRequested = true
+		return 0
+	}
+	return target.char
+}
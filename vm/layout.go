@@ -0,0 +1,102 @@
+package vm
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// the register blocks of the built-in devices, by the names SetupDeviceAddress knows them by
+var deviceRegisters = map[string]struct {
+	base  uint16
+	words int
+}{
+	"keyboard": {MR_KBSR, 4},
+	"display":  {MR_DSR, 4},
+	"timer":    {MR_TMR, 4},
+	"watchdog": {MR_WDT, 2},
+	"bank":     {MR_BANK, 2},
+	"clock":    {MR_RTCH, 6},
+	"random":   {MR_RNG, 2},
+	"disk":     {MR_DSKS, 8},
+	"serial":   {MR_SERIAL, SERIAL_WORDS},
+	"serial2":  {MR_SERIAL2, SERIAL_WORDS},
+	"beep":     {MR_BEEPF, 4},
+	"pcm":      {MR_PCMD, 4},
+	"gamepad":  {MR_PAD, 2},
+	"mailbox":  {MR_MBS, 8},
+	"nic":      {MR_NICS, 6},
+	"perf":     {MR_PERF, PERF_END - MR_PERF + 2},
+	"board":    {MR_SW, 16},
+	"args":     {MR_ARGC, 6},
+	"gpio":     {MR_GPI, 4},
+	"printer":  {MR_LPS, 4},
+	"sensor":   {MR_SENS, 6},
+}
+
+type deviceLayout struct {
+	// the program's address for a moved register and the register's own, both ways round, so the
+	// registers still live at their own addresses and what the program sees at those is the
+	// memory behind where they went. nil while nothing has moved
+	deviceMoves map[uint16]uint16
+	deviceBases map[string]uint16 // where the moved devices start now
+}
+
+// DeviceNames lists the built-in devices whose registers SetupDeviceAddress can move
+func DeviceNames() []string {
+	return slices.Sorted(maps.Keys(deviceRegisters))
+}
+
+// SetupDeviceAddress moves a built-in device's registers to start at base, keeping their order and
+// spacing, to lay out the device page like a particular board. the new place has to be in the device
+// page, below the PSR, and clear of every device's registers, moved or not, and of plugins
+func (v *VM) SetupDeviceAddress(name string, base uint16) error {
+	regs, ok := deviceRegisters[name]
+	if !ok {
+		return fmt.Errorf("no device %q, want one of %s", name, strings.Join(DeviceNames(), ", "))
+	}
+	if _, ok := v.deviceBases[name]; ok {
+		return fmt.Errorf("the %s registers have already been moved", name)
+	}
+	if base == regs.base {
+		return nil
+	}
+	end := int(base) + regs.words
+	if base < DEVICE_START || end > MR_PSR {
+		return fmt.Errorf("the %s registers at x%04X-x%04X don't fit in the device page below x%04X", name, base, end-1, MR_PSR)
+	}
+	for other, r := range deviceRegisters {
+		starts := []uint16{r.base}
+		if moved, ok := v.deviceBases[other]; ok {
+			starts = append(starts, moved)
+		}
+		for _, start := range starts {
+			if int(base) < int(start)+r.words && int(start) < end {
+				return fmt.Errorf("the %s registers at x%04X-x%04X overlap the %s registers at x%04X", name, base, end-1, other, start)
+			}
+		}
+	}
+	for address := int(base); address < end; address++ {
+		if v.deviceAt[uint16(address)] != nil {
+			return fmt.Errorf("the %s registers at x%04X-x%04X overlap a plugin at x%04X", name, base, end-1, address)
+		}
+	}
+
+	if v.deviceMoves == nil {
+		v.deviceMoves, v.deviceBases = map[uint16]uint16{}, map[string]uint16{}
+	}
+	for i := range uint16(regs.words) {
+		v.deviceMoves[base+i], v.deviceMoves[regs.base+i] = regs.base+i, base+i
+	}
+	v.deviceBases[name] = base
+	return nil
+}
+
+// deviceAddress is where the register the program addresses really is
+func (v *VM) deviceAddress(address uint16) uint16 {
+	if moved, ok := v.deviceMoves[address]; ok {
+		return moved
+	}
+	return address
+}
@@ -0,0 +1,55 @@
+package vm
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// parts of memory images shouldn't land on
+var systemAreas = []struct {
+	name       string
+	start, end int
+}{
+	{"trap vector table", TRAP_TABLE_START, TRAP_TABLE_END},
+	{"device registers", 0xFE00, 0xFFFF},
+}
+
+func (s segment) end() int {
+	return int(s.origin) + s.length - 1
+}
+
+// CheckOverlaps makes sure no two images share an address and none of them covers a system area
+func (v *VM) CheckOverlaps() error {
+	for i, a := range v.segments {
+		if a.length == 0 {
+			continue
+		}
+		for _, b := range v.segments[i+1:] {
+			if b.length > 0 && int(a.origin) <= b.end() && int(b.origin) <= a.end() {
+				return fmt.Errorf("%s (x%04X-x%04X) overlaps %s (x%04X-x%04X)", b.path, b.origin, b.end(), a.path, a.origin, a.end())
+			}
+		}
+		for _, area := range systemAreas {
+			if int(a.origin) <= area.end && area.start <= a.end() {
+				return fmt.Errorf("%s (x%04X-x%04X) overlaps the %s (x%04X-x%04X)", a.path, a.origin, a.end(), area.name, area.start, area.end)
+			}
+		}
+	}
+	return nil
+}
+
+// PrintSegments lists what got loaded where, and the entry point
+func (v *VM) PrintSegments(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "file\torigin\tlength\tend")
+	for _, seg := range v.segments {
+		end := "-"
+		if seg.length > 0 {
+			end = fmt.Sprintf("x%04X", seg.end())
+		}
+		fmt.Fprintf(tw, "%s\tx%04X\t%d\t%s\n", seg.path, seg.origin, seg.length, end)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "entry point: x%04X\n", v.startPC)
+}
@@ -0,0 +1,101 @@
+package vm
+
+import "fmt"
+
+const (
+	MR_MBS = 0xFE68 // mailbox status, with this machine's number in the low byte
+	MR_MBD = 0xFE6A // mailbox data, reading takes the waiting word, writing sends one to MBA's machine
+	MR_MBA = 0xFE6C // the machine number words are sent to
+	MR_MBF = 0xFE6E // the machine number the word last read came from
+
+	MBS_READY   = 1 << 15 // a word is waiting
+	MBS_IE      = 1 << 14 // set by the program to be interrupted when a word comes in
+	MBS_DROPPED = 1 << 13 // the last word sent went nowhere: no such machine, or its mailbox was full
+
+	MAILBOX_VECTOR   = 0x82
+	MAILBOX_PRIORITY = 3
+	MAILBOX_SIZE     = 64 // words waiting in a mailbox, more are dropped
+)
+
+type letter struct {
+	from uint8
+	word uint16
+}
+
+type mailbox struct {
+	mailID      uint8
+	mailPeers   []*VM       // every connected machine, this one included, by number
+	mailIn      chan letter // can be sent to from other machines' goroutines
+	mailIE      bool
+	mailDropped bool
+	mailLatched bool // the interrupt for the word in front has been raised
+}
+
+// ConnectMailboxes gives the machines a mailbox each, numbered in the order they're given, so they
+// can send each other words. a program writes the number of the machine to MBA and the word to MBD,
+// and finds words for it in MBD when MBS shows ready, or through the interrupt at x82 if it set MBS's
+// interrupt enable bit. connect them before running them
+func ConnectMailboxes(machines ...*VM) error {
+	if len(machines) > 256 {
+		return fmt.Errorf("%d machines, a mailbox number is a byte", len(machines))
+	}
+	for i, v := range machines {
+		v.mailID = uint8(i)
+		v.mailPeers = machines
+		v.mailIn = make(chan letter, MAILBOX_SIZE)
+	}
+	return nil
+}
+
+func (v *VM) mailRead(address uint16) uint16 {
+	switch address {
+	case MR_MBS:
+		status := uint16(v.mailID)
+		if len(v.mailIn) > 0 {
+			status |= MBS_READY
+		}
+		if v.mailIE {
+			status |= MBS_IE
+		}
+		if v.mailDropped {
+			status |= MBS_DROPPED
+		}
+		return status
+	case MR_MBD:
+		select {
+		case l := <-v.mailIn:
+			v.memory[MR_MBF] = uint16(l.from)
+			v.mailLatched = false
+			return l.word
+		default:
+			return v.memory[MR_MBD]
+		}
+	}
+	return v.memory[address] // MBA and MBF
+}
+
+func (v *VM) mailWrite(address, value uint16) {
+	switch address {
+	case MR_MBS:
+		v.mailIE = value&MBS_IE != 0
+	case MR_MBD:
+		v.mailDropped = true
+		if to := int(v.memory[MR_MBA]); to < len(v.mailPeers) {
+			select {
+			case v.mailPeers[to].mailIn <- letter{v.mailID, value}:
+				v.mailDropped = false
+			default:
+			}
+		}
+	case MR_MBA:
+		v.memory[MR_MBA] = value
+	} // MBF is read only
+}
+
+// mailInterrupt raises the mailbox interrupt for a word that came in, once per word
+func (v *VM) mailInterrupt() {
+	if !v.mailLatched && len(v.mailIn) > 0 {
+		v.mailLatched = true
+		v.deviceInterrupt(MAILBOX_VECTOR)
+	}
+}
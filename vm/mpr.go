@@ -0,0 +1,30 @@
+package vm
+
+import "fmt"
+
+const (
+	MR_MPR  = 0xFE12 // memory protection register, bit n set keeps user mode out of x(n)000-x(n)FFF
+	MR_ACVA = 0xFE16 // the address the last access control violation was over
+
+	MPR_PAGE_SHIFT = 12 // 4K pages
+)
+
+// userDenied says whether the MPR keeps a program in user mode from address, the MPR and PSR are
+// always out of reach. a denied access is an access control violation: the instruction is undone
+// and the exception taken through the vector table at x0102
+func (v *VM) userDenied(address uint16) bool {
+	if address != MR_MPR && address != MR_PSR && v.memory[MR_MPR]>>(address>>MPR_PAGE_SHIFT)&1 == 0 {
+		return false
+	}
+	v.accessViolation(address, fmt.Errorf("%w, user mode access to 0x%04X (PC=0x%04X)", ErrACV, address, v.reg[R_PC]-1))
+	return true
+}
+
+// accessViolation raises the access control violation exception over address, which the routine
+// finds in ACVA
+func (v *VM) accessViolation(address uint16, err error) {
+	if !v.excRaised {
+		v.memory[MR_ACVA] = address
+	}
+	v.exception(EXC_ACV, err)
+}
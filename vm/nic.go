@@ -0,0 +1,137 @@
+package vm
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+)
+
+const (
+	MR_NICS = 0xFE70 // network status
+	MR_NICA = 0xFE72 // memory address of the frame buffer
+	MR_NICC = 0xFE74 // command, writing one runs it
+
+	NICS_READY = 1 << 15 // a frame has come in
+	NICS_IE    = 1 << 14 // set by the program to be interrupted when a frame comes in
+	NICS_ERROR = 1 << 13 // the last command failed: nothing to receive, a buffer past the end of memory or a host error
+
+	NICC_SEND    = 1 // the buffer to the peer
+	NICC_RECEIVE = 2 // the frame that came in first into the buffer
+
+	FRAME_WORDS  = 64 // every frame, a shorter datagram is padded with zeros and a longer one cut
+	NIC_QUEUE    = 16 // frames waiting to be received, more are dropped
+	NIC_VECTOR   = 0x83
+	NIC_PRIORITY = 3
+)
+
+type nic struct {
+	nicConn    *net.UDPConn
+	nicPeer    *net.UDPAddr
+	nicFrames  chan []byte
+	nicIE      bool
+	nicError   bool
+	nicLatched bool // the interrupt for the frame in front has been raised
+}
+
+// SetupNIC attaches the network adapter to UDP. spec is "listen,peer", e.g. ":9000,otherhost:9000":
+// frames are received on the local address and sent to the peer, one datagram each
+func (v *VM) SetupNIC(spec string) error {
+	if spec == "" {
+		return nil
+	}
+	listen, peer, ok := strings.Cut(spec, ",")
+	if !ok {
+		return errors.New("want listen,peer addresses, e.g. :9000,otherhost:9000")
+	}
+	local, err := net.ResolveUDPAddr("udp", listen)
+	if err != nil {
+		return err
+	}
+	if v.nicPeer, err = net.ResolveUDPAddr("udp", peer); err != nil {
+		return err
+	}
+	if v.nicConn, err = net.ListenUDP("udp", local); err != nil {
+		return err
+	}
+	frames := make(chan []byte, NIC_QUEUE)
+	v.nicFrames = frames
+	go func(conn *net.UDPConn) {
+		buf := make([]byte, 2*FRAME_WORDS)
+		for {
+			n, _, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return // closed
+			}
+			frame := make([]byte, 2*FRAME_WORDS)
+			copy(frame, buf[:n])
+			select {
+			case frames <- frame:
+			default:
+			}
+		}
+	}(v.nicConn)
+	return nil
+}
+
+func (v *VM) nicStatus() uint16 {
+	var status uint16
+	if len(v.nicFrames) > 0 {
+		status |= NICS_READY
+	}
+	if v.nicIE {
+		status |= NICS_IE
+	}
+	if v.nicError {
+		status |= NICS_ERROR
+	}
+	return status
+}
+
+// nicCommand runs a command to the end, like the disk the adapter never keeps a program waiting
+func (v *VM) nicCommand(command uint16) {
+	v.nicError = v.nicTransfer(command) != nil
+}
+
+func (v *VM) nicTransfer(command uint16) error {
+	buffer := int(v.memory[MR_NICA])
+	if buffer+FRAME_WORDS > DEVICE_START || buffer+FRAME_WORDS > v.memSize {
+		return errors.New("buffer past the end of memory")
+	}
+	switch command {
+	case NICC_SEND:
+		frame := make([]byte, 2*FRAME_WORDS)
+		for i := range FRAME_WORDS {
+			binary.BigEndian.PutUint16(frame[2*i:], v.memory[buffer+i])
+		}
+		_, err := v.nicConn.WriteToUDP(frame, v.nicPeer)
+		return err
+	case NICC_RECEIVE:
+		select {
+		case frame := <-v.nicFrames:
+			for i := range FRAME_WORDS {
+				v.memory[buffer+i] = binary.BigEndian.Uint16(frame[2*i:])
+			}
+			v.nicLatched = false
+			return nil
+		default:
+			return errors.New("nothing to receive")
+		}
+	}
+	return errors.New("unknown command")
+}
+
+// nicInterrupt raises the network interrupt for a frame that came in, once per frame
+func (v *VM) nicInterrupt() {
+	if !v.nicLatched && len(v.nicFrames) > 0 {
+		v.nicLatched = true
+		v.deviceInterrupt(NIC_VECTOR)
+	}
+}
+
+func (v *VM) closeNIC() {
+	if v.nicConn != nil {
+		v.nicConn.Close()
+		v.nicConn, v.nicFrames = nil, nil
+	}
+}
@@ -0,0 +1,62 @@
+package vm
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/fs"
+	"os"
+)
+
+type nvram struct {
+	nvramPath            string // host file backing the region, "" if there's none
+	nvramStart, nvramEnd uint16
+}
+
+// LoadNVRAM makes region (start-end) non-volatile, backed by the host file at path.
+// the region is filled from the file now, a missing file is a blank region
+func (v *VM) LoadNVRAM(path, region string) error {
+	if path == "" {
+		return nil
+	}
+	start, end, err := ParseRange(region)
+	if err != nil {
+		return err
+	}
+	if err := v.checkMapped(start, int(end)-int(start)+1); err != nil {
+		return err
+	}
+	v.nvramPath, v.nvramStart, v.nvramEnd = path, start, end
+	return v.readNVRAM()
+}
+
+// readNVRAM fills the region from the file
+func (v *VM) readNVRAM() error {
+	data, err := os.ReadFile(v.nvramPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for i := 0; i+1 < len(data) && int(v.nvramStart)+i/2 <= int(v.nvramEnd); i += 2 {
+		v.memory[int(v.nvramStart)+i/2] = binary.BigEndian.Uint16(data[i:])
+	}
+	return nil
+}
+
+// SaveNVRAM writes the region back, big endian like image files. it goes to a new file renamed over
+// the old one, so a save cut short leaves the last one whole
+func (v *VM) SaveNVRAM() error {
+	if v.nvramPath == "" {
+		return nil
+	}
+	data := make([]byte, 0, 2*(int(v.nvramEnd)-int(v.nvramStart)+1))
+	for a := int(v.nvramStart); a <= int(v.nvramEnd); a++ {
+		data = binary.BigEndian.AppendUint16(data, v.memory[a])
+	}
+	tmp := v.nvramPath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, v.nvramPath)
+}
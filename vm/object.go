@@ -0,0 +1,253 @@
+package vm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+)
+
+/*
+relocatable objects, as lc3 asm -r writes them, can go anywhere in memory and use labels from
+each other. every field is a big endian word:
+
+	x4C43 x3352        magic, "LC3R"
+	origin             where it was assembled for, and where it goes unless it's told otherwise
+	n, n words         the code, as assembled for origin
+	n, n offsets       words holding an address in the object itself, moved along with it
+	n, n symbols       name, offset: its labels, for the other objects to use
+	n, n references    name, offset, bits: uses of another object's label. bits is 16 for a word
+	                   the address is added to, and 6, 9 or 11 for an instruction's PC offset
+
+a name is its length and then its characters, one to a word. a plain .obj whose origin and first
+word happen to spell the magic would be taken for one
+*/
+const (
+	OBJECT_MAGIC_HI = 0x4C43
+	OBJECT_MAGIC_LO = 0x3352
+)
+
+// ErrNotObject is ReadObject's answer for a plain absolute image
+var ErrNotObject = errors.New("not a relocatable object")
+
+// Object is a relocatable object
+type Object struct {
+	Origin     uint16
+	Words      []uint16
+	Relocs     []uint16          // offsets of the words that hold an address in the object
+	Symbols    map[string]uint16 // label to offset
+	References []Reference
+}
+
+// Reference is a use of a label the object leaves to another one
+type Reference struct {
+	Name   string
+	Offset uint16 // of the word it's in
+	Bits   int    // 16 for the whole word, or the width of a PC offset
+}
+
+// ObjectLoad is an object to link and where it goes
+type ObjectLoad struct {
+	Path   string
+	Object *Object
+	At     int // its origin, -1 for its own or, if that's taken, the next place it fits
+}
+
+// WriteTo writes the object in the format ReadObject reads
+func (o *Object) WriteTo(w io.Writer) (int64, error) {
+	out := []uint16{OBJECT_MAGIC_HI, OBJECT_MAGIC_LO, o.Origin, uint16(len(o.Words))}
+	out = append(out, o.Words...)
+	out = append(out, uint16(len(o.Relocs)))
+	out = append(out, o.Relocs...)
+	names := slices.Sorted(maps.Keys(o.Symbols))
+	out = append(out, uint16(len(names)))
+	for _, name := range names {
+		out = appendName(out, name)
+		out = append(out, o.Symbols[name])
+	}
+	out = append(out, uint16(len(o.References)))
+	for _, ref := range o.References {
+		out = appendName(out, ref.Name)
+		out = append(out, ref.Offset, uint16(ref.Bits))
+	}
+
+	data := make([]byte, 2*len(out))
+	for i, word := range out {
+		binary.BigEndian.PutUint16(data[2*i:], word)
+	}
+	n, err := w.Write(data)
+	return int64(n), err
+}
+
+func appendName(out []uint16, name string) []uint16 {
+	out = append(out, uint16(len(name)))
+	for i := 0; i < len(name); i++ {
+		out = append(out, uint16(name[i]))
+	}
+	return out
+}
+
+// ReadObject reads a relocatable object, ErrNotObject when data is something else
+func ReadObject(data []byte) (*Object, error) {
+	words := make([]uint16, len(data)/2)
+	for i := range words {
+		words[i] = binary.BigEndian.Uint16(data[2*i:])
+	}
+	if len(words) < 2 || words[0] != OBJECT_MAGIC_HI || words[1] != OBJECT_MAGIC_LO {
+		return nil, ErrNotObject
+	}
+	r := objectReader{words: words[2:]}
+	o := &Object{Origin: r.next(), Symbols: map[string]uint16{}}
+	o.Words = r.take(int(r.next()))
+	o.Relocs = r.take(int(r.next()))
+	for n := int(r.next()); n > 0 && r.err == nil; n-- {
+		name := r.name()
+		o.Symbols[name] = r.next()
+	}
+	for n := int(r.next()); n > 0 && r.err == nil; n-- {
+		o.References = append(o.References, Reference{Name: r.name(), Offset: r.next(), Bits: int(r.next())})
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	for _, offset := range o.Relocs {
+		if int(offset) >= len(o.Words) {
+			return nil, fmt.Errorf("relocation at %d is past the end of the code", offset)
+		}
+	}
+	for _, ref := range o.References {
+		if int(ref.Offset) >= len(o.Words) || !slices.Contains([]int{6, 9, 11, 16}, ref.Bits) {
+			return nil, fmt.Errorf("bad reference to %s at %d", ref.Name, ref.Offset)
+		}
+	}
+	return o, nil
+}
+
+// ReadObjectFile is ReadObject for a file
+func ReadObjectFile(path string) (*Object, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	o, err := ReadObject(data)
+	if err != nil && err != ErrNotObject {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return o, err
+}
+
+// objectReader takes words off the front, remembering the first time it ran out
+type objectReader struct {
+	words []uint16
+	err   error
+}
+
+func (r *objectReader) take(n int) []uint16 {
+	if n > len(r.words) {
+		r.err, n = io.ErrUnexpectedEOF, len(r.words)
+	}
+	taken := r.words[:n]
+	r.words = r.words[n:]
+	return taken
+}
+
+func (r *objectReader) next() uint16 {
+	if word := r.take(1); len(word) == 1 {
+		return word[0]
+	}
+	return 0
+}
+
+func (r *objectReader) name() string {
+	chars := r.take(int(r.next()))
+	name := make([]byte, len(chars))
+	for i, c := range chars {
+		name[i] = byte(c)
+	}
+	return string(name)
+}
+
+// LoadObjects links relocatable objects into memory: each goes where it's told, or else at its
+// own origin or the next place after it that's clear of everything loaded so far and of the
+// system areas, its addresses are moved along with it, and references between them are filled in.
+// it gives every object's labels at the addresses they ended up at
+func (v *VM) LoadObjects(loads []ObjectLoad) (map[string]uint16, error) {
+	bases := make([]uint16, len(loads))
+	taken := slices.Clone(v.segments)
+	for i, load := range loads {
+		seg := segment{path: load.Path, origin: load.Object.Origin, length: len(load.Object.Words)}
+		if load.At >= 0 {
+			seg.origin = uint16(load.At)
+		} else if err := v.findRoom(&seg, taken); err != nil {
+			return nil, err
+		}
+		bases[i] = seg.origin
+		taken = append(taken, seg)
+	}
+
+	symbols := map[string]uint16{}
+	definedIn := map[string]string{}
+	for i, load := range loads {
+		for name, offset := range load.Object.Symbols {
+			if other, ok := definedIn[name]; ok {
+				return nil, fmt.Errorf("%s: %s is already defined in %s", load.Path, name, other)
+			}
+			symbols[name], definedIn[name] = bases[i]+offset, load.Path
+		}
+	}
+
+	for i, load := range loads {
+		o, base := load.Object, bases[i]
+		words := slices.Clone(o.Words)
+		for _, offset := range o.Relocs {
+			words[offset] += base - o.Origin
+		}
+		for _, ref := range o.References {
+			target, ok := symbols[ref.Name]
+			if !ok {
+				return nil, fmt.Errorf("%s: undefined label %s", load.Path, ref.Name)
+			}
+			if ref.Bits == 16 {
+				words[ref.Offset] += target
+				continue
+			}
+			offset := int(int16(target - (base + ref.Offset) - 1))
+			if offset < -(1<<(ref.Bits-1)) || offset >= 1<<(ref.Bits-1) {
+				return nil, fmt.Errorf("%s: %s is too far away at x%04X, %d words for a %d bit offset", load.Path, ref.Name, target, offset, ref.Bits)
+			}
+			mask := uint16(1)<<ref.Bits - 1
+			words[ref.Offset] = words[ref.Offset]&^mask | uint16(offset)&mask
+		}
+		if err := v.place(segment{path: load.Path, origin: base, data: words}, words); err != nil {
+			return nil, err
+		}
+	}
+	return symbols, nil
+}
+
+// findRoom moves seg up from its origin until it's clear of taken and the system areas
+func (v *VM) findRoom(seg *segment, taken []segment) error {
+	origin := int(seg.origin)
+	for moved := true; moved; {
+		moved = false
+		end := origin + seg.length - 1
+		for _, other := range taken {
+			if other.length > 0 && origin <= other.end() && int(other.origin) <= end {
+				origin, moved = other.end()+1, true
+			}
+		}
+		for _, area := range systemAreas {
+			if origin <= area.end && area.start <= end {
+				origin, moved = area.end+1, true
+			}
+		}
+		if origin+seg.length > min(v.memSize, DEVICE_START) {
+			return fmt.Errorf("%s: no room for its %d words from x%04X up", seg.path, seg.length, seg.origin)
+		}
+	}
+	seg.origin = uint16(origin)
+	return nil
+}
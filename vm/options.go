@@ -0,0 +1,62 @@
+package vm
+
+import (
+	"fmt"
+	"io"
+)
+
+// Option adjusts a machine New is building
+type Option func(v *VM) error
+
+// WithMemorySize gives the machine words of RAM from x0000 up, at most MEMORY_MAX (the default).
+// the device registers at xFE00-xFFFF are there whatever the size
+func WithMemorySize(words int) Option {
+	return func(v *VM) error {
+		if words <= 0 || words > MEMORY_MAX {
+			return fmt.Errorf("memory size %d is not between 1 and %d words", words, MEMORY_MAX)
+		}
+		v.memSize = words
+		return nil
+	}
+}
+
+// WithStartPC sets where programs start running, PC_START by default
+func WithStartPC(pc uint16) Option {
+	return func(v *VM) error {
+		v.startPC = pc
+		return nil
+	}
+}
+
+// WithConsole connects the console to in and out instead of stdin and stdout, in may be nil
+// for a machine without a keyboard
+func WithConsole(in io.Reader, out io.Writer) Option {
+	return func(v *VM) error {
+		v.In, v.Out = in, out
+		return nil
+	}
+}
+
+// WithDevices attaches device plugins, see SetupDevices
+func WithDevices(specs ...string) Option {
+	return func(v *VM) error {
+		return v.SetupDevices(specs)
+	}
+}
+
+// WithTrace writes every executed instruction, and the registers it changed, to w
+func WithTrace(w io.Writer) Option {
+	return func(v *VM) error {
+		v.trace = w
+		return nil
+	}
+}
+
+// WithMaxInstructions bounds every Run to n instructions, after which it returns ErrMaxInstructions.
+// 0 means no bound
+func WithMaxInstructions(n uint64) Option {
+	return func(v *VM) error {
+		v.maxInstructions = n
+		return nil
+	}
+}
@@ -0,0 +1,117 @@
+package vm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	MR_PCMD = 0xFE58 // sample data, each write queues one signed 16 bit sample
+	MR_PCMF = 0xFE5A // fill level, samples queued and not played yet
+
+	PCM_BUFFER = 4096 // samples the queue holds, writes to a full queue are dropped
+	PCM_TICK   = 10 * time.Millisecond
+)
+
+type pcm struct {
+	pcmOn    bool
+	pcmMu    sync.Mutex
+	pcmQueue []uint16 // guarded by pcmMu
+	pcmOut   io.WriteCloser
+	pcmCmd   *exec.Cmd
+	pcmStop  chan struct{} // closed to stop the player
+	pcmDone  chan struct{} // closed by the player once it's stopped
+}
+
+// SetupPCM turns the sample device on, playing rate samples a second. the samples are written to
+// the stdin of command, big endian, e.g. "aplay -q -t raw -f S16_BE -c 1 -r 8000" or
+// "play -q -t raw -r 8000 -e signed -b 16 -B -c 1 -". without a command they're played to nobody,
+// at the same pace, so a program feeding the device still runs the way it would with sound
+func (v *VM) SetupPCM(rate int, command string) error {
+	if rate <= 0 {
+		return nil
+	}
+	if args := strings.Fields(command); len(args) > 0 {
+		v.pcmCmd = exec.Command(args[0], args[1:]...)
+		v.pcmCmd.Stderr = os.Stderr
+		var err error
+		if v.pcmOut, err = v.pcmCmd.StdinPipe(); err != nil {
+			return err
+		}
+		if err := v.pcmCmd.Start(); err != nil {
+			return fmt.Errorf("%s: %v", args[0], err)
+		}
+	}
+	v.pcmOn = true
+	v.pcmStop, v.pcmDone = make(chan struct{}), make(chan struct{})
+	go v.playPCM(rate)
+	return nil
+}
+
+// playPCM takes samples off the queue in real time
+func (v *VM) playPCM(rate int) {
+	ticker := time.NewTicker(PCM_TICK)
+	defer ticker.Stop()
+	defer close(v.pcmDone)
+	start := time.Now()
+	played := 0
+	for {
+		select {
+		case <-v.pcmStop:
+			return
+		case now := <-ticker.C:
+			due := int(now.Sub(start).Seconds()*float64(rate)) - played
+			played += due
+
+			v.pcmMu.Lock()
+			n := min(due, len(v.pcmQueue))
+			samples := v.pcmQueue[:n]
+			v.pcmQueue = v.pcmQueue[n:]
+			v.pcmMu.Unlock()
+
+			if v.pcmOut != nil && n > 0 {
+				if err := binary.Write(v.pcmOut, binary.BigEndian, samples); err != nil {
+					log.Printf("pcm: %v", err)
+					v.pcmOut = nil
+				}
+			}
+		}
+	}
+}
+
+func (v *VM) pcmWrite(sample uint16) {
+	v.pcmMu.Lock()
+	defer v.pcmMu.Unlock()
+	if len(v.pcmQueue) < PCM_BUFFER {
+		v.pcmQueue = append(v.pcmQueue, sample)
+	}
+}
+
+func (v *VM) pcmFill() uint16 {
+	v.pcmMu.Lock()
+	defer v.pcmMu.Unlock()
+	return uint16(len(v.pcmQueue))
+}
+
+func (v *VM) closePCM() {
+	if !v.pcmOn {
+		return
+	}
+	close(v.pcmStop)
+	<-v.pcmDone
+	v.pcmOn = false
+	if v.pcmCmd != nil {
+		if v.pcmOut != nil {
+			v.pcmOut.Close()
+		}
+		v.pcmCmd.Wait()
+		v.pcmCmd, v.pcmOut = nil, nil
+	}
+}
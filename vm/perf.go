@@ -0,0 +1,30 @@
+package vm
+
+const (
+	MR_PERF = 0xFE80 // performance counters, each the low 32 bits as a high word then a low word:
+
+	PERF_INSTR_HI  = MR_PERF + 0 // instructions executed
+	PERF_INSTR_LO  = MR_PERF + 2
+	PERF_CYCLES_HI = MR_PERF + 4 // cycles, counted the way Stats does
+	PERF_CYCLES_LO = MR_PERF + 6
+	PERF_MEM_HI    = MR_PERF + 8 // memory accesses, instruction fetches included
+	PERF_MEM_LO    = MR_PERF + 10
+	PERF_END       = PERF_MEM_LO
+)
+
+type perfCounters struct {
+	perfLatched [3]uint32
+}
+
+// perfRead gives a counter register. reading any high word latches all three counters,
+// so reading a high word and then the low words gives one consistent set
+func (v *VM) perfRead(address uint16) uint16 {
+	if (address-MR_PERF)%4 == 0 {
+		v.perfLatched = [3]uint32{uint32(v.instrCount), uint32(v.instrCount + v.memAccesses), uint32(v.memAccesses)}
+	}
+	n := v.perfLatched[(address-MR_PERF)/4]
+	if (address-MR_PERF)%4 == 0 {
+		return uint16(n >> 16)
+	}
+	return uint16(n)
+}
@@ -0,0 +1,59 @@
+package vm
+
+import (
+	"bufio"
+	"os"
+)
+
+const (
+	MR_LPS = 0xFEB0 // printer status
+	MR_LPD = 0xFEB2 // printer data, writing one prints its low byte
+
+	LPS_READY = 1 << 15 // the printer can take a character, one written while it's busy is lost
+)
+
+type printer struct {
+	lpOut       *bufio.Writer
+	lpFile      *os.File
+	lpDelay     uint64 // instructions each character keeps the printer busy
+	lpBusyUntil uint64
+}
+
+// SetupPrinter attaches the line printer at xFEB0 to the host file at path, characters are appended
+// to it. after each one the printer stays busy for delay instructions
+func (v *VM) SetupPrinter(path string, delay int) error {
+	if path == "" {
+		return nil
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	v.lpFile, v.lpOut = file, bufio.NewWriter(file)
+	v.lpDelay = uint64(max(delay, 0))
+	return nil
+}
+
+func (v *VM) printerStatus() uint16 {
+	if v.instrCount >= v.lpBusyUntil {
+		return LPS_READY
+	}
+	return 0
+}
+
+func (v *VM) printerWrite(value uint16) {
+	v.memory[MR_LPD] = value
+	if v.instrCount < v.lpBusyUntil {
+		return
+	}
+	v.lpOut.WriteByte(byte(value))
+	v.lpBusyUntil = v.instrCount + v.lpDelay
+}
+
+func (v *VM) closePrinter() {
+	if v.lpFile != nil {
+		v.lpOut.Flush()
+		v.lpFile.Close()
+		v.lpFile, v.lpOut = nil, nil
+	}
+}
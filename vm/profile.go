@@ -0,0 +1,63 @@
+package vm
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+
+	"lc3/decode"
+)
+
+// call stack profiler. frames are named after the address the subroutine starts at
+type profileState struct {
+	profiling     bool
+	profileStack  []string          // folded key for each depth, the last one is the current stack
+	profileCounts map[string]uint64 // instructions executed per stack
+}
+
+// SetupProfile starts a JSR/RET based profile, rooted at the current PC
+func (v *VM) SetupProfile(on bool) {
+	if !on {
+		return
+	}
+	v.profiling = true
+	v.profileStack = []string{fmt.Sprintf("x%04X", v.reg[R_PC])}
+	v.profileCounts = map[string]uint64{}
+}
+
+// profileStep charges instr to the current stack, then follows calls and returns
+func (v *VM) profileStep(instr uint16) {
+	current := v.profileStack[len(v.profileStack)-1]
+	v.profileCounts[current]++
+
+	switch in := decode.Decode(instr); in.Op {
+	case OP_JSR:
+		v.profileStack = append(v.profileStack, fmt.Sprintf("%s;x%04X", current, v.reg[R_PC]))
+	case OP_JMP:
+		if in.SR1 == R_R7 && len(v.profileStack) > 1 { // RET
+			v.profileStack = v.profileStack[:len(v.profileStack)-1]
+		}
+	}
+}
+
+// WriteProfile saves the profile in folded stack format, for flamegraph.pl and friends
+func (v *VM) WriteProfile(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	stacks := make([]string, 0, len(v.profileCounts))
+	for stack := range v.profileCounts {
+		stacks = append(stacks, stack)
+	}
+	sort.Strings(stacks)
+
+	w := bufio.NewWriter(file)
+	for _, stack := range stacks {
+		fmt.Fprintf(w, "%s %d\n", stack, v.profileCounts[stack])
+	}
+	return w.Flush()
+}
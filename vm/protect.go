@@ -0,0 +1,106 @@
+package vm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	TRAP_TABLE_START = 0x0000 // trap vector table
+	TRAP_TABLE_END   = 0x00FF
+)
+
+type protection struct {
+	protected     []bool // marks every address a store isn't allowed to touch
+	protectIgnore bool   // stores there are dropped rather than faulting
+}
+
+func (v *VM) protect(start, end uint16) {
+	for a := int(start); a <= int(end); a++ {
+		v.protected[a] = true
+	}
+}
+
+// Protect makes start-end (inclusive) read-only, like ROM
+func (v *VM) Protect(start, end uint16) {
+	v.protect(start, end)
+}
+
+// SetupProtectionAction says what a store to read-only memory does: fault (the default), raising
+// the access control violation exception, which stops the program when there's no routine for it
+// at x0102, or ignore, leaving memory as it was the way real ROM does
+func (v *VM) SetupProtectionAction(action string) error {
+	switch action {
+	case "fault":
+		v.protectIgnore = false
+	case "ignore":
+		v.protectIgnore = true
+	default:
+		return fmt.Errorf("want fault or ignore, got %q", action)
+	}
+	return nil
+}
+
+// ParseAddr accepts LC-3 style hex (x3000), go style hex (0x3000) and plain decimal
+func ParseAddr(s string) (uint16, error) {
+	s = strings.TrimSpace(s)
+	base := 10
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		s, base = s[2:], 16
+	} else if strings.HasPrefix(s, "x") || strings.HasPrefix(s, "X") {
+		s, base = s[1:], 16
+	}
+	n, err := strconv.ParseUint(s, base, 16)
+	if err != nil {
+		return 0, fmt.Errorf("bad address %q", s)
+	}
+	return uint16(n), nil
+}
+
+// ParseRange reads 'start-end' (inclusive) or a single address
+func ParseRange(s string) (uint16, uint16, error) {
+	lo, hi, found := strings.Cut(s, "-")
+	start, err := ParseAddr(lo)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !found {
+		return start, start, nil
+	}
+	end, err := ParseAddr(hi)
+	if err != nil {
+		return 0, 0, err
+	}
+	if end < start {
+		return 0, 0, fmt.Errorf("bad range %q: end is before start", s)
+	}
+	return start, end, nil
+}
+
+// SetupProtection makes the comma separated regions read-only: 'code' (every loaded image),
+// 'traps' or ranges like x3000-x30FF. it must run after the images are loaded
+func (v *VM) SetupProtection(spec string) error {
+	if spec == "" {
+		return nil
+	}
+	for _, part := range strings.Split(spec, ",") {
+		switch part = strings.TrimSpace(part); part {
+		case "code":
+			for _, seg := range v.segments {
+				if seg.length > 0 {
+					v.protect(seg.origin, uint16(seg.end()))
+				}
+			}
+		case "traps":
+			v.protect(TRAP_TABLE_START, TRAP_TABLE_END)
+		default:
+			start, end, err := ParseRange(part)
+			if err != nil {
+				return err
+			}
+			v.protect(start, end)
+		}
+	}
+	return nil
+}
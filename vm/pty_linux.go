@@ -0,0 +1,62 @@
+//go:build linux
+
+package vm
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// openPTY makes a new pseudo terminal in raw mode, so bytes go through untouched,
+// and gives its master side and the path of the device for the other end
+func openPTY() (io.ReadWriteCloser, string, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		return nil, "", err
+	}
+	fd := int(master.Fd())
+	if err := unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err != nil {
+		master.Close()
+		return nil, "", err
+	}
+	n, err := unix.IoctlGetInt(fd, unix.TIOCGPTN)
+	if err != nil {
+		master.Close()
+		return nil, "", err
+	}
+	path := fmt.Sprintf("/dev/pts/%d", n)
+
+	// holding the other end open too keeps reads from failing while nothing else has it open
+	slave, err := os.OpenFile(path, os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, "", err
+	}
+	termios, err := unix.IoctlGetTermios(int(slave.Fd()), unix.TCGETS)
+	if err == nil {
+		termios.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+		termios.Oflag &^= unix.OPOST
+		termios.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+		termios.Cflag = termios.Cflag&^(unix.CSIZE|unix.PARENB) | unix.CS8
+		err = unix.IoctlSetTermios(int(slave.Fd()), unix.TCSETS, termios)
+	}
+	if err != nil {
+		master.Close()
+		slave.Close()
+		return nil, "", err
+	}
+	return ptyMaster{master, slave}, path, nil
+}
+
+type ptyMaster struct {
+	*os.File
+	slave *os.File
+}
+
+func (p ptyMaster) Close() error {
+	p.slave.Close()
+	return p.File.Close()
+}
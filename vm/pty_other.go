@@ -0,0 +1,12 @@
+//go:build !linux
+
+package vm
+
+import (
+	"errors"
+	"io"
+)
+
+func openPTY() (io.ReadWriteCloser, string, error) {
+	return nil, "", errors.New("making a pty is only supported on linux, give the path of one instead")
+}
@@ -0,0 +1,10 @@
+package vm
+
+import "math/rand"
+
+const MR_RNG = 0xFE26 // random numbers, every read gives a new 16 bit one and a write reseeds with the value
+
+// SetupSeed seeds the random number device, so the numbers a program draws are the same every run
+func (v *VM) SetupSeed(seed int64) {
+	v.rng = rand.New(rand.NewSource(seed))
+}
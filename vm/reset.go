@@ -0,0 +1,79 @@
+package vm
+
+import "time"
+
+// Reset puts the machine back to how it was before its first run, for running a program again
+// without building a new machine: the registers are cleared with the Z flag set and the PC at
+// the start, bank 0 put in the window, the device registers and keyboard state cleared, the
+// timer stopped, pending interrupts dropped, the watchdog petted, the heap all free again and
+// the run counters and history zeroed. with reload memory and the banks are cleared too and the
+// images, boot ROM and NVRAM are read from disk again, so a rebuilt program gets picked up.
+// don't call it while the machine is running
+func (v *VM) Reset(reload bool) error {
+	if reload {
+		segments := v.segments
+		v.segments = nil
+		clear(v.memory)
+		clear(v.bankStore)
+		v.bankCurrent = 0
+		for _, seg := range segments {
+			var err error
+			if seg.rom {
+				err = v.LoadROM(seg.path, seg.origin)
+			} else if seg.data != nil {
+				err = v.place(seg, seg.data)
+			} else {
+				err = v.LoadFile(seg.path)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if v.nvramPath != "" {
+			if err := v.readNVRAM(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if v.bankCount != 0 {
+		v.switchBank(0)
+	}
+	clear(v.memory[DEVICE_START:])
+	v.kbsrControl = 0
+	v.kbdLatched = false
+	v.padPressed, v.padHeld, v.padQueue = [8]time.Time{}, 0, nil
+	v.boardDrawn = ""
+	v.argN, v.argPos = 0, 0
+	v.gpioNext = 0
+	v.lpBusyUntil = 0
+	v.sensorIE, v.sensorAbove = false, false
+	v.scancodeQueue = nil
+	v.haltRequested = false
+	v.petWatchdog()
+	v.timer = timer{}
+	v.clock = clock{}
+	v.diskError = false
+	v.mailIE, v.mailDropped, v.mailLatched = false, false, false
+	v.nicIE, v.nicError, v.nicLatched = false, false, false
+	v.pcmMu.Lock()
+	v.pcmQueue = nil
+	v.pcmMu.Unlock()
+	if len(v.heap) > 0 {
+		v.heap = []heapBlock{{start: v.heap[0].start, size: v.heap[len(v.heap)-1].end() - int(v.heap[0].start) + 1, free: true}}
+	}
+	clear(v.executed)
+	clear(v.smcWarned)
+
+	v.instrCount, v.memAccesses = 0, 0
+	v.trapCounts = map[uint16]uint64{}
+	v.haltReason, v.exitCode = "", 0
+	v.SetupHistory(len(v.history))
+	v.priority, v.user = 0, v.startUser
+	v.intMu.Lock()
+	v.intPending = nil
+	v.intHighest.Store(-1)
+	v.intMu.Unlock()
+	v.resetCPU()
+	return nil
+}
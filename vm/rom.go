@@ -0,0 +1,36 @@
+package vm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+)
+
+// LoadROM maps a boot ROM image (raw big endian words, no origin header) read-only at origin.
+// its first word is the reset vector, the machine starts there from the next reset
+func (v *VM) LoadROM(path string, origin uint16) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	length := len(data) / 2
+	if length == 0 {
+		return fmt.Errorf("%s is empty", path)
+	}
+	if int(origin)+length > MEMORY_MAX {
+		return fmt.Errorf("%s doesn't fit at x%04X", path, origin)
+	}
+	if err := v.checkMapped(origin, length); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	for i := 0; i < length; i++ {
+		v.memory[int(origin)+i] = binary.BigEndian.Uint16(data[2*i:])
+	}
+
+	seg := segment{path: path, origin: origin, length: length, rom: true}
+	v.segments = append(v.segments, seg)
+	v.protect(origin, uint16(seg.end()))
+	v.startPC = v.memory[origin]
+	return nil
+}
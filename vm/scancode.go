@@ -0,0 +1,126 @@
+package vm
+
+import "github.com/eiannone/keyboard"
+
+const (
+	KBSR_READY    = 1 << 15
+	KBSR_IE       = 1 << 14 // set by the program to be interrupted when a key is pressed instead of polling
+	KBSR_SCANCODE = 1 << 13 // set by the program to get scancodes in KBDR instead of characters
+	KBSR_CONTROL  = KBSR_IE | KBSR_SCANCODE
+
+	SCAN_BREAK    = 0x80   // or'ed into the code when the key goes up
+	SCAN_EXTENDED = 1 << 8 // the key has an E0 prefix on a real keyboard (arrows, home, ...)
+)
+
+// PC scancode set 1 make codes
+const (
+	SCAN_ESC       = 0x01
+	SCAN_BACKSPACE = 0x0E
+	SCAN_TAB       = 0x0F
+	SCAN_ENTER     = 0x1C
+	SCAN_CTRL      = 0x1D
+	SCAN_SHIFT     = 0x2A
+	SCAN_ALT       = 0x38
+	SCAN_SPACE     = 0x39
+)
+
+type scancodeState struct {
+	kbsrControl   uint16   // the control bits of KBSR the program has written
+	scancodeQueue []uint16 // scancodes waiting to be read from KBDR
+	kbdLatched    bool     // a key taken for the keyboard interrupt is in KBDR and hasn't been read
+}
+
+// where a character lives on the keyboard
+type scanRune struct {
+	code  uint16
+	shift bool
+}
+
+var scanRunes = map[rune]scanRune{}
+
+func init() {
+	// each row of keys in scancode order, unshifted then shifted
+	rows := []struct {
+		first          uint16
+		plain, shifted string
+	}{
+		{0x02, "1234567890-=", "!@#$%^&*()_+"},
+		{0x10, "qwertyuiop[]", "QWERTYUIOP{}"},
+		{0x1E, "asdfghjkl;'`", "ASDFGHJKL:\"~"},
+		{0x2B, "\\zxcvbnm,./", "|ZXCVBNM<>?"},
+	}
+	for _, row := range rows {
+		for i, r := range row.plain {
+			scanRunes[r] = scanRune{row.first + uint16(i), false}
+		}
+		for i, r := range row.shifted {
+			scanRunes[r] = scanRune{row.first + uint16(i), true}
+		}
+	}
+}
+
+var scanKeys = map[keyboard.Key]uint16{
+	keyboard.KeyEsc: SCAN_ESC, keyboard.KeyBackspace: SCAN_BACKSPACE, keyboard.KeyBackspace2: SCAN_BACKSPACE,
+	keyboard.KeyTab: SCAN_TAB, keyboard.KeyEnter: SCAN_ENTER, keyboard.KeySpace: SCAN_SPACE,
+	keyboard.KeyF1: 0x3B, keyboard.KeyF2: 0x3C, keyboard.KeyF3: 0x3D, keyboard.KeyF4: 0x3E, keyboard.KeyF5: 0x3F,
+	keyboard.KeyF6: 0x40, keyboard.KeyF7: 0x41, keyboard.KeyF8: 0x42, keyboard.KeyF9: 0x43, keyboard.KeyF10: 0x44,
+	keyboard.KeyF11: 0x57, keyboard.KeyF12: 0x58,
+	keyboard.KeyHome: SCAN_EXTENDED | 0x47, keyboard.KeyArrowUp: SCAN_EXTENDED | 0x48, keyboard.KeyPgup: SCAN_EXTENDED | 0x49,
+	keyboard.KeyArrowLeft: SCAN_EXTENDED | 0x4B, keyboard.KeyArrowRight: SCAN_EXTENDED | 0x4D, keyboard.KeyEnd: SCAN_EXTENDED | 0x4F,
+	keyboard.KeyArrowDown: SCAN_EXTENDED | 0x50, keyboard.KeyPgdn: SCAN_EXTENDED | 0x51, keyboard.KeyInsert: SCAN_EXTENDED | 0x52,
+	keyboard.KeyDelete: SCAN_EXTENDED | 0x53,
+}
+
+// press queues a key going down then up, wrapped in a modifier if it needs one
+func (v *VM) press(code uint16, modifier uint16) {
+	if modifier != 0 {
+		v.scancodeQueue = append(v.scancodeQueue, modifier)
+	}
+	v.scancodeQueue = append(v.scancodeQueue, code, code|SCAN_BREAK)
+	if modifier != 0 {
+		v.scancodeQueue = append(v.scancodeQueue, modifier|SCAN_BREAK)
+	}
+}
+
+// queueScancodes turns a key press into scancodes. a terminal only tells us about presses,
+// so every key is reported as going down and straight back up
+func (v *VM) queueScancodes(ev keyboard.KeyEvent) {
+	var modifier uint16
+	if ev.Key == keyboard.KeyEsc && ev.Rune != 0 { // alt+key arrives as escape followed by the key
+		modifier, ev.Key = SCAN_ALT, 0
+	}
+
+	if ev.Rune != 0 {
+		if k, ok := scanRunes[ev.Rune]; ok {
+			if k.shift && modifier == 0 {
+				modifier = SCAN_SHIFT
+			}
+			v.press(k.code, modifier)
+		}
+		return
+	}
+	if code, ok := scanKeys[ev.Key]; ok {
+		v.press(code, modifier)
+		return
+	}
+	if ev.Key >= keyboard.KeyCtrlA && ev.Key <= keyboard.KeyCtrlZ { // what's left of the control range is ctrl+letter
+		v.press(scanRunes[rune('a'+ev.Key-keyboard.KeyCtrlA)].code, SCAN_CTRL)
+	}
+}
+
+// pollScancode fills KBSR/KBDR from the scancode queue, topping it up from the keyboard
+func (v *VM) pollScancode() {
+	if len(v.scancodeQueue) == 0 {
+		if ev, ok := v.pollKey(); ok {
+			v.queueScancodes(ev)
+		}
+	}
+	if len(v.scancodeQueue) == 0 {
+		v.memory[MR_KBSR] = v.kbsrControl
+		return
+	}
+	v.memory[MR_KBSR] = KBSR_READY | v.kbsrControl
+	v.memory[MR_KBDR] = v.scancodeQueue[0]
+	v.recordScancode(v.scancodeQueue[0])
+	v.scancodeQueue = v.scancodeQueue[1:]
+}
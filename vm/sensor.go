@@ -0,0 +1,132 @@
+package vm
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	MR_SENS = 0xFEB8 // sensor status
+	MR_SENV = 0xFEBA // the reading, signed
+	MR_SENT = 0xFEBC // threshold, signed
+
+	SENS_ABOVE = 1 << 15 // the reading is at or above the threshold
+	SENS_IE    = 1 << 14 // set by the program to be interrupted when the reading crosses the threshold
+
+	SENSOR_VECTOR   = 0x84
+	SENSOR_PRIORITY = 3
+)
+
+type sensor struct {
+	sensorWave  func(ms uint64) int16 // the reading ms milliseconds into the run
+	sensorIE    bool
+	sensorAbove bool // which side of the threshold the reading was last seen on
+}
+
+// SetupSensor turns on the sensor at xFEB8, its reading following spec: a waveform
+// "sine:min,max,ms", "square:...", "triangle:..." or "saw:..." going between min and max every ms
+// milliseconds, e.g. "sine:150,300,10000" for a temperature in tenths of a degree, or the path of a
+// trace file of ms,value lines like the GPIO script's. a program can set a threshold and ask for
+// the interrupt at x84 when the reading goes over or under it
+func (v *VM) SetupSensor(spec string) error {
+	if spec == "" {
+		return nil
+	}
+	shape, params, ok := strings.Cut(spec, ":")
+	if !ok {
+		trace, err := readTrace(spec)
+		if err != nil {
+			return err
+		}
+		v.sensorWave = func(ms uint64) int16 {
+			i := sort.Search(len(trace), func(i int) bool { return trace[i].ms > ms })
+			if i == 0 {
+				return 0
+			}
+			return int16(trace[i-1].value)
+		}
+		return nil
+	}
+
+	fields := strings.Split(params, ",")
+	if len(fields) != 3 {
+		return fmt.Errorf("want %s:min,max,ms", shape)
+	}
+	var n [3]int64
+	for i, f := range fields {
+		var err error
+		if n[i], err = strconv.ParseInt(strings.TrimSpace(f), 10, 16); err != nil || i == 2 && n[i] <= 0 {
+			return fmt.Errorf("bad number %q in %s", f, spec)
+		}
+	}
+	low, high, period := float64(n[0]), float64(n[1]), uint64(n[2])
+	var wave func(phase float64) float64 // 0 to 1 over a period
+	switch shape {
+	case "sine":
+		wave = func(p float64) float64 { return (1 + math.Sin(2*math.Pi*p)) / 2 }
+	case "square":
+		wave = func(p float64) float64 {
+			if p < 0.5 {
+				return 1
+			}
+			return 0
+		}
+	case "triangle":
+		wave = func(p float64) float64 { return 1 - math.Abs(2*p-1) }
+	case "saw":
+		wave = func(p float64) float64 { return p }
+	default:
+		return fmt.Errorf("unknown waveform %q, want sine, square, triangle or saw", shape)
+	}
+	v.sensorWave = func(ms uint64) int16 {
+		phase := float64(ms%period) / float64(period)
+		return int16(math.Round(low + (high-low)*wave(phase)))
+	}
+	return nil
+}
+
+func (v *VM) sensorAboveNow() bool {
+	return v.sensorWave(v.runMillis()) >= int16(v.memory[MR_SENT])
+}
+
+func (v *VM) sensorRead(address uint16) uint16 {
+	switch address {
+	case MR_SENS:
+		var status uint16
+		if v.sensorAboveNow() {
+			status |= SENS_ABOVE
+		}
+		if v.sensorIE {
+			status |= SENS_IE
+		}
+		return status
+	case MR_SENV:
+		return uint16(v.sensorWave(v.runMillis()))
+	}
+	return v.memory[address]
+}
+
+func (v *VM) sensorWrite(address, value uint16) {
+	switch address {
+	case MR_SENS:
+		v.sensorIE = value&SENS_IE != 0
+	case MR_SENT:
+		v.memory[MR_SENT] = value
+	}
+	v.sensorAbove = v.sensorAboveNow() // crossings count from here
+}
+
+// sensorInterrupt raises the sensor interrupt when the reading has crossed the threshold. the
+// reading is only looked at every 1024 instructions
+func (v *VM) sensorInterrupt() {
+	if v.instrCount&0x3FF != 0 {
+		return
+	}
+	if above := v.sensorAboveNow(); above != v.sensorAbove {
+		v.sensorAbove = above
+		v.deviceInterrupt(SENSOR_VECTOR)
+	}
+}
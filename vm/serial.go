@@ -0,0 +1,232 @@
+package vm
+
+import (
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	MR_SERIAL  = 0xFE40 // serial port bridged to TCP, each port has four registers from its base:
+	MR_SERIAL2 = 0xFE48 // serial port on a host pty or pipes
+
+	SERIAL_RSR   = 0 // receive status, bit 15 set when a byte has come in
+	SERIAL_RDR   = 2 // receive data, reading it takes the byte
+	SERIAL_TSR   = 4 // transmit status, bit 15 set when the port takes a byte
+	SERIAL_TDR   = 6 // transmit data, the low byte of a write is sent
+	SERIAL_WORDS = 8
+
+	SERIAL_READY  = 1 << 15
+	SERIAL_BUFFER = 4096 // bytes received ahead of the program reading them, more wait on the host side
+)
+
+type serial struct {
+	serialPorts []*serialPort
+}
+
+// serialPort is a character device like the console, connected to something outside the machine.
+// bytes sent while nothing is connected are dropped
+type serialPort struct {
+	base     uint16
+	name     string
+	rx       chan byte
+	held     int // the byte RDR gives, -1 when there's none
+	mu       sync.Mutex
+	conn     io.ReadWriteCloser // guarded by mu
+	listener net.Listener
+}
+
+func newSerialPort(base uint16, name string) *serialPort {
+	return &serialPort{base: base, name: name, rx: make(chan byte, SERIAL_BUFFER), held: -1}
+}
+
+// SetupSerial adds a serial port bridged to TCP: it listens on addr (e.g. ":7000") and the
+// latest connection is what the program talks to
+func (v *VM) SetupSerial(addr string) error {
+	if addr == "" {
+		return nil
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	p := v.addSerialPort(MR_SERIAL, "tcp "+listener.Addr().String())
+	p.listener = listener
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return // closed
+			}
+			p.connect(conn)
+		}
+	}()
+	return nil
+}
+
+// SetupSerialFile adds the second serial port, on host files rather than the network. spec is
+//
+//	pty          a new pseudo terminal, for minicom, screen or expect to open (not on windows)
+//	path         an existing terminal or character device, read and written, e.g. another machine's pty
+//	in,out       a pair of named pipes: the program reads what's written to in, and writes to out
+//
+// it returns what the port ended up on, the new device's path for a pty. named pipes are opened in the
+// background since that waits for the other end, anything sent before then is dropped
+func (v *VM) SetupSerialFile(spec string) (string, error) {
+	if spec == "" {
+		return "", nil
+	}
+	p := v.addSerialPort(MR_SERIAL2, spec)
+	if spec == "pty" {
+		conn, path, err := openPTY()
+		if err != nil {
+			return "", err
+		}
+		p.name = path
+		p.connect(conn)
+		return path, nil
+	}
+	if in, out, ok := strings.Cut(spec, ","); ok {
+		go func() {
+			r, err := os.OpenFile(in, os.O_RDONLY, 0)
+			if err != nil {
+				log.Printf("serial %s: %v", spec, err)
+				return
+			}
+			w, err := os.OpenFile(out, os.O_WRONLY, 0)
+			if err != nil {
+				r.Close()
+				log.Printf("serial %s: %v", spec, err)
+				return
+			}
+			p.connect(pipePair{r, w})
+		}()
+		return spec, nil
+	}
+	file, err := os.OpenFile(spec, os.O_RDWR, 0)
+	if err != nil {
+		return "", err
+	}
+	p.connect(file)
+	return spec, nil
+}
+
+// pipePair is one connection made of two one-way pipes
+type pipePair struct {
+	r, w *os.File
+}
+
+func (p pipePair) Read(b []byte) (int, error)  { return p.r.Read(b) }
+func (p pipePair) Write(b []byte) (int, error) { return p.w.Write(b) }
+func (p pipePair) Close() error {
+	p.r.Close()
+	return p.w.Close()
+}
+
+func (v *VM) addSerialPort(base uint16, name string) *serialPort {
+	p := newSerialPort(base, name)
+	v.serialPorts = append(v.serialPorts, p)
+	return p
+}
+
+// connect makes conn the other end of the port, hanging up on the one before
+func (p *serialPort) connect(conn io.ReadWriteCloser) {
+	p.mu.Lock()
+	if p.conn != nil {
+		p.conn.Close()
+	}
+	p.conn = conn
+	p.mu.Unlock()
+
+	go func() {
+		buf := make([]byte, 256)
+		for {
+			n, err := conn.Read(buf)
+			for _, b := range buf[:n] {
+				p.rx <- b
+			}
+			if err != nil {
+				p.hangUp(conn)
+				return
+			}
+		}
+	}()
+}
+
+func (p *serialPort) hangUp(conn io.ReadWriteCloser) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	conn.Close()
+	if p.conn == conn {
+		p.conn = nil
+	}
+}
+
+// serialAt finds the port a device register belongs to
+func (v *VM) serialAt(address uint16) *serialPort {
+	for _, p := range v.serialPorts {
+		if address >= p.base && address < p.base+SERIAL_WORDS {
+			return p
+		}
+	}
+	return nil
+}
+
+func (p *serialPort) read(address uint16) uint16 {
+	switch address - p.base {
+	case SERIAL_RSR:
+		if p.held < 0 {
+			select {
+			case b := <-p.rx:
+				p.held = int(b)
+			default:
+			}
+		}
+		if p.held >= 0 {
+			return SERIAL_READY
+		}
+	case SERIAL_RDR:
+		if p.held >= 0 {
+			b := p.held
+			p.held = -1
+			return uint16(b)
+		}
+	case SERIAL_TSR:
+		return SERIAL_READY
+	}
+	return 0
+}
+
+func (p *serialPort) write(address, value uint16) {
+	if address-p.base != SERIAL_TDR {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn == nil {
+		return
+	}
+	if _, err := p.conn.Write([]byte{byte(value)}); err != nil {
+		log.Printf("serial %s: %v", p.name, err)
+		p.conn.Close()
+		p.conn = nil
+	}
+}
+
+func (v *VM) closeSerialPorts() {
+	for _, p := range v.serialPorts {
+		if p.listener != nil {
+			p.listener.Close()
+		}
+		p.mu.Lock()
+		if p.conn != nil {
+			p.conn.Close()
+			p.conn = nil
+		}
+		p.mu.Unlock()
+	}
+	v.serialPorts = nil
+}
@@ -0,0 +1,44 @@
+package vm
+
+import (
+	"fmt"
+	"log"
+)
+
+type smcState struct {
+	executed  []bool // addresses run as instructions so far, only tracked when the check is on
+	smcWarned []bool
+	smcLogAll bool
+	smcStop   bool
+}
+
+// SetupSMC says what to do when a program writes over code it already ran:
+// off, warn (once per address), log (every time) or stop
+func (v *VM) SetupSMC(mode string) error {
+	switch mode {
+	case "off":
+		return nil
+	case "warn":
+	case "log":
+		v.smcLogAll = true
+	case "stop":
+		v.smcStop = true
+	default:
+		return fmt.Errorf("want off, warn, log or stop, got %q", mode)
+	}
+	v.executed = make([]bool, MEMORY_MAX)
+	v.smcWarned = make([]bool, MEMORY_MAX)
+	return nil
+}
+
+// checkSMC runs before a store lands on an address that has been executed
+func (v *VM) checkSMC(address, value uint16) {
+	pc := v.reg[R_PC] - 1
+	if v.smcStop {
+		v.fault("self-modifying code: write of 0x%04X over the instruction at 0x%04X (PC=0x%04X)", value, address, pc)
+	}
+	if v.smcLogAll || !v.smcWarned[address] {
+		v.smcWarned[address] = true
+		log.Printf("self-modifying code: write of 0x%04X over the instruction at 0x%04X (PC=0x%04X)", value, address, pc)
+	}
+}
@@ -0,0 +1,267 @@
+package vm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"time"
+)
+
+const (
+	SNAPSHOT_MAGIC   = "LC3S"
+	SNAPSHOT_VERSION = 4
+)
+
+/*
+a snapshot is the whole machine state, big endian throughout:
+
+	"LC3S" version
+	registers                       R_COUNT words, PC and COND included
+	memory size (words, 4 bytes)    start PC
+	memory                          all MEMORY_MAX words, device registers included
+	KBSR control bits               scancode count, scancodes
+	watchdog left (4 bytes)         instructions executed (8 bytes)
+	heap block count                per block: start, size (4 bytes), free (1 byte)
+	PSR                             saved SSP, saved USP          (version 2)
+	bank window                     bank count, current bank (4 bytes each)
+	backing store                   bank count * 4K words         (version 3)
+	devices                         see snapshotDevices
+	pending interrupts              per interrupt: vector, priority (a byte each)  (version 4)
+
+a new field means a new version, Restore keeps reading the old ones
+*/
+
+// snapshotHeader is the fixed size part at the front
+type snapshotHeader struct {
+	Magic   [4]byte
+	Version uint16
+	Reg     [R_COUNT]uint16
+	MemSize uint32
+	StartPC uint16
+}
+
+// snapshotDevices is the state of the built-in devices kept outside their registers
+type snapshotDevices struct {
+	TimerControl     uint16
+	TimerLoad        uint16
+	TimerLeft        int32 // instructions, or milliseconds when the timer counts those
+	TimerRunning     bool
+	TimerDone        bool
+	ClockLatched     int64 // unix milliseconds the RTC registers read, -1 when nothing's latched
+	KbdLatched       bool
+	SensorIE         bool
+	SensorAbove      bool
+	PrinterBusyUntil uint64
+	DiskError        bool
+	Pending          uint16
+}
+
+// Snapshot captures the registers, memory and device state of the machine: the keyboard, timer,
+// clock, watchdog, heap, banks, sensor, printer and disk status, and the interrupts still pending.
+// what lives on the host side is left out: the disk image is its file, and the connections of
+// serial ports, the NIC, the mailbox and -device processes along with the bytes in flight on
+// them can't be captured, so Restore won't put a snapshot into a machine that has any of those.
+// take it while the machine isn't running, between runs or from a trap handler
+func (v *VM) Snapshot() ([]byte, error) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	var buf bytes.Buffer
+	header := snapshotHeader{
+		Version: SNAPSHOT_VERSION,
+		Reg:     v.reg,
+		MemSize: uint32(v.memSize),
+		StartPC: v.startPC,
+	}
+	copy(header.Magic[:], SNAPSHOT_MAGIC)
+
+	fields := []any{
+		header,
+		v.memory,
+		v.kbsrControl,
+		uint16(len(v.scancodeQueue)),
+		v.scancodeQueue,
+		int32(v.watchdogLeft),
+		v.instrCount,
+		uint16(len(v.heap)),
+	}
+	for _, b := range v.heap {
+		fields = append(fields, b.start, uint32(b.size), b.free)
+	}
+	fields = append(fields, v.psr(), v.savedSSP, v.savedUSP)
+	fields = append(fields, v.bankWindow, uint32(v.bankCount), uint32(v.bankCurrent), v.bankStore)
+
+	devices := snapshotDevices{
+		TimerControl:     v.timerControl,
+		TimerLoad:        v.timerLoad,
+		TimerLeft:        int32(v.timerLeft),
+		TimerRunning:     v.timerRunning,
+		TimerDone:        v.timerDone,
+		ClockLatched:     -1,
+		KbdLatched:       v.kbdLatched,
+		SensorIE:         v.sensorIE,
+		SensorAbove:      v.sensorAbove,
+		PrinterBusyUntil: v.lpBusyUntil,
+		DiskError:        v.diskError,
+	}
+	if v.timerRunning && v.timerControl&TMR_MS != 0 {
+		devices.TimerLeft = int32(time.Until(v.timerDeadline).Milliseconds())
+	}
+	if !v.clockLatched.IsZero() {
+		devices.ClockLatched = v.clockLatched.UnixMilli()
+	}
+	v.intMu.Lock()
+	pending := slices.Clone(v.intPending)
+	v.intMu.Unlock()
+	devices.Pending = uint16(len(pending))
+	fields = append(fields, devices)
+	for _, in := range pending {
+		fields = append(fields, in.vector, uint8(in.priority))
+	}
+	for _, field := range fields {
+		if err := binary.Write(&buf, binary.BigEndian, field); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// Restore puts the machine back in the state a snapshot captured, it carries on from there on the next Run
+func (v *VM) Restore(data []byte) error {
+	r := bytes.NewReader(data)
+	var header snapshotHeader
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		return fmt.Errorf("not a snapshot: %v", err)
+	}
+	if string(header.Magic[:]) != SNAPSHOT_MAGIC {
+		return fmt.Errorf("not a snapshot")
+	}
+	if header.Version < 1 || header.Version > SNAPSHOT_VERSION {
+		return fmt.Errorf("snapshot version %d, only 1 to %d are understood", header.Version, SNAPSHOT_VERSION)
+	}
+	if header.MemSize == 0 || header.MemSize > uint32(MEMORY_MAX) {
+		return fmt.Errorf("snapshot has a bad memory size %d", header.MemSize)
+	}
+	if attached := v.hostAttached(); attached != "" {
+		return fmt.Errorf("a snapshot can't be restored into a machine with %s attached", attached)
+	}
+
+	memory := make([]uint16, MEMORY_MAX)
+	var kbsrControl, queued uint16
+	var watchdogLeft int32
+	var instrCount uint64
+	var blocks uint16
+	for _, field := range []any{memory, &kbsrControl, &queued} {
+		if err := binary.Read(r, binary.BigEndian, field); err != nil {
+			return fmt.Errorf("snapshot cut short: %v", err)
+		}
+	}
+	scancodes := make([]uint16, queued)
+	for _, field := range []any{scancodes, &watchdogLeft, &instrCount, &blocks} {
+		if err := binary.Read(r, binary.BigEndian, field); err != nil {
+			return fmt.Errorf("snapshot cut short: %v", err)
+		}
+	}
+	var heap []heapBlock
+	for i := 0; i < int(blocks); i++ {
+		var b struct {
+			Start uint16
+			Size  uint32
+			Free  bool
+		}
+		if err := binary.Read(r, binary.BigEndian, &b); err != nil {
+			return fmt.Errorf("snapshot cut short: %v", err)
+		}
+		heap = append(heap, heapBlock{start: b.Start, size: int(b.Size), free: b.Free})
+	}
+	psr, savedSSP, savedUSP := uint16(0), v.sspStart, uint16(0) // version 1 ran in supervisor mode
+	if header.Version >= 2 {
+		for _, field := range []any{&psr, &savedSSP, &savedUSP} {
+			if err := binary.Read(r, binary.BigEndian, field); err != nil {
+				return fmt.Errorf("snapshot cut short: %v", err)
+			}
+		}
+	}
+	bankWindow, bankCount, bankCurrent, bankStore := v.bankWindow, uint32(v.bankCount), uint32(v.bankCurrent), v.bankStore
+	if header.Version >= 3 {
+		for _, field := range []any{&bankWindow, &bankCount, &bankCurrent} {
+			if err := binary.Read(r, binary.BigEndian, field); err != nil {
+				return fmt.Errorf("snapshot cut short: %v", err)
+			}
+		}
+		if bankCount > 0x10000 || bankCount != 0 && bankCurrent >= bankCount {
+			return fmt.Errorf("snapshot has bank %d of %d", bankCurrent, bankCount)
+		}
+		bankStore = make([]uint16, bankCount*BANK_WORDS)
+		if err := binary.Read(r, binary.BigEndian, bankStore); err != nil {
+			return fmt.Errorf("snapshot cut short: %v", err)
+		}
+	}
+	devices := snapshotDevices{TimerControl: v.timerControl, ClockLatched: -1}
+	var pending []pendingInterrupt
+	if header.Version >= 4 {
+		if err := binary.Read(r, binary.BigEndian, &devices); err != nil {
+			return fmt.Errorf("snapshot cut short: %v", err)
+		}
+		for i := 0; i < int(devices.Pending); i++ {
+			var in [2]uint8
+			if err := binary.Read(r, binary.BigEndian, &in); err != nil {
+				return fmt.Errorf("snapshot cut short: %v", err)
+			}
+			if in[1] > 7 {
+				return fmt.Errorf("snapshot has an interrupt at priority %d", in[1])
+			}
+			pending = append(pending, pendingInterrupt{in[0], int(in[1])})
+		}
+	}
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.reg = header.Reg
+	v.memSize = int(header.MemSize)
+	v.startPC = header.StartPC
+	v.memory = memory
+	v.kbsrControl = kbsrControl
+	v.scancodeQueue = scancodes
+	v.watchdogLeft = int(watchdogLeft)
+	v.instrCount = instrCount
+	v.heap = heap
+	v.user, v.priority = psr&PSR_USER != 0, int(psr>>8&0x7)
+	v.savedSSP, v.savedUSP = savedSSP, savedUSP
+	v.bankWindow, v.bankCount, v.bankCurrent, v.bankStore = bankWindow, int(bankCount), int(bankCurrent), bankStore
+
+	v.timerControl, v.timerLoad, v.timerLeft = devices.TimerControl, devices.TimerLoad, int(devices.TimerLeft)
+	v.timerRunning, v.timerDone = devices.TimerRunning, devices.TimerDone
+	if v.timerControl&TMR_MS != 0 {
+		v.timerDeadline = time.Now().Add(time.Duration(devices.TimerLeft) * time.Millisecond)
+	}
+	v.clockLatched = time.Time{}
+	if devices.ClockLatched >= 0 {
+		v.clockLatched = time.UnixMilli(devices.ClockLatched)
+	}
+	v.kbdLatched = devices.KbdLatched
+	v.sensorIE, v.sensorAbove = devices.SensorIE, devices.SensorAbove
+	v.lpBusyUntil = devices.PrinterBusyUntil
+	v.diskError = devices.DiskError
+	v.intMu.Lock()
+	v.intPending = pending
+	v.intHighest.Store(highestPending(pending))
+	v.intMu.Unlock()
+	return nil
+}
+
+// hostAttached names a device the machine has whose state is on the host side, "" if there's none
+func (v *VM) hostAttached() string {
+	switch {
+	case len(v.serialPorts) > 0:
+		return "a serial port"
+	case v.nicConn != nil:
+		return "the NIC"
+	case v.mailIn != nil:
+		return "the mailbox"
+	case len(v.deviceAt) > 0:
+		return "a -device process"
+	}
+	return ""
+}
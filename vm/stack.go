@@ -0,0 +1,36 @@
+package vm
+
+type stackCheck struct {
+	stackChecked bool
+	stackLimit   int // lowest word a push may use
+	stackBase    int // R6 of an empty stack
+}
+
+// SetupStack sets the stack region start-end; R6 leaving it is a fault (an empty stack has R6 = end+1).
+// it's the stack of the mode the program starts in: a program started in user mode has its interrupts
+// and exceptions run on the supervisor stack, which isn't checked, nor is the swap of R6 into it and back
+// out with RTI. a program running in supervisor mode has them on its own stack, checked like the rest
+func (v *VM) SetupStack(spec string) error {
+	if spec == "" {
+		return nil
+	}
+	start, end, err := ParseRange(spec)
+	if err != nil {
+		return err
+	}
+	v.stackChecked = true
+	v.stackLimit = int(start)
+	v.stackBase = int(end) + 1
+	return nil
+}
+
+// checkStack runs after an instruction at pc changed R6, in the mode the program started in
+func (v *VM) checkStack(pc uint16) {
+	sp := int(v.reg[R_R6])
+	if sp < v.stackLimit {
+		v.fault("stack overflow: R6=0x%04X is below the stack limit 0x%04X (PC=0x%04X)", sp, v.stackLimit, pc)
+	}
+	if sp > v.stackBase {
+		v.fault("stack underflow: R6=0x%04X is past the stack base 0x%04X (PC=0x%04X)", sp, v.stackBase, pc)
+	}
+}
@@ -0,0 +1,55 @@
+package vm
+
+import (
+	"fmt"
+	"time"
+)
+
+// run counters, always kept since they're just increments
+type counters struct {
+	instrCount  uint64
+	memAccesses uint64 // reads and writes, instruction fetches included
+	trapCounts  map[uint16]uint64
+	startTime   time.Time
+	haltReason  string
+	exitCode    int // low byte of R0 at the HALT
+}
+
+// Stats sums up a run
+type Stats struct {
+	Instructions uint64            `json:"instructions"`
+	Cycles       uint64            `json:"cycles"`
+	Traps        map[string]uint64 `json:"traps"`
+	WallSeconds  float64           `json:"wall_seconds"`
+	MIPS         float64           `json:"mips"`
+	HaltReason   string            `json:"halt_reason"`
+	ExitCode     int               `json:"exit_code"`
+}
+
+// Stats counts what the machine has done since it started running.
+// there's no real timing model, a cycle is one per instruction plus one per memory access
+func (v *VM) Stats() Stats {
+	elapsed := time.Since(v.startTime).Seconds()
+	stats := Stats{
+		Instructions: v.instrCount,
+		Cycles:       v.instrCount + v.memAccesses,
+		Traps:        map[string]uint64{},
+		WallSeconds:  elapsed,
+		HaltReason:   v.haltReason,
+		ExitCode:     v.exitCode,
+	}
+	if elapsed > 0 {
+		stats.MIPS = float64(v.instrCount) / elapsed / 1e6
+	}
+	for vector, n := range v.trapCounts {
+		stats.Traps[fmt.Sprintf("x%02X", vector)] = n
+	}
+	return stats
+}
+
+// ExitCode is what the program handed back when it halted: the low byte of R0 at the HALT,
+// so a program can end with e.g. AND R0, R0, #0 / ADD R0, R0, #1 / HALT to report failure.
+// it's 0 for a run that ended any other way
+func (v *VM) ExitCode() int {
+	return v.exitCode
+}
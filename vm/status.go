@@ -0,0 +1,53 @@
+package vm
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+const STATUS_INTERVAL = 200 * time.Millisecond
+
+type statusState struct {
+	statusOn    bool
+	statusShown bool
+	statusLast  time.Time
+	statusCount uint64 // instrCount at statusLast
+}
+
+// SetupStatus keeps a live status line (instructions, speed, PC and what's left of an instruction limit) on stderr while running
+func (v *VM) SetupStatus(on bool) {
+	v.statusOn = on
+}
+
+// statusStep redraws the status line a few times a second.
+// the clock is only looked at every 4096 instructions to keep the loop fast
+func (v *VM) statusStep(pc uint16) {
+	if v.instrCount&0xFFF != 0 {
+		return
+	}
+	now := time.Now()
+	if v.statusLast.IsZero() {
+		v.statusLast = v.startTime
+	}
+	elapsed := now.Sub(v.statusLast)
+	if elapsed < STATUS_INTERVAL {
+		return
+	}
+	rate := float64(v.instrCount-v.statusCount) / elapsed.Seconds()
+	fmt.Fprintf(os.Stderr, "\r\x1b[K%d instructions  %.2f MIPS  PC=x%04X", v.instrCount, rate/1e6, pc)
+	if v.instrLimit != 0 {
+		fmt.Fprintf(os.Stderr, "  %d left", v.instrLimit-v.instrCount)
+	}
+	v.statusShown = true
+	v.statusLast = now
+	v.statusCount = v.instrCount
+}
+
+// clearStatus wipes the line so it doesn't mix with whatever comes next
+func (v *VM) clearStatus() {
+	if v.statusShown {
+		fmt.Fprint(os.Stderr, "\r\x1b[K")
+		v.statusShown = false
+	}
+}
@@ -0,0 +1,43 @@
+package vm
+
+import (
+	"context"
+	"time"
+
+	"lc3/decode"
+)
+
+// StepInfo describes one executed instruction
+type StepInfo struct {
+	PC       uint16   // where the instruction was
+	NextPC   uint16   // where the PC went after it
+	Instr    uint16   // the instruction word
+	Opcode   uint16   // OP_*
+	Op       string   // mnemonic, e.g. "ADD" or "BRnz"
+	Operands []string // in assembly syntax, e.g. "R1", "#-3", "x25"
+	Reads    []uint16 // addresses loaded from, the fetch not included
+	Writes   []uint16 // addresses stored to
+	Halted   bool     // the instruction stopped the machine
+}
+
+// Step executes exactly one instruction and says what it did. a pending interrupt is taken
+// first, the instruction is then the first of its service routine. a fault is returned as
+// an error like Run does, with the PC left on the faulting instruction's successor
+func (v *VM) Step() (info StepInfo, err error) {
+	if v.startTime.IsZero() {
+		v.startTime = time.Now()
+	}
+	info.PC = v.reg[R_PC]
+	v.stepping = &info
+	defer func() {
+		v.stepping = nil
+		info.NextPC = v.reg[R_PC]
+		in := decode.Decode(info.Instr)
+		info.Opcode = uint16(in.Op)
+		info.Op, info.Operands = in.Mnemonic(), in.Operands()
+	}()
+	defer v.recoverFault(context.Background(), &err)
+
+	info.Halted = !v.execute()
+	return info, nil
+}
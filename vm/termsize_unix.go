@@ -0,0 +1,17 @@
+//go:build !windows
+
+package vm
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+func hostTermSize() (int, int, bool) {
+	ws, err := unix.IoctlGetWinsize(int(os.Stdout.Fd()), unix.TIOCGWINSZ)
+	if err != nil || ws.Col == 0 {
+		return 0, 0, false
+	}
+	return int(ws.Col), int(ws.Row), true
+}
@@ -0,0 +1,18 @@
+//go:build windows
+
+package vm
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+func hostTermSize() (int, int, bool) {
+	var info windows.ConsoleScreenBufferInfo
+	if err := windows.GetConsoleScreenBufferInfo(windows.Handle(os.Stdout.Fd()), &info); err != nil {
+		return 0, 0, false
+	}
+	w := info.Window
+	return int(w.Right-w.Left) + 1, int(w.Bottom-w.Top) + 1, true
+}
@@ -0,0 +1,84 @@
+package vm
+
+import "time"
+
+const (
+	MR_TMR = 0xFE08 // timer status and control
+	MR_TMC = 0xFE0A // timer count, a write loads it and starts the countdown, reads give what's left
+
+	TMR_DONE   = 1 << 15 // set when the count reaches zero, cleared by loading a new count
+	TMR_REPEAT = 1 << 1  // load the count again at zero, for periodic work
+	TMR_MS     = 1 << 0  // count milliseconds of host time instead of instructions
+
+	TMR_CONTROL = TMR_REPEAT | TMR_MS // the bits a program can write
+)
+
+type timer struct {
+	timerControl  uint16
+	timerLoad     uint16    // the count last written to TMC
+	timerLeft     int       // instructions to go, when counting instructions
+	timerDeadline time.Time // when it reaches zero, when counting milliseconds
+	timerRunning  bool
+	timerDone     bool
+}
+
+// timerWrite handles a store to one of the timer registers. the mode is picked up
+// at the next load of the count
+func (v *VM) timerWrite(address, value uint16) {
+	if address == MR_TMR {
+		v.timerControl = value & TMR_CONTROL
+		return
+	}
+	v.timerLoad = value
+	v.timerDone = false
+	v.timerRunning = value != 0
+	v.timerStart()
+}
+
+func (v *VM) timerStart() {
+	if v.timerControl&TMR_MS != 0 {
+		v.timerDeadline = time.Now().Add(time.Duration(v.timerLoad) * time.Millisecond)
+	} else {
+		v.timerLeft = int(v.timerLoad)
+	}
+}
+
+// timerRead gives the value of a timer register, catching up with the clock first
+func (v *VM) timerRead(address uint16) uint16 {
+	if v.timerRunning && v.timerControl&TMR_MS != 0 {
+		now := time.Now()
+		for v.timerRunning && !now.Before(v.timerDeadline) {
+			v.timerExpired()
+			v.timerDeadline = v.timerDeadline.Add(time.Duration(v.timerLoad) * time.Millisecond)
+		}
+		v.timerLeft = int((v.timerDeadline.Sub(now) + time.Millisecond - 1) / time.Millisecond)
+	}
+
+	if address == MR_TMR {
+		if v.timerDone {
+			return TMR_DONE | v.timerControl
+		}
+		return v.timerControl
+	}
+	if !v.timerRunning {
+		return 0
+	}
+	return uint16(v.timerLeft)
+}
+
+// timerStep counts down one instruction
+func (v *VM) timerStep() {
+	if v.timerControl&TMR_MS != 0 {
+		return
+	}
+	v.timerLeft--
+	if v.timerLeft <= 0 {
+		v.timerExpired()
+		v.timerLeft = int(v.timerLoad)
+	}
+}
+
+func (v *VM) timerExpired() {
+	v.timerDone = true
+	v.timerRunning = v.timerControl&TMR_REPEAT != 0
+}
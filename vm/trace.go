@@ -0,0 +1,19 @@
+package vm
+
+import (
+	"fmt"
+	"strings"
+
+	"lc3/decode"
+)
+
+// traceStep writes the instruction that just ran, disassembled, and the registers it changed
+func (v *VM) traceStep(pc, instr uint16, before [R_COUNT]uint16) {
+	line := fmt.Sprintf("x%04X  x%04X  %-18s", pc, instr, decode.Decode(instr))
+	for r := 0; r < R_COUNT; r++ {
+		if r != R_PC && v.reg[r] != before[r] {
+			line += fmt.Sprintf("  %s=x%04X", regNames[r], v.reg[r])
+		}
+	}
+	fmt.Fprintln(v.trace, strings.TrimRight(line, " "))
+}
@@ -0,0 +1,83 @@
+package vm
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+)
+
+/*
+one console event. exactly one of In, Out or Scancode is set:
+
+	{"instr":1523,"sec":0.0132,"out":"Enter a number: "}
+	{"instr":1530,"sec":2.4410,"in":"7"}
+*/
+type transcriptEvent struct {
+	Instr    uint64  `json:"instr"`
+	Sec      float64 `json:"sec"`
+	In       string  `json:"in,omitempty"`
+	Out      string  `json:"out,omitempty"`
+	Scancode uint16  `json:"scancode,omitempty"`
+}
+
+type transcriptState struct {
+	transcript     *json.Encoder
+	transcriptFile *os.File
+}
+
+// SetupTranscript records every console input and output event, with timestamps,
+// to the file at path (JSON lines). it wraps Out, so set that first
+func (v *VM) SetupTranscript(path string) error {
+	if path == "" {
+		return nil
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	v.transcriptFile = file
+	v.transcript = json.NewEncoder(file)
+	v.Out = transcriptWriter{v, v.Out}
+	return nil
+}
+
+func (v *VM) recordEvent(ev transcriptEvent) {
+	ev.Instr = v.instrCount
+	if !v.deterministic {
+		ev.Sec = time.Since(v.startTime).Seconds()
+	}
+	v.transcript.Encode(ev)
+}
+
+func (v *VM) recordInput(char uint16) {
+	if v.transcript != nil {
+		v.recordEvent(transcriptEvent{In: string(rune(char))})
+	}
+}
+
+func (v *VM) recordScancode(code uint16) {
+	if v.transcript != nil {
+		v.recordEvent(transcriptEvent{Scancode: code})
+	}
+}
+
+func (v *VM) closeTranscript() {
+	if v.transcriptFile != nil {
+		v.transcriptFile.Close()
+		v.transcript, v.transcriptFile = nil, nil
+	}
+}
+
+// transcriptWriter records console output on its way out
+type transcriptWriter struct {
+	v   *VM
+	out io.Writer
+}
+
+func (t transcriptWriter) Write(p []byte) (int, error) {
+	if t.v.transcript != nil {
+		t.v.recordEvent(transcriptEvent{Out: string(p)})
+	}
+	return t.out.Write(p)
+}
@@ -0,0 +1,137 @@
+package vm
+
+import "fmt"
+
+/* extension trap routines, not part of the standard LC-3 */
+const (
+	TRAP_MALLOC = 0x26 /* allocate R0 words on the heap, address returned in R0 (xFFFF, HEAP_FAIL, if nothing fits) */
+	TRAP_FREE   = 0x27 /* release the heap block at R0 */
+	TRAP_PUTN   = 0x28 /* output R0 as a signed decimal number */
+	TRAP_GETN   = 0x29 /* read a signed decimal number from the keyboard into R0, echoed */
+	TRAP_GETS   = 0x2A /* read a line of at most R1 characters into the buffer at R0, null terminated, echoed */
+	TRAP_POLL   = 0x2B /* character waiting on the keyboard into R0 without blocking, 0 (and the Z flag) if there is none */
+	TRAP_CLEAR  = 0x2C /* clear the screen and home the cursor */
+	TRAP_CURSOR = 0x2D /* move the cursor to row R0, column R1 (both from 0) */
+	TRAP_COLOR  = 0x2E /* set foreground R0, background R1 (0-15, anything above is the default) and attributes R2 for later terminal output, not -video or -display */
+	TRAP_GETENV = 0x2F /* copy host env variable named by the string at R0 into the buffer at R1 (at most R2 chars), length in R0, -1 if unset */
+	TRAP_TERMSZ = 0x30 /* console width into R0 and height into R1, the -video grid's when it's on */
+)
+
+// TrapHandler implements a trap vector in Go. it sees the registers and memory as the
+// TRAP instruction left them (R7 already holds the return address, in the classic trap mode);
+// an error stops the machine
+type TrapHandler func(v *VM) error
+
+// RegisterTrap routes TRAP vector to handler, taking precedence over any built-in routine
+func (v *VM) RegisterTrap(vector uint16, handler TrapHandler) {
+	v.trapHandlers[vector&0xFF] = handler
+}
+
+// SetupTrapMode picks how TRAP works. "classic" saves the return address in R7 and runs the routine
+// in Go. "spec" does what the LC-3 does since its third edition: TRAP pushes the PSR and PC on the
+// supervisor stack like an interrupt, switches to supervisor mode and jumps to the routine the trap
+// vector table at x0000 has for the vector, which returns with RTI. a vector left at 0 still runs
+// the Go routine, in supervisor mode with the PSR and PC pushed and popped around it. "table" is
+// classic with the trap vector table in front: TRAP saves the return address in R7 and jumps to the
+// routine the table has for the vector, which returns with RET, and only a vector left at 0 runs in Go
+func (v *VM) SetupTrapMode(mode string) error {
+	switch mode {
+	case "classic":
+		v.trapSpec, v.trapTable = false, false
+	case "spec":
+		v.trapSpec, v.trapTable = true, false
+	case "table":
+		v.trapSpec, v.trapTable = false, true
+	default:
+		return fmt.Errorf("want classic, spec or table, got %q", mode)
+	}
+	return nil
+}
+
+// installed on every new machine
+var extensionTraps = map[uint16]TrapHandler{
+	TRAP_MALLOC: (*VM).trapMalloc,
+	TRAP_FREE:   (*VM).trapFree,
+	TRAP_PUTN:   (*VM).trapPutn,
+	TRAP_GETN:   (*VM).trapGetn,
+	TRAP_GETS:   (*VM).trapGets,
+	TRAP_POLL:   (*VM).trapPoll,
+	TRAP_CLEAR:  (*VM).trapClear,
+	TRAP_CURSOR: (*VM).trapCursor,
+	TRAP_COLOR:  (*VM).trapColor,
+	TRAP_GETENV: (*VM).trapGetenv,
+	TRAP_TERMSZ: (*VM).trapTermsz,
+}
+
+func (v *VM) trapMalloc() error {
+	v.reg[R_R0] = v.heapAlloc(int(v.reg[R_R0]))
+	v.updateFlags(R_R0)
+	return nil
+}
+
+func (v *VM) trapFree() error {
+	return v.heapFree(v.reg[R_R0])
+}
+
+func (v *VM) trapPutn() error {
+	fmt.Fprintf(v.Out, "%d", int16(v.reg[R_R0]))
+	return nil
+}
+
+func (v *VM) trapGetn() error {
+	n, err := v.readNumber()
+	if err != nil {
+		return fmt.Errorf("tried reading entered number, failed: %w", err)
+	}
+	v.reg[R_R0] = n
+	v.updateFlags(R_R0)
+	return nil
+}
+
+func (v *VM) trapGets() error {
+	line, err := v.readLine(int(v.reg[R_R1]))
+	if err != nil {
+		return fmt.Errorf("tried reading entered line, failed: %w", err)
+	}
+	address := v.reg[R_R0]
+	for i, char := range line {
+		v.memWrite(address+uint16(i), char)
+	}
+	v.memWrite(address+uint16(len(line)), 0)
+	return nil
+}
+
+func (v *VM) trapPoll() error {
+	char, _ := v.pollChar()
+	v.reg[R_R0] = char
+	v.updateFlags(R_R0)
+	return nil
+}
+
+func (v *VM) trapClear() error {
+	v.clearScreen()
+	return nil
+}
+
+func (v *VM) trapCursor() error {
+	v.moveCursor(v.reg[R_R0], v.reg[R_R1])
+	return nil
+}
+
+func (v *VM) trapColor() error {
+	v.setColor(v.reg[R_R0], v.reg[R_R1], v.reg[R_R2])
+	return nil
+}
+
+func (v *VM) trapGetenv() error {
+	v.reg[R_R0] = v.getEnv(v.reg[R_R0], v.reg[R_R1], int(v.reg[R_R2]))
+	v.updateFlags(R_R0)
+	return nil
+}
+
+func (v *VM) trapTermsz() error {
+	w, h := v.termSize()
+	v.reg[R_R0], v.reg[R_R1] = uint16(w), uint16(h)
+	v.updateFlags(R_R0)
+	return nil
+}
@@ -0,0 +1,90 @@
+package vm
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+const (
+	TOUCH_READ  = 1 << 0
+	TOUCH_WRITE = 1 << 1
+	TOUCH_EXEC  = 1 << 2
+)
+
+type usageState struct {
+	touched []uint8 // what happened to each address, only kept when usage is on
+}
+
+// SetupUsage starts keeping track of which words get read, written and executed
+func (v *VM) SetupUsage(on bool) {
+	if on {
+		v.touched = make([]uint8, MEMORY_MAX)
+	}
+}
+
+// fetch reads the instruction at pc, which counts as executing it rather than reading it
+func (v *VM) fetch(pc uint16) uint16 {
+	if v.touched == nil {
+		return v.memRead(pc)
+	}
+	was := v.touched[pc]
+	instr := v.memRead(pc)
+	v.touched[pc] = was | TOUCH_EXEC
+	return instr
+}
+
+// regionOf names the part of memory an address belongs to
+func (v *VM) regionOf(address uint16) string {
+	a := int(address)
+	switch {
+	case v.stackChecked && a >= v.stackLimit && a < v.stackBase:
+		return "stack"
+	case len(v.heap) > 0 && a >= int(v.heap[0].start) && a <= v.heap[len(v.heap)-1].end():
+		return "heap"
+	case a >= 0xFE00:
+		return "devices"
+	}
+	for _, seg := range v.segments {
+		if seg.length > 0 && a >= int(seg.origin) && a <= seg.end() {
+			return "code"
+		}
+	}
+	if a < PC_START {
+		return "OS"
+	}
+	return "data"
+}
+
+// PrintUsage reports how many words were read, written and executed, by region
+func (v *VM) PrintUsage(w io.Writer) {
+	regions := []string{"code", "data", "stack", "heap", "OS", "devices"}
+	counts := map[string]*[3]int{}
+	for _, name := range regions {
+		counts[name] = &[3]int{}
+	}
+	for address, t := range v.touched {
+		if t == 0 {
+			continue
+		}
+		c := counts[v.regionOf(uint16(address))]
+		for i, bit := range []uint8{TOUCH_READ, TOUCH_WRITE, TOUCH_EXEC} {
+			if t&bit != 0 {
+				c[i]++
+			}
+		}
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "region\tread\twritten\texecuted")
+	var total [3]int
+	for _, name := range regions {
+		c := counts[name]
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\n", name, c[0], c[1], c[2])
+		for i := range total {
+			total[i] += c[i]
+		}
+	}
+	fmt.Fprintf(tw, "total\t%d\t%d\t%d\n", total[0], total[1], total[2])
+	tw.Flush()
+}
@@ -0,0 +1,132 @@
+package vm
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	VIDEO_COLS = 80
+	VIDEO_ROWS = 24
+)
+
+/*
+the video region is a grid of cells, row by row, one word each:
+
+	bits 7-0     character, 0 shows as a space
+	bits 11-8    foreground colour, the 16 ANSI colours
+	bits 15-12   background colour
+
+a cell with both colours 0 uses the terminal's own colours, so plain characters just work
+*/
+type video struct {
+	videoOn       bool
+	videoStart    uint16
+	videoCols     int
+	videoRows     int
+	videoInterval time.Duration
+	videoLast     time.Time
+	videoFrame    []uint16 // what's on the terminal, nil before the first frame
+}
+
+// SetupVideo shows a region of memory as a character grid on the terminal, redrawn up to fps times
+// a second when it changes. spec is the start address, optionally followed by the size, e.g.
+// "xC000" or "xC000,40x12" (80x24 when it's left out)
+func (v *VM) SetupVideo(spec string, fps int) error {
+	if spec == "" {
+		return nil
+	}
+	if fps <= 0 {
+		return fmt.Errorf("want a frame rate above 0, got %d", fps)
+	}
+	addr, size, _ := strings.Cut(spec, ",")
+	start, err := ParseAddr(addr)
+	if err != nil {
+		return err
+	}
+	cols, rows := VIDEO_COLS, VIDEO_ROWS
+	if size != "" {
+		if _, err := fmt.Sscanf(size, "%dx%d", &cols, &rows); err != nil || cols <= 0 || rows <= 0 {
+			return fmt.Errorf("bad size %q, want e.g. 80x24", size)
+		}
+	}
+	if int(start)+cols*rows > DEVICE_START {
+		return fmt.Errorf("a %dx%d grid from x%04X runs into the device page", cols, rows, start)
+	}
+	if err := v.checkMapped(start, cols*rows); err != nil {
+		return err
+	}
+	v.videoOn = true
+	v.videoStart, v.videoCols, v.videoRows = start, cols, rows
+	v.videoInterval = time.Second / time.Duration(fps)
+	return nil
+}
+
+// videoStep redraws the grid when it's due, the clock is only looked at every 1024 instructions
+func (v *VM) videoStep() {
+	if v.instrCount&0x3FF != 0 {
+		return
+	}
+	if now := time.Now(); now.Sub(v.videoLast) >= v.videoInterval {
+		v.videoLast = now
+		v.drawVideo()
+	}
+}
+
+// drawVideo puts the grid on the terminal if it changed since the last frame
+func (v *VM) drawVideo() {
+	grid := v.memory[v.videoStart : int(v.videoStart)+v.videoCols*v.videoRows]
+	if slices.Equal(grid, v.videoFrame) {
+		return
+	}
+	var out strings.Builder
+	if v.videoFrame == nil {
+		out.WriteString("\x1b[2J\x1b[?25l") // clear the screen and hide the cursor
+	}
+	for row := 0; row < v.videoRows; row++ {
+		fmt.Fprintf(&out, "\x1b[%d;1H", row+1)
+		attr := uint16(0)
+		for _, cell := range grid[row*v.videoCols : (row+1)*v.videoCols] {
+			if cell>>8 != attr {
+				attr = cell >> 8
+				out.WriteString(videoColours(attr))
+			}
+			char := rune(cell & 0xFF)
+			if char < ' ' || char == CHAR_DELETE {
+				char = ' '
+			}
+			out.WriteRune(char)
+		}
+		out.WriteString("\x1b[0m")
+	}
+	v.videoFrame = slices.Clone(grid)
+	fmt.Fprint(v.Out, out.String())
+}
+
+// videoColours is the escape sequence for a cell's attribute byte
+func videoColours(attr uint16) string {
+	if attr == 0 {
+		return "\x1b[0m"
+	}
+	fg, bg := attr&0xF, attr>>4&0xF
+	return fmt.Sprintf("\x1b[0;%d;%dm", ansiColour(fg, 30), ansiColour(bg, 40))
+}
+
+func ansiColour(c, base uint16) uint16 {
+	if c >= 8 {
+		return base + 60 + c - 8 // the bright ones
+	}
+	return base + c
+}
+
+// endVideo draws the last frame when a run ends and gives the cursor back, below the grid
+func (v *VM) endVideo() {
+	if !v.videoOn {
+		return
+	}
+	v.drawVideo()
+	fmt.Fprintf(v.Out, "\x1b[%d;1H\x1b[?25h", v.videoRows+1)
+	v.videoFrame = nil
+}
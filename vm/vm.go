@@ -0,0 +1,949 @@
+// Package vm is an LC-3 machine that can be embedded in other Go programs.
+package vm
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/eiannone/keyboard"
+
+	"lc3/decode"
+)
+
+// consts
+const MEMORY_MAX int = int(1 << 16)
+
+const PC_START = 0x3000 // where programs start running, unless a boot ROM says otherwise
+
+const DEVICE_START = 0xFE00 // the device register page runs from here to the top, whatever the memory size
+
+const ( // registers
+	R_R0 = iota
+	R_R1
+	R_R2
+	R_R3
+	R_R4
+	R_R5
+	R_R6
+	R_R7
+	R_PC // program counter
+	R_COND
+	R_COUNT // the count of registers
+)
+
+const ( // opcodes, decode has them too
+	OP_BR   = decode.BR   // branch
+	OP_ADD  = decode.ADD  // add
+	OP_LD   = decode.LD   // load
+	OP_ST   = decode.ST   // store
+	OP_JSR  = decode.JSR  // jump register
+	OP_AND  = decode.AND  // bitwise and
+	OP_LDR  = decode.LDR  // load register
+	OP_STR  = decode.STR  // store register
+	OP_RTI  = decode.RTI  // return from interrupt
+	OP_NOT  = decode.NOT  // bitwise not
+	OP_LDI  = decode.LDI  // load indirect
+	OP_STI  = decode.STI  // store indirect
+	OP_JMP  = decode.JMP  // jump
+	OP_RES  = decode.RES  // reserved(unused)
+	OP_LEA  = decode.LEA  // load effective address
+	OP_TRAP = decode.TRAP // execute trap
+)
+
+const ( // conditional flags
+	FL_POS = 1 << 0
+	FL_ZRO = 1 << 1
+	FL_NEG = 1 << 2
+)
+
+/* trap routines */
+const (
+	TRAP_GETC  = 0x20 /* get character from keyboard, not echoed onto the terminal */
+	TRAP_OUT   = 0x21 /*output a chacarter*/
+	TRAP_PUTS  = 0x22 /* output a word string */
+	TRAP_IN    = 0x23 /* get a character from keyboard, echoed onto the terminal */
+	TRAP_PUTSP = 0x24 /* output a byte string */
+	TRAP_HALT  = 0x25 /* halt a program */
+)
+
+const ( // memory mapped registers - they allow the system to 'sleep' while waiting for user input from the keyboard
+	MR_KBSR = 0xFE00 // 'event listener'
+	MR_KBDR = 0xFE02 // data from keyboard
+	MR_DSR  = 0xFE04 // display status, bit 15 set when the display takes a character
+	MR_DDR  = 0xFE06 // display data, the low byte of a write goes to the console
+	MR_WDT  = 0xFE0C // watchdog, any write pets it, reads give the instructions left
+)
+
+const DSR_READY = 1 << 15
+
+// VM is one LC-3 machine. it owns its memory, registers and console, and
+// every optional feature keeps its state in here too, so machines don't share anything
+type VM struct {
+	memory  []uint16 // a 65,536 sized empty array
+	memSize int      // words of RAM from x0000 up, the rest below the device page isn't there
+	reg     [R_COUNT]uint16
+	mutex   sync.Mutex
+
+	Keys <-chan keyboard.KeyEvent // key presses, from a real keyboard
+	In   io.Reader                // read as typed characters when Keys isn't set, nil for a machine without a keyboard
+	Out  io.Writer                // where everything the program prints goes
+
+	segments []segment
+	startPC  uint16
+
+	trace           io.Writer
+	maxInstructions uint64 // per Run, 0 for no limit
+	instrLimit      uint64 // instrCount the current Run stops at, 0 for none
+
+	trapHandlers map[uint16]TrapHandler
+	trapSpec     bool            // TRAP goes through the supervisor stack, see SetupTrapMode
+	trapTable    bool            // TRAP jumps to the routine in the trap vector table, see SetupTrapMode
+	ctx          context.Context // of the current Run, blocking reads give up when it's done
+
+	counters
+	scancodeState
+	keymapState
+	protection
+	stackCheck
+	heapState
+	extensions
+	watchdog
+	timer
+	clock
+	disk
+	serial
+	video
+	display
+	beeper
+	pcm
+	gamepad
+	board
+	arguments
+	gpio
+	printer
+	sensor
+	mailbox
+	nic
+	banking
+	perfCounters
+	watches
+	historyState
+	smcState
+	encoding string
+	usageState
+	coverage []uint64 // execution count per address, only kept when coverage is on
+	profileState
+	nvram
+	transcriptState
+	statusState
+	devices
+	deviceLayout
+	eventState
+	control
+	determinism
+	interrupts
+	stepping *StepInfo // filled in by the instruction Step is running
+}
+
+// a chunk of memory filled in from an image file
+type segment struct {
+	path   string
+	origin uint16
+	length int      // in words
+	rom    bool     // loaded by LoadROM rather than LoadFile
+	data   []uint16 // what LoadImage or LoadWords put there, there's no file to read again
+}
+
+// Config describes the machine to build, the zero value is a standard LC-3
+type Config struct {
+	MemorySize int    // words of RAM starting at x0000, at most MEMORY_MAX (the default)
+	StartPC    uint16 // where programs start running, PC_START if 0
+}
+
+// New makes a standard machine with empty memory, the console on stdin/stdout and the extension
+// traps installed, then applies opts. it panics if an option is invalid, NewWithConfig returns the error
+func New(opts ...Option) *VM {
+	v, err := NewWithConfig(Config{}, opts...)
+	if err != nil {
+		panic("vm.New: " + err.Error())
+	}
+	return v
+}
+
+// NewWithConfig is New for a machine described by cfg, with opts applied after it
+func NewWithConfig(cfg Config, opts ...Option) (*VM, error) {
+	v := &VM{
+		memory:       make([]uint16, MEMORY_MAX),
+		memSize:      MEMORY_MAX,
+		In:           os.Stdin,
+		Out:          os.Stdout,
+		startPC:      PC_START,
+		trapHandlers: map[uint16]TrapHandler{},
+		ctx:          context.Background(),
+		encoding:     "latin1",
+	}
+	v.ctlCond = sync.NewCond(&v.ctl)
+	v.rng = rand.New(rand.NewSource(time.Now().UnixNano()))
+	v.SetupHistory(16)
+	v.protected = make([]bool, MEMORY_MAX)
+	v.watched = make([]bool, MEMORY_MAX)
+	v.charMap = map[uint16]keyTarget{}
+	v.specialMap = map[keyboard.Key]keyTarget{}
+	v.trapCounts = map[uint16]uint64{}
+	v.sspStart, v.sspLimit = SSP_START, SSP_LIMIT
+	v.intHighest.Store(-1)
+	for vector, handler := range extensionTraps {
+		v.RegisterTrap(vector, handler)
+	}
+	if cfg.MemorySize != 0 {
+		opts = append([]Option{WithMemorySize(cfg.MemorySize)}, opts...)
+	}
+	if cfg.StartPC != 0 {
+		opts = append([]Option{WithStartPC(cfg.StartPC)}, opts...)
+	}
+	for _, opt := range opts {
+		if err := opt(v); err != nil {
+			return nil, err
+		}
+	}
+	v.resetCPU()
+	return v, nil
+}
+
+func (v *VM) updateFlags(r int) {
+	if v.reg[r] == 0 {
+		v.reg[R_COND] = FL_ZRO
+	} else if v.reg[r]>>15 != 0 { // a '1' in the left-most bit indicates a negative. we get there by bitshiting with 15 becuaes it has 16 bits
+		v.reg[R_COND] = FL_NEG
+	} else {
+		v.reg[R_COND] = FL_POS
+	}
+}
+
+// resetCPU clears the registers and points the PC at the program start, memory is left alone
+func (v *VM) resetCPU() {
+	v.reg = [R_COUNT]uint16{}
+	v.reg[R_COND] = FL_ZRO
+	v.savedSSP, v.savedUSP = v.sspStart, 0
+
+	// setting PC to default position
+	v.reg[R_PC] = v.startPC
+}
+
+// ResetCPU puts the registers back to how they are at power on, memory is left alone.
+// a boot ROM loaded since New moves the PC to its reset vector
+func (v *VM) ResetCPU() {
+	v.resetCPU()
+}
+
+// Reg reads a register, R_PC and R_COND included
+func (v *VM) Reg(r int) uint16 {
+	return v.reg[r]
+}
+
+// SetReg sets a register without touching the condition flags
+func (v *VM) SetReg(r int, value uint16) {
+	v.reg[r] = value
+}
+
+// Peek reads memory directly, without the side effects a load has on device registers
+func (v *VM) Peek(address uint16) uint16 {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	return v.memory[address]
+}
+
+// Poke writes memory directly, skipping devices, protection and the rest of what a store goes through
+func (v *VM) Poke(address uint16, value uint16) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.memory[address] = value
+}
+
+// mapped says whether there's RAM or a device register at address
+func (v *VM) mapped(address uint16) bool {
+	return int(address) < v.memSize || address >= DEVICE_START
+}
+
+// checkMapped makes sure there's memory for length words from start
+func (v *VM) checkMapped(start uint16, length int) error {
+	for a := int(start); a < int(start)+length && a < MEMORY_MAX; a++ {
+		if !v.mapped(uint16(a)) {
+			return fmt.Errorf("x%04X is past the end of memory (%d words)", a, v.memSize)
+		}
+	}
+	return nil
+}
+
+func (v *VM) memRead(address uint16) uint16 {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	if !v.mapped(address) {
+		v.memFault(ErrMemFault{Addr: address, PC: v.reg[R_PC] - 1, Access: "read"})
+	}
+	if v.user && v.userDenied(address) {
+		return 0
+	}
+	v.memAccesses++
+
+	if v.touched != nil {
+		v.touched[address] |= TOUCH_READ
+	}
+	if v.stepping != nil {
+		v.stepping.Reads = append(v.stepping.Reads, address)
+	}
+	if v.deviceMoves != nil && address >= DEVICE_START {
+		address = v.deviceAddress(address)
+	}
+
+	if address == MR_KBSR && v.kbsrControl&KBSR_IE == 0 { // with interrupts on, keys come in between instructions
+		if v.kbsrControl&KBSR_SCANCODE != 0 {
+			v.pollScancode()
+		} else if char, ok := v.pollChar(); ok {
+			v.memory[MR_KBSR] = KBSR_READY | v.kbsrControl
+			v.memory[MR_KBDR] = char
+		} else {
+			v.memory[MR_KBSR] = v.kbsrControl
+		}
+	}
+	if address == MR_KBDR && v.kbdLatched {
+		v.kbdLatched = false
+		v.memory[MR_KBSR] &^= KBSR_READY
+	}
+
+	if address == MR_DSR { // the console never keeps a program waiting
+		v.memory[MR_DSR] = DSR_READY
+	}
+
+	if address == MR_TMR || address == MR_TMC {
+		v.memory[address] = v.timerRead(address)
+	}
+	if address == MR_RTCH || address == MR_RTCL || address == MR_RTCM {
+		v.memory[address] = v.clockRead(address)
+	}
+	if address == MR_RNG {
+		v.memory[MR_RNG] = uint16(v.rng.Uint32())
+	}
+	if address == MR_DSKS {
+		v.memory[MR_DSKS] = v.diskStatus()
+	}
+	if address >= MR_MBS && address <= MR_MBF && v.mailIn != nil {
+		v.memory[address] = v.mailRead(address)
+	}
+	if address == MR_NICS && v.nicConn != nil {
+		v.memory[MR_NICS] = v.nicStatus()
+	}
+	if address == MR_BANK && v.bankCount != 0 {
+		v.memory[MR_BANK] = uint16(v.bankCurrent)
+	}
+	if address >= MR_PERF && address <= PERF_END && address%2 == 0 {
+		v.memory[address] = v.perfRead(address)
+	}
+	if address >= MR_ARGC && address <= MR_ARGD {
+		v.memory[address] = v.argRead(address)
+	}
+	if address == MR_PSR {
+		v.memory[MR_PSR] = v.psr()
+	}
+	if address >= MR_SENS && address <= MR_SENT && v.sensorWave != nil {
+		v.memory[address] = v.sensorRead(address)
+	}
+	if address == MR_LPS && v.lpFile != nil {
+		v.memory[MR_LPS] = v.printerStatus()
+	}
+	if address == MR_GPI && v.gpioOn {
+		v.memory[MR_GPI] = v.gpioRead()
+	}
+	if address == MR_SW && v.boardOn {
+		v.memory[MR_SW] = uint16(v.switches.Load())
+	}
+	if address == MR_PAD && v.padOn {
+		v.memory[MR_PAD] = v.padRead()
+	}
+	if address == MR_PCMF && v.pcmOn {
+		v.memory[MR_PCMF] = v.pcmFill()
+	}
+	if p := v.serialAt(address); p != nil {
+		v.memory[address] = p.read(address)
+	}
+
+	if address == MR_WDT {
+		v.memory[MR_WDT] = v.watchdogRemaining()
+	}
+
+	if p := v.deviceAt[address]; p != nil {
+		v.memory[address] = p.read(v, address)
+	}
+
+	return v.memory[address]
+}
+
+func (v *VM) memWrite(address uint16, value uint16) {
+	if !v.mapped(address) {
+		v.memFault(ErrMemFault{Addr: address, PC: v.reg[R_PC] - 1, Access: "write"})
+	}
+	if v.user && v.userDenied(address) {
+		return
+	}
+	if v.protected[address] {
+		if v.protectIgnore {
+			return
+		}
+		v.accessViolation(address, ErrMemFault{Addr: address, PC: v.reg[R_PC] - 1, Access: "write", Protected: true})
+		return
+	}
+
+	v.memAccesses++
+	if v.touched != nil {
+		v.touched[address] |= TOUCH_WRITE
+	}
+	if v.stepping != nil {
+		v.stepping.Writes = append(v.stepping.Writes, address)
+	}
+	if v.subscribed.Load() {
+		v.emit(Event{Kind: EVENT_MEM, PC: v.reg[R_PC] - 1, Addr: address, Old: v.memory[v.deviceAddress(address)], New: value})
+	}
+	if v.deviceMoves != nil && address >= DEVICE_START {
+		address = v.deviceAddress(address)
+	}
+
+	if address == MR_WDT {
+		v.petWatchdog()
+		return
+	}
+	if address == MR_TMR || address == MR_TMC {
+		v.timerWrite(address, value)
+		return
+	}
+	if address == MR_RNG {
+		v.SetupSeed(int64(value))
+		return
+	}
+	if address == MR_BANK && v.bankCount != 0 {
+		v.switchBank(value)
+		return
+	}
+	if address >= MR_MBS && address <= MR_MBF && v.mailIn != nil {
+		v.mailWrite(address, value)
+		return
+	}
+	if address == MR_NICS && v.nicConn != nil {
+		v.nicIE = value&NICS_IE != 0
+		return
+	}
+	if address == MR_NICC && v.nicConn != nil {
+		v.memory[MR_NICC] = value
+		v.nicCommand(value)
+		return
+	}
+	if address == MR_PSR {
+		v.setPSR(value)
+		return
+	}
+	if (address == MR_SENS || address == MR_SENT) && v.sensorWave != nil {
+		v.sensorWrite(address, value)
+		return
+	}
+	if address == MR_LPD && v.lpFile != nil {
+		v.printerWrite(value)
+		return
+	}
+	if address == MR_GPO && v.gpioOn {
+		v.gpioWrite(value)
+		return
+	}
+	if address == MR_ARGN {
+		v.argWrite(value)
+		return
+	}
+	if address >= MR_LED && address <= MR_SEG3 && v.boardOn {
+		v.boardWrite(address, value)
+		return
+	}
+	if address == MR_PCMD && v.pcmOn {
+		v.pcmWrite(value)
+		return
+	}
+	if address == MR_BEEPD {
+		v.memory[MR_BEEPD] = value
+		v.beep(value)
+		return
+	}
+	if address == MR_DSKC {
+		v.memory[MR_DSKC] = value
+		v.diskCommand(value)
+		return
+	}
+	if p := v.serialAt(address); p != nil {
+		p.write(address, value)
+		v.memory[address] = value
+		return
+	}
+
+	if p := v.deviceAt[address]; p != nil {
+		p.write(v, address, value)
+		v.memory[address] = value
+		return
+	}
+
+	if readOnlyRegister(address) {
+		return
+	}
+	if address == MR_DDR {
+		v.putChar(value & 0xFF)
+		v.memory[MR_DDR] = value
+		return
+	}
+
+	if address == MR_KBSR { // only the control bits are writable
+		v.kbsrControl = value & KBSR_CONTROL
+		v.memory[MR_KBSR] = v.memory[MR_KBSR]&KBSR_READY | v.kbsrControl
+		return
+	}
+
+	if v.watched[address] {
+		v.logWatch(address, value)
+	}
+
+	if v.executed != nil && v.executed[address] {
+		v.checkSMC(address, value)
+	}
+
+	v.memory[address] = value
+}
+
+// readOnlyRegister says whether address is a device register stores leave alone
+func readOnlyRegister(address uint16) bool {
+	switch address {
+	case MR_DSR, MR_ACVA, MR_DSKS, MR_LPS, MR_PAD, MR_SW, MR_GPI, MR_SENV, MR_ARGC, MR_ARGD, MR_RTCH, MR_RTCL, MR_RTCM:
+		return true
+	}
+	return address >= MR_PERF && address <= PERF_END
+}
+
+// LoadFile reads an object file (a big endian origin word, then the words that go there) into memory
+func (v *VM) LoadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	origin, words, err := readImage(file)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return v.place(segment{path: path, origin: origin}, words)
+}
+
+// LoadImage is LoadFile for an object image read from r, an embedded asset or a network download say
+func (v *VM) LoadImage(r io.Reader) error {
+	origin, words, err := readImage(r)
+	if err != nil {
+		return err
+	}
+	return v.place(segment{path: "image", origin: origin, data: words}, words)
+}
+
+// LoadWords puts words into memory from origin on, like an image with that origin would
+func (v *VM) LoadWords(origin uint16, words []uint16) error {
+	return v.place(segment{path: "words", origin: origin, data: append([]uint16{}, words...)}, words)
+}
+
+// readImage splits an object image into its origin and the words that go there
+func readImage(r io.Reader) (uint16, []uint16, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(data) < 2 {
+		return 0, nil, io.ErrUnexpectedEOF
+	}
+
+	// the first word is the origin, big endian like the rest
+	origin := binary.BigEndian.Uint16(data)
+	words := make([]uint16, (len(data)-2)/2)
+	for i := range words {
+		words[i] = binary.BigEndian.Uint16(data[2+2*i:])
+	}
+	return origin, words, nil
+}
+
+// place copies words into memory at seg.origin - only as many words as the image holds, so a
+// second image doesn't wipe the first - and records the segment
+func (v *VM) place(seg segment, words []uint16) error {
+	seg.length = len(words)
+	if int(seg.origin)+seg.length > MEMORY_MAX {
+		seg.length = MEMORY_MAX - int(seg.origin)
+	}
+	if err := v.checkMapped(seg.origin, seg.length); err != nil {
+		return fmt.Errorf("%s: %v", seg.path, err)
+	}
+	copy(v.memory[seg.origin:], words[:seg.length])
+
+	v.segments = append(v.segments, seg)
+	return nil
+}
+
+// how many instructions run between looks at the context
+const CANCEL_CHECK_INTERVAL = 1024
+
+// Run executes instructions until the program halts. a fault in the program ends
+// the run with an error, DumpHistory shows how it got there. cancelling ctx stops the
+// machine within a few instructions, or straight away if it's waiting for a key,
+// and Run returns ctx.Err(). Pause, Resume and Stop control it from other goroutines
+func (v *VM) Run(ctx context.Context) (err error) {
+	ctx, cancel := context.WithCancel(ctx) // for Stop
+	v.startRun(cancel)
+	v.ctx = ctx
+	defer func() {
+		cancel()
+		v.clearStatus()
+		v.endVideo()
+		v.endDisplay()
+		v.ctx = context.Background()
+		v.endRun(&err)
+	}()
+	defer v.recoverFault(ctx, &err)
+
+	v.startTime = time.Now()
+	v.exitCode = 0
+	v.instrLimit = 0
+	if v.maxInstructions != 0 {
+		v.instrLimit = v.instrCount + v.maxInstructions
+	}
+	for {
+		if v.ctlPoll.Load() && !v.park() {
+			return nil
+		}
+		if v.instrCount == v.instrLimit && v.instrLimit != 0 {
+			v.haltReason = "instruction limit"
+			return ErrMaxInstructions
+		}
+		if v.instrCount%CANCEL_CHECK_INTERVAL == 0 && ctx.Err() != nil {
+			v.haltReason = "canceled"
+			return ctx.Err()
+		}
+		if !v.execute() {
+			return nil
+		}
+	}
+}
+
+// recoverFault, deferred, turns a fault raised while running into the error it returns
+func (v *VM) recoverFault(ctx context.Context, err *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	f, ok := r.(faultError)
+	if !ok {
+		panic(r)
+	}
+	v.haltReason = "fault: " + f.err.Error()
+	*err = f.err
+	if ctx.Err() != nil { // the fault is a key read giving up
+		v.haltReason = "canceled"
+		*err = ctx.Err()
+	}
+}
+
+// execute runs the instruction at the PC, it says false once the machine has stopped
+func (v *VM) execute() bool {
+	if v.kbsrControl&KBSR_IE != 0 {
+		v.keyboardInterrupt()
+	}
+	if v.mailIE {
+		v.mailInterrupt()
+	}
+	if v.nicIE {
+		v.nicInterrupt()
+	}
+	if v.sensorIE {
+		v.sensorInterrupt()
+	}
+	if v.intHighest.Load() > int32(v.priority) {
+		v.takeInterrupt()
+	}
+
+	// fetch
+	pc := v.reg[R_PC]
+	sp, user := v.reg[R_R6], v.user
+	before := v.reg
+	if !v.mapped(pc) {
+		v.historyBegin(pc, 0)
+		v.memFault(ErrMemFault{Addr: pc, PC: pc, Access: "execute"})
+	}
+	instr := v.fetch(v.reg[R_PC])
+	if v.stepping != nil {
+		v.stepping.PC = pc
+		v.stepping.Instr = instr
+		v.stepping.Reads = nil // the fetch isn't one of the instruction's own reads, nor is taking an interrupt
+		v.stepping.Writes = nil
+	}
+	v.historyBegin(pc, instr)
+	v.instrCount++
+	if v.coverage != nil {
+		v.coverage[pc]++
+	}
+	if v.executed != nil {
+		v.executed[pc] = true
+	}
+	v.reg[R_PC]++
+	in := decode.Decode(instr)
+
+	running := v.executeOp(in, instr, pc)
+
+	if v.excRaised { // nothing the instruction did sticks, the PC moves on past it
+		v.excRaised = false
+		v.reg = before
+		if v.excUnhandled {
+			v.excUnhandled = false
+			v.haltReason = "unhandled exception"
+			running = false
+		} else {
+			v.reg[R_PC] = pc + 1
+			v.serviceRoutine(v.excVector, v.priority)
+		}
+	}
+
+	if v.subscribed.Load() {
+		v.emitRegisters(pc, before)
+	}
+	if v.trace != nil {
+		v.traceStep(pc, instr, before)
+	}
+	if v.stackChecked && v.reg[R_R6] != sp && v.user == user && user == v.startUser {
+		v.checkStack(pc)
+	}
+	v.historyEnd()
+	if v.haltRequested {
+		fmt.Fprintln(v.Out, "HALT (key)")
+		v.haltReason = "halt key"
+		running = false
+	}
+	if v.profiling {
+		v.profileStep(instr)
+	}
+
+	if v.statusOn {
+		v.statusStep(v.reg[R_PC])
+	}
+	if v.videoOn {
+		v.videoStep()
+	}
+	if v.displayOn {
+		v.displayStep()
+	}
+
+	if v.timerRunning {
+		v.timerStep()
+	}
+
+	if v.watchdogExpired() {
+		switch v.watchdogAction {
+		case "interrupt":
+			v.deviceInterrupt(WATCHDOG_VECTOR)
+			v.petWatchdog()
+		case "reset":
+			log.Printf("watchdog expired (PC=0x%04X)", pc)
+			v.resetCPU()
+			v.petWatchdog()
+		default:
+			log.Printf("watchdog expired (PC=0x%04X)", pc)
+			v.haltReason = "watchdog"
+			running = false
+		}
+	}
+	return running
+}
+
+// executeOp carries out the decoded instruction. an exception it raises unwinds it there and then,
+// so nothing after the faulting access happens, no store in particular
+func (v *VM) executeOp(in decode.Instruction, instr, pc uint16) (running bool) {
+	running = true
+	v.inInstruction = true
+	defer func() {
+		v.inInstruction = false
+		if v.excRaised {
+			if r := recover(); r != nil && r != (exceptionUnwind{}) {
+				panic(r)
+			}
+		}
+	}()
+
+	switch in.Op {
+	case OP_ADD:
+		if in.Immediate {
+			v.reg[in.DR] = v.reg[in.SR1] + uint16(in.Imm)
+		} else {
+			v.reg[in.DR] = v.reg[in.SR1] + v.reg[in.SR2]
+		}
+		v.updateFlags(in.DR)
+	case OP_AND:
+		if in.Immediate {
+			v.reg[in.DR] = v.reg[in.SR1] & uint16(in.Imm)
+		} else {
+			v.reg[in.DR] = v.reg[in.SR1] & v.reg[in.SR2]
+		}
+		v.updateFlags(in.DR)
+	case OP_NOT:
+		v.reg[in.DR] = ^v.reg[in.SR1] // ^ is the nitwise XOR
+		v.updateFlags(in.DR)
+	case OP_BR:
+		if in.CC&v.reg[R_COND] != 0 {
+			v.reg[R_PC] += uint16(in.Imm)
+		}
+	case OP_JMP:
+		v.reg[R_PC] = v.reg[in.SR1]
+	case OP_JSR:
+		v.reg[R_R7] = v.reg[R_PC]
+		if in.Immediate {
+			v.reg[R_PC] = v.reg[R_PC] + uint16(in.Imm)
+		} else {
+			v.reg[R_PC] = v.reg[in.SR1]
+		}
+	case OP_LD:
+		v.reg[in.DR] = v.memRead(v.reg[R_PC] + uint16(in.Imm))
+		v.updateFlags(in.DR)
+	case OP_LDI:
+		v.reg[in.DR] = v.memRead(v.memRead(v.reg[R_PC] + uint16(in.Imm)))
+		v.updateFlags(in.DR)
+	case OP_LDR:
+		v.reg[in.DR] = v.memRead(v.reg[in.SR1] + uint16(in.Imm))
+		v.updateFlags(in.DR)
+	case OP_LEA:
+		v.reg[in.DR] = v.reg[R_PC] + uint16(in.Imm)
+		v.updateFlags(in.DR)
+	case OP_ST:
+		v.memWrite(v.reg[R_PC]+uint16(in.Imm), v.reg[in.DR])
+	case OP_STI:
+		address := v.memRead(v.reg[R_PC] + uint16(in.Imm))
+		v.memWrite(address, v.reg[in.DR])
+	case OP_STR:
+		v.memWrite(v.reg[in.SR1]+uint16(in.Imm), v.reg[in.DR])
+	case OP_TRAP:
+		v.trapCounts[in.Vector]++
+		if v.subscribed.Load() {
+			v.emit(Event{Kind: EVENT_TRAP, PC: pc, Addr: in.Vector})
+		}
+		if !v.trapSpec {
+			v.reg[R_R7] = v.reg[R_PC]
+			if routine := v.memory[TRAP_TABLE_START+in.Vector]; v.trapTable && routine != 0 {
+				v.reg[R_PC] = routine
+			} else {
+				running = v.builtinTrap(in.Vector, pc)
+			}
+			break
+		}
+		if !v.enterSupervisor() {
+			v.reg[R_PC] = v.memRead(INTERRUPT_TABLE_START + EXC_STACK)
+			break
+		}
+		if routine := v.memory[TRAP_TABLE_START+in.Vector]; routine != 0 {
+			v.reg[R_PC] = routine
+			break
+		}
+		running = v.builtinTrap(in.Vector, pc)
+		v.rti() // as if the routine had returned
+	case OP_RES:
+		if v.extMulDiv {
+			v.execMulDiv(instr, pc)
+		} else if v.extShift {
+			v.execShift(instr, pc)
+		} else {
+			v.exception(EXC_ILLEGAL, fmt.Errorf("%w 0x%04X (PC=0x%04X)", ErrBadOpcode, instr, pc))
+		}
+	case OP_RTI:
+		v.rti()
+	}
+	return running
+}
+
+// builtinTrap runs the trap routine for vector written in Go, a registered one or one of the
+// standard ones. it says whether the machine keeps running
+func (v *VM) builtinTrap(vector, pc uint16) bool {
+	if handler, ok := v.trapHandlers[vector]; ok {
+		if err := handler(v); err != nil {
+			v.fault("trap 0x%02X: %w (PC=0x%04X)", vector, err, pc)
+		}
+		return true
+	}
+
+	switch vector {
+	case TRAP_GETC:
+		char, err := v.readChar()
+		if err != nil {
+			v.fault("tried reading entered char, failed: %w", err)
+		}
+		v.reg[R_R0] = char
+		v.updateFlags(R_R0)
+	case TRAP_OUT:
+		char := v.reg[R_R0]
+		v.putChar(char)
+	case TRAP_PUTS:
+		address := v.reg[R_R0]
+		var chr uint16
+		var i uint16
+		for ok := true; ok; ok = (chr != 0x0) {
+			chr = v.memory[address+i] & 0xFFFF
+			v.putChar(chr)
+			i++
+		}
+	case TRAP_PUTSP:
+		address := v.reg[R_R0]
+		for i := uint16(0); ; i++ {
+			chr := v.memory[address+i]
+			if chr == 0 {
+				break
+			}
+
+			char1 := chr & 0xFF
+			v.putChar(char1)
+
+			char2 := chr >> 8
+			if char2 != 0 {
+				v.putChar(char2)
+			}
+			i++
+		}
+	case TRAP_IN:
+		fmt.Fprintln(v.Out, "Enter character: ")
+		char, err := v.readChar()
+		if err != nil {
+			v.fault("tried reading entered char, failed: %w", err)
+		}
+		v.reg[R_R0] = char
+		v.updateFlags(R_R0)
+		/* case TRAP_PUTSP: */
+		// trap whatever
+	case TRAP_HALT:
+		fmt.Fprintln(v.Out, "HALT")
+		v.haltReason = "halt"
+		v.exitCode = int(v.reg[R_R0] & 0xFF)
+		return false
+	}
+	return true
+}
+
+// Close hangs up on device plugins, serial ports and the network, stops the sound and closes the
+// disk, the display window, the transcript and event channels, call it once the machine is done with
+func (v *VM) Close() {
+	v.closeDevices()
+	v.closeDisk()
+	v.closeSerialPorts()
+	v.closeNIC()
+	v.closePCM()
+	v.closeGPIO()
+	v.closePrinter()
+	v.closeDisplayWindow()
+	v.closeTranscript()
+	v.closeSubscribers()
+}
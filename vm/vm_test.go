@@ -0,0 +1,2213 @@
+package vm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eiannone/keyboard"
+
+	"lc3/decode"
+	"lc3/encode"
+)
+
+// sums 1..n into R2, n is at x3006
+var sumProgram = []uint16{
+	encode.LD(1, 5),
+	encode.AND(2, 2, encode.Imm(0)),
+	encode.ADD(2, 2, encode.Reg(1)),
+	encode.ADD(1, 1, encode.Imm(-1)),
+	encode.BR(decode.CC_P, -3),
+	encode.HALT(),
+}
+
+// echoes characters up to a newline
+var echoProgram = []uint16{
+	encode.GETC(),
+	encode.ADD(1, 0, encode.Imm(-10)),
+	encode.BR(decode.CC_Z, 2), // to the HALT
+	encode.OUT(),
+	encode.BR(decode.CC_N|decode.CC_Z|decode.CC_P, -5),
+	encode.HALT(),
+}
+
+func load(v *VM, program []uint16) {
+	for i, word := range program {
+		v.Poke(PC_START+uint16(i), word)
+	}
+}
+
+func TestConcurrentMachines(t *testing.T) {
+	const machines = 8
+	var wg sync.WaitGroup
+	for i := 0; i < machines; i++ {
+		wg.Add(1)
+		go func(n uint16) {
+			defer wg.Done()
+			v := New()
+			v.Out = &bytes.Buffer{}
+			load(v, sumProgram)
+			v.Poke(PC_START+6, n)
+			if err := v.Run(context.Background()); err != nil {
+				t.Errorf("n=%d: %v", n, err)
+				return
+			}
+			if want := n * (n + 1) / 2; v.Reg(R_R2) != want {
+				t.Errorf("n=%d: R2 = %d, want %d", n, v.Reg(R_R2), want)
+			}
+			if got := v.Stats().HaltReason; got != "halt" {
+				t.Errorf("n=%d: halt reason %q", n, got)
+			}
+		}(uint16(100 + i*10))
+	}
+	wg.Wait()
+}
+
+func TestConcurrentConsoles(t *testing.T) {
+	const machines = 8
+	var wg sync.WaitGroup
+	for i := 0; i < machines; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			text := fmt.Sprintf("machine %d says hi", i)
+			var out bytes.Buffer
+			v := New()
+			v.In = strings.NewReader(text + "\n")
+			v.Out = &out
+			load(v, echoProgram)
+			if err := v.Run(context.Background()); err != nil {
+				t.Errorf("machine %d: %v", i, err)
+				return
+			}
+			if want := text + "HALT\n"; out.String() != want {
+				t.Errorf("machine %d printed %q, want %q", i, out.String(), want)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestMachinesDontShareState(t *testing.T) {
+	a, b := New(), New()
+	a.Poke(0x4000, 0x1234)
+	a.SetReg(R_R3, 7)
+	if b.Peek(0x4000) != 0 || b.Reg(R_R3) != 0 {
+		t.Fatal("a change to one machine showed up in another")
+	}
+}
+
+func TestCancelOneOfMany(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stuck := New()
+	stuck.Out = &bytes.Buffer{}
+	stuck.Poke(PC_START, encode.BR(decode.CC_N|decode.CC_Z|decode.CC_P, -1))
+	done := make(chan error)
+	go func() { done <- stuck.Run(ctx) }()
+
+	other := New()
+	other.Out = &bytes.Buffer{}
+	load(other, sumProgram)
+	other.Poke(PC_START+6, 10)
+	if err := other.Run(context.Background()); err != nil || other.Reg(R_R2) != 55 {
+		t.Fatalf("other machine: R2 = %d, err %v", other.Reg(R_R2), err)
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("stuck machine returned %v, want context.Canceled", err)
+	}
+}
+
+func TestPauseResumeStop(t *testing.T) {
+	v := New()
+	v.Out = &bytes.Buffer{}
+	v.Poke(PC_START, encode.ADD(0, 0, encode.Imm(1)))
+	v.Poke(PC_START+1, encode.BR(decode.CC_N|decode.CC_Z|decode.CC_P, -2))
+	done := make(chan error)
+	go func() { done <- v.Run(context.Background()) }()
+
+	v.Pause()
+	count := v.Reg(R_R0)
+	v.SetReg(R_R1, 42)
+	if v.Reg(R_R0) != count {
+		t.Fatal("the machine kept running while paused")
+	}
+	v.Resume()
+	v.Pause()
+	if v.Reg(R_R1) != 42 {
+		t.Fatal("a register set while paused was lost")
+	}
+	v.Resume()
+
+	v.Stop()
+	if err := <-done; err != nil {
+		t.Fatalf("Run returned %v after Stop", err)
+	}
+	if got := v.Stats().HaltReason; got != "stopped" {
+		t.Fatalf("halt reason %q, want stopped", got)
+	}
+}
+
+func TestStopWaitingForKey(t *testing.T) {
+	keys, _ := io.Pipe() // never typed on
+	v := New()
+	v.In = keys
+	v.Out = &bytes.Buffer{}
+	load(v, echoProgram)
+	done := make(chan error)
+	go func() { done <- v.Run(context.Background()) }()
+
+	v.Pause() // returns once the GETC is waiting
+	v.Resume()
+	v.Stop()
+	if err := <-done; err != nil {
+		t.Fatalf("Run returned %v after Stop", err)
+	}
+}
+
+func TestFaultErrors(t *testing.T) {
+	run := func(program []uint16, setup func(v *VM)) error {
+		v := New()
+		v.In = strings.NewReader("")
+		v.Out = &bytes.Buffer{}
+		load(v, program)
+		if setup != nil {
+			setup(v)
+		}
+		return v.Run(context.Background())
+	}
+
+	if err := run([]uint16{0xD000}, nil); !errors.Is(err, ErrBadOpcode) {
+		t.Errorf("reserved opcode: got %v, want ErrBadOpcode", err)
+	}
+
+	err := run([]uint16{encode.LDR(0, 1, 0)}, func(v *VM) {
+		v.memSize = 0x4000
+		v.SetReg(R_R1, 0x8000)
+	})
+	var mf ErrMemFault
+	if !errors.As(err, &mf) || mf.Addr != 0x8000 || mf.Access != "read" {
+		t.Errorf("unmapped read: got %v, want an ErrMemFault at x8000", err)
+	}
+
+	if err := run([]uint16{encode.GETC()}, nil); !errors.Is(err, ErrIO) || !errors.Is(err, io.EOF) {
+		t.Errorf("GETC at end of input: got %v, want ErrIO wrapping io.EOF", err)
+	}
+}
+
+func TestReset(t *testing.T) {
+	image := []byte{0x30, 0x00} // origin x3000
+	for _, word := range sumProgram {
+		image = append(image, byte(word>>8), byte(word))
+	}
+	image = append(image, 0, 4) // n
+	path := t.TempDir() + "/sum.obj"
+	if err := os.WriteFile(path, image, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	v := New()
+	v.Out = &bytes.Buffer{}
+	if err := v.LoadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	for i, reload := range []bool{false, false, true} {
+		if i > 0 {
+			v.Poke(PC_START+6, 3) // only a reload undoes this
+			if err := v.Reset(reload); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := v.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		want := uint16(6)
+		if i == 0 || reload {
+			want = 10
+		}
+		if v.Reg(R_R2) != want || v.Stats().Instructions != uint64(3+3*v.Peek(PC_START+6)) {
+			t.Errorf("run %d: R2 = %d after %d instructions", i, v.Reg(R_R2), v.Stats().Instructions)
+		}
+	}
+}
+
+func TestMaxInstructions(t *testing.T) {
+	v := New(WithMaxInstructions(100))
+	v.Out = &bytes.Buffer{}
+	v.Poke(PC_START, encode.BR(decode.CC_N|decode.CC_Z|decode.CC_P, -1))
+	if err := v.Run(context.Background()); !errors.Is(err, ErrMaxInstructions) {
+		t.Fatalf("got %v, want ErrMaxInstructions", err)
+	}
+	if n := v.Stats().Instructions; n != 100 {
+		t.Fatalf("ran %d instructions, want 100", n)
+	}
+}
+
+func TestOptions(t *testing.T) {
+	var out, trace bytes.Buffer
+	v := New(WithConsole(strings.NewReader("x"), &out), WithTrace(&trace), WithStartPC(0x4000))
+	v.Poke(0x4000, encode.GETC())
+	v.Poke(0x4001, encode.OUT())
+	v.Poke(0x4002, encode.HALT())
+	if err := v.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "xHALT\n" {
+		t.Errorf("printed %q", out.String())
+	}
+	if want := "x4000  xF020  TRAP x20            R0=x0078  R7=x4001  CC=x0001\n"; !strings.HasPrefix(trace.String(), want) {
+		t.Errorf("trace starts %q, want %q", trace.String(), want)
+	}
+
+	if _, err := NewWithConfig(Config{}, WithMemorySize(MEMORY_MAX+1)); err == nil {
+		t.Error("a memory size past MEMORY_MAX was accepted")
+	}
+}
+
+func TestClone(t *testing.T) {
+	v := New()
+	v.Out = &bytes.Buffer{}
+	load(v, sumProgram)
+	v.Poke(PC_START+6, 10)
+	for i := 0; i < 8; i++ { // into the loop
+		if _, err := v.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := v.Clone()
+	c.SetReg(R_R1, 100) // a different future for the clone
+	if err := v.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if v.Reg(R_R2) != 55 {
+		t.Errorf("original: R2 = %d, want 55", v.Reg(R_R2))
+	}
+	if want := uint16(10 + 9 + 100*101/2); c.Reg(R_R2) != want {
+		t.Errorf("clone: R2 = %d, want %d", c.Reg(R_R2), want)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	// a machine stopped halfway, with the timer counting and an interrupt pending, carries on
+	// from a snapshot the way it would have
+	machine := func() *VM {
+		v := New()
+		v.Out = &bytes.Buffer{}
+		load(v, sumProgram)
+		v.Poke(PC_START+6, 10)
+		v.Poke(INTERRUPT_TABLE_START+0x90, 0x5000)
+		v.Poke(0x5000, encode.ADD(3, 3, encode.Imm(1)))
+		v.Poke(0x5001, encode.RTI())
+		v.SetReg(R_R6, 0x4000)
+		return v
+	}
+	v := machine()
+	for i := 0; i < 8; i++ {
+		if _, err := v.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	v.memWrite(MR_TMC, 1000)
+	v.memRead(MR_RTCH)
+	v.AssertInterrupt(0x90, 2)
+
+	data, err := v.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := New()
+	r.Out = &bytes.Buffer{}
+	if err := r.Restore(data); err != nil {
+		t.Fatal(err)
+	}
+	if r.reg != v.reg || !slices.Equal(r.memory, v.memory) {
+		t.Error("registers or memory differ")
+	}
+	if r.timerLeft != v.timerLeft || !r.timerRunning || !r.clockLatched.Equal(v.clockLatched.Truncate(time.Millisecond)) {
+		t.Errorf("timer at %d running %v, clock latched at %v", r.timerLeft, r.timerRunning, r.clockLatched)
+	}
+	for _, m := range []*VM{v, r} {
+		if err := m.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if r.Reg(R_R2) != 55 || r.Reg(R_R3) != 1 || r.instrCount != v.instrCount {
+		t.Errorf("restored machine: R2 = %d, R3 = %d after %d instructions, want 55, 1 after %d",
+			r.Reg(R_R2), r.Reg(R_R3), r.instrCount, v.instrCount)
+	}
+
+	r.addSerialPort(MR_SERIAL, "test")
+	if err := r.Restore(data); err == nil {
+		t.Error("restored into a machine with a serial port")
+	}
+	if err := r.Restore(data[:len(data)-1]); err == nil {
+		t.Error("restored a snapshot cut short")
+	}
+}
+
+func TestLoadImageAndWords(t *testing.T) {
+	v := New()
+	v.Out = &bytes.Buffer{}
+	if err := v.LoadImage(bytes.NewReader([]byte{0x30, 0x00, 0x22, 0x05})); err != nil { // LD R1, N
+		t.Fatal(err)
+	}
+	if err := v.LoadWords(PC_START+1, append(sumProgram[1:], 3)); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Run(context.Background()); err != nil || v.Reg(R_R2) != 6 {
+		t.Fatalf("R2 = %d, err %v", v.Reg(R_R2), err)
+	}
+	if err := v.CheckOverlaps(); err != nil {
+		t.Error(err)
+	}
+
+	if err := v.LoadImage(bytes.NewReader([]byte{0x30})); err == nil {
+		t.Error("an image without a whole origin word loaded")
+	}
+}
+
+func TestAssertInterrupt(t *testing.T) {
+	v := New()
+	v.Out = &bytes.Buffer{}
+	load(v, sumProgram)
+	v.Poke(PC_START+6, 3)
+	v.Poke(INTERRUPT_TABLE_START+0x81, 0x5000)
+	v.Poke(0x5000, encode.ADD(3, 3, encode.Imm(7)))
+	v.Poke(0x5001, encode.HALT())
+	v.SetReg(R_R6, 0x4000)
+	if err := v.AssertInterrupt(0x81, 4); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if v.Reg(R_R3) != 7 || v.Reg(R_R2) != 0 {
+		t.Errorf("R3 = %d, R2 = %d: the service routine didn't run first", v.Reg(R_R3), v.Reg(R_R2))
+	}
+	if v.Reg(R_R6) != 0x3FFE || v.Peek(0x3FFE) != PC_START || v.Peek(0x3FFF) != FL_ZRO {
+		t.Errorf("stack at R6=x%04X: PC x%04X, PSR x%04X", v.Reg(R_R6), v.Peek(0x3FFE), v.Peek(0x3FFF))
+	}
+
+	// not above the priority the machine runs at, so it waits
+	v = New()
+	v.Out = &bytes.Buffer{}
+	load(v, sumProgram)
+	v.Poke(PC_START+6, 3)
+	v.AssertInterrupt(0x81, 0)
+	if err := v.Run(context.Background()); err != nil || v.Reg(R_R2) != 6 {
+		t.Errorf("R2 = %d, err %v", v.Reg(R_R2), err)
+	}
+	if err := v.AssertInterrupt(0x81, 8); err == nil {
+		t.Error("priority 8 accepted")
+	}
+}
+
+func TestDisplayRegisters(t *testing.T) {
+	// polls DSR, then writes R0 to DDR, without any traps
+	v := New()
+	var out bytes.Buffer
+	v.Out = &out
+	load(v, []uint16{
+		encode.LDI(1, 4), // R1 = DSR
+		encode.BR(decode.CC_Z|decode.CC_P, -2),
+		encode.LD(0, 4),
+		encode.STI(0, 2), // DDR = R0
+		encode.HALT(),
+		MR_DSR,
+		MR_DDR,
+		'A',
+	})
+	if err := v.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "AHALT\n" {
+		t.Errorf("printed %q", out.String())
+	}
+}
+
+func TestWatchdog(t *testing.T) {
+	// a program stuck in a loop that never pets the watchdog gets interrupted at PL7, or halted
+	run := func(action string) *VM {
+		v := New()
+		v.Out = &bytes.Buffer{}
+		load(v, []uint16{encode.BR(decode.CC_N|decode.CC_Z|decode.CC_P, -1)})
+		v.Poke(INTERRUPT_TABLE_START+WATCHDOG_VECTOR, 0x5000)
+		v.Poke(0x5000, encode.HALT())
+		v.SetReg(R_R6, 0x4000)
+		if err := v.SetupWatchdog(100, action); err != nil {
+			t.Fatal(err)
+		}
+		if err := v.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	v := run("interrupt")
+	if v.priority != WATCHDOG_PRIORITY || v.Peek(0x3FFE) != PC_START || v.haltReason != "halt" {
+		t.Errorf("PL%d, pushed PC x%04X, stopped on %q", v.priority, v.Peek(0x3FFE), v.haltReason)
+	}
+	if v = run("halt"); v.haltReason != "watchdog" || v.instrCount != 100 {
+		t.Errorf("stopped on %q after %d instructions", v.haltReason, v.instrCount)
+	}
+	if err := v.SetupWatchdog(100, "nmi"); err == nil {
+		t.Error("took an unknown action")
+	}
+}
+
+func TestTimer(t *testing.T) {
+	// loads the timer with 20 instructions, counts loop passes in R2 until it's done
+	v := New()
+	v.Out = &bytes.Buffer{}
+	load(v, []uint16{
+		encode.LD(0, 6),
+		encode.STI(0, 6), // TMC = 20
+		encode.ADD(2, 2, encode.Imm(1)),
+		encode.LDI(1, 2), // R1 = TMR
+		encode.BR(decode.CC_Z|decode.CC_P, -3),
+		encode.HALT(),
+		MR_TMR,
+		20,
+		MR_TMC,
+	})
+	if err := v.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if v.Reg(R_R2) != 7 { // 20 instructions, 3 per pass, from the ADD after the store
+		t.Errorf("R2 = %d, want 7", v.Reg(R_R2))
+	}
+	if v.Peek(MR_TMR) != TMR_DONE {
+		t.Errorf("TMR = x%04X", v.Peek(MR_TMR))
+	}
+}
+
+func TestClock(t *testing.T) {
+	v := New()
+	before := time.Now().Unix()
+	high, low := v.memRead(MR_RTCH), v.memRead(MR_RTCL)
+	if sec := int64(high)<<16 | int64(low); sec < before || sec > time.Now().Unix() {
+		t.Errorf("clock reads %d, it's %d", sec, before)
+	}
+	if ms := v.memRead(MR_RTCM); ms > 999 {
+		t.Errorf("%d ms", ms)
+	}
+
+	v.SetupDeterministic(true, 1)
+	v.instrCount = 2500
+	if high, low, ms := v.memRead(MR_RTCH), v.memRead(MR_RTCL), v.memRead(MR_RTCM); high != 0 || low != 0 || ms != 2 {
+		t.Errorf("deterministic clock reads %d %d %d", high, low, ms)
+	}
+}
+
+func TestRandom(t *testing.T) {
+	draw := func(v *VM) []uint16 {
+		var out []uint16
+		for i := 0; i < 4; i++ {
+			out = append(out, v.memRead(MR_RNG))
+		}
+		return out
+	}
+	a, b := New(), New()
+	a.SetupSeed(7)
+	b.SetupSeed(7)
+	first := draw(a)
+	if second := draw(b); !slices.Equal(first, second) {
+		t.Errorf("same seed, different numbers: %v %v", first, second)
+	}
+	a.memWrite(MR_RNG, 7) // the program reseeding
+	if again := draw(a); !slices.Equal(first, again) {
+		t.Errorf("reseeded to the same seed, got %v, want %v", again, first)
+	}
+}
+
+func TestDisk(t *testing.T) {
+	path := t.TempDir() + "/disk.img"
+	v := New()
+	if err := v.SetupDisk(path); err != nil {
+		t.Fatal(err)
+	}
+	defer v.Close()
+	for i := range SECTOR_WORDS {
+		v.Poke(0x4000+uint16(i), uint16(i)*3)
+	}
+	v.memWrite(MR_DSKN, 2)
+	v.memWrite(MR_DSKA, 0x4000)
+	v.memWrite(MR_DSKC, DSKC_WRITE)
+	v.memWrite(MR_DSKA, 0x5000)
+	v.memWrite(MR_DSKC, DSKC_READ)
+	if status := v.memRead(MR_DSKS); status != DSKS_READY {
+		t.Fatalf("status x%04X", status)
+	}
+	for i := range SECTOR_WORDS {
+		if got := v.Peek(0x5000 + uint16(i)); got != uint16(i)*3 {
+			t.Fatalf("word %d read back as %d", i, got)
+		}
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() != 3*SECTOR_WORDS*2 {
+		t.Errorf("disk image is %v, %v", info, err)
+	}
+
+	v.memWrite(MR_DSKA, 0xFF80) // runs into the device page
+	v.memWrite(MR_DSKC, DSKC_READ)
+	if status := v.memRead(MR_DSKS); status != DSKS_READY|DSKS_ERROR {
+		t.Errorf("status x%04X after a bad buffer", status)
+	}
+
+	// the transfer is a store like any other for whoever is watching
+	events := v.Subscribe(2 * SECTOR_WORDS)
+	v.memWrite(MR_DSKA, 0x5000)
+	v.memWrite(MR_DSKC, DSKC_READ)
+	stores := 0
+	for len(events) > 0 {
+		if ev := <-events; ev.Kind == EVENT_MEM && ev.Addr >= 0x5000 && ev.Addr < 0x5000+SECTOR_WORDS {
+			stores++
+		}
+	}
+	if stores != SECTOR_WORDS {
+		t.Errorf("%d stores seen, want %d", stores, SECTOR_WORDS)
+	}
+
+	// and it can't go where the program couldn't store itself
+	v.Protect(0x60F0, 0x60FF)
+	v.memWrite(MR_DSKA, 0x6000)
+	v.memWrite(MR_DSKC, DSKC_READ)
+	if status := v.memRead(MR_DSKS); status != DSKS_READY|DSKS_ERROR || v.Peek(0x6003) != 0 {
+		t.Errorf("status x%04X, x6003 x%04X after a read over ROM", status, v.Peek(0x6003))
+	}
+	v.SetupUserMode(true)
+	v.memory[MR_MPR] = 1 << 5 // x5000-x5FFF
+	v.memWrite(MR_DSKA, 0x5000)
+	v.memWrite(MR_DSKC, DSKC_WRITE)
+	if status := v.memRead(MR_DSKS); status != DSKS_READY|DSKS_ERROR {
+		t.Errorf("status x%04X after a user mode write from supervisor memory", status)
+	}
+}
+
+func TestSerialTCP(t *testing.T) {
+	v := New()
+	if err := v.SetupSerial("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer v.Close()
+	conn, err := net.Dial("tcp", v.serialPorts[0].listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.Write([]byte("hi"))
+	var got []byte
+	for deadline := time.Now().Add(5 * time.Second); len(got) < 2 && time.Now().Before(deadline); {
+		if v.memRead(MR_SERIAL+SERIAL_RSR)&SERIAL_READY != 0 {
+			got = append(got, byte(v.memRead(MR_SERIAL+SERIAL_RDR)))
+		}
+	}
+	if string(got) != "hi" {
+		t.Fatalf("received %q", got)
+	}
+
+	for _, b := range []byte("ok") {
+		v.memWrite(MR_SERIAL+SERIAL_TDR, uint16(b))
+	}
+	buf := make([]byte, 2)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ok" {
+		t.Errorf("sent %q, %v", buf, err)
+	}
+}
+
+func TestSerialPTY(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("ptys are made on linux only")
+	}
+	v := New()
+	path, err := v.SetupSerialFile("pty")
+	if err != nil {
+		t.Skip(err) // no /dev/ptmx in some sandboxes
+	}
+	defer v.Close()
+	term, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer term.Close()
+
+	term.Write([]byte("a\r"))
+	var got []byte
+	for deadline := time.Now().Add(5 * time.Second); len(got) < 2 && time.Now().Before(deadline); {
+		if v.memRead(MR_SERIAL2+SERIAL_RSR)&SERIAL_READY != 0 {
+			got = append(got, byte(v.memRead(MR_SERIAL2+SERIAL_RDR)))
+		}
+	}
+	if string(got) != "a\r" { // raw, no CR to LF
+		t.Fatalf("received %q", got)
+	}
+
+	v.memWrite(MR_SERIAL2+SERIAL_TDR, '\n')
+	buf := make([]byte, 1)
+	if _, err := term.Read(buf); err != nil || buf[0] != '\n' { // raw, no LF to CRLF
+		t.Errorf("sent %q, %v", buf, err)
+	}
+}
+
+// one machine's pty is another's terminal device, -serial2 pty on one and -serial2 /dev/pts/N on the other
+func TestSerialPTYMachines(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("ptys are made on linux only")
+	}
+	a, b := New(), New()
+	path, err := a.SetupSerialFile("pty")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer a.Close()
+	if _, err := b.SetupSerialFile(path); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	for _, c := range []struct {
+		from, to *VM
+		char     uint16
+	}{{a, b, 'a'}, {b, a, 'b'}} {
+		c.from.memWrite(MR_SERIAL2+SERIAL_TDR, c.char)
+		deadline := time.Now().Add(5 * time.Second)
+		for c.to.memRead(MR_SERIAL2+SERIAL_RSR)&SERIAL_READY == 0 && time.Now().Before(deadline) {
+		}
+		if got := c.to.memRead(MR_SERIAL2 + SERIAL_RDR); got != c.char {
+			t.Errorf("sent %q, received %q", c.char, got)
+		}
+	}
+}
+
+func TestVideo(t *testing.T) {
+	v := New()
+	var out bytes.Buffer
+	v.Out = &out
+	if err := v.SetupVideo("xC000,4x2", 30); err != nil {
+		t.Fatal(err)
+	}
+	load(v, []uint16{
+		encode.LD(0, 2),
+		encode.STI(0, 2), // the second row's first cell
+		encode.HALT(),
+		0x1200 | 'A', // green on red
+		0xC004,
+	})
+	if err := v.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	frame := "\x1b[2J\x1b[?25l\x1b[1;1H    \x1b[0m\x1b[2;1H\x1b[0;32;41mA\x1b[0m   \x1b[0m\x1b[3;1H\x1b[?25h"
+	if !strings.HasSuffix(out.String(), frame) {
+		t.Errorf("drew %q, want it to end %q", out.String(), frame)
+	}
+	if err := v.SetupVideo("xFD00", 30); err == nil {
+		t.Error("a grid over the device page was accepted")
+	}
+}
+
+func TestDisplay(t *testing.T) {
+	v := New()
+	var out bytes.Buffer
+	v.Out = &out
+	if err := v.SetupDisplay(true, 30); err != nil {
+		t.Fatal(err)
+	}
+	v.Poke(DISPLAY_START+DISPLAY_WIDTH, 0x7C00) // red, the second row's first pixel
+	v.Poke(PC_START, encode.HALT())
+	if err := v.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if first := "\x1b[1;1H\x1b[38;2;0;0;0m\x1b[48;2;255;0;0m▀\x1b[38;2;0;0;0m"; !strings.Contains(out.String(), first) {
+		t.Errorf("the first cell isn't black over red: %q", out.String()[:80])
+	}
+	if rows := strings.Count(out.String(), "▀"); rows != DISPLAY_WIDTH*DISPLAY_HEIGHT/2 {
+		t.Errorf("%d cells drawn", rows)
+	}
+}
+
+func TestCP437(t *testing.T) {
+	v := New()
+	var out bytes.Buffer
+	v.Out = &out
+	if err := v.SetupEncoding("cp437"); err != nil {
+		t.Fatal(err)
+	}
+	for _, char := range []uint16{'A', 0xB3, 0xDB, 0xFF} {
+		v.putChar(char)
+	}
+	if out.String() != "A│█\u00A0" {
+		t.Errorf("printed %q", out.String())
+	}
+}
+
+func TestKeyboardInterrupt(t *testing.T) {
+	// turns the keyboard interrupt on and spins, the service routine reads the key into R0
+	v := New()
+	v.Out = &bytes.Buffer{}
+	v.In = strings.NewReader("k")
+	load(v, []uint16{
+		encode.LD(0, 2),
+		encode.STI(0, 2), // KBSR = IE
+		encode.BR(decode.CC_N|decode.CC_Z|decode.CC_P, -1),
+		KBSR_IE,
+		MR_KBSR,
+	})
+	v.Poke(INTERRUPT_TABLE_START+KEYBOARD_VECTOR, 0x5000)
+	v.Poke(0x5000, encode.LDI(0, 1))
+	v.Poke(0x5001, encode.HALT())
+	v.Poke(0x5002, MR_KBDR)
+	v.SetReg(R_R6, 0x4000)
+	if err := v.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if v.Reg(R_R0) != 'k' {
+		t.Errorf("R0 = x%04X", v.Reg(R_R0))
+	}
+	if v.Peek(MR_KBSR)&KBSR_READY != 0 {
+		t.Error("KBSR still ready after KBDR was read")
+	}
+}
+
+func TestBeeper(t *testing.T) {
+	v := New()
+	var out bytes.Buffer
+	v.Out = &out
+	v.memWrite(MR_BEEPF, 440)
+	v.memWrite(MR_BEEPD, 100)
+	if out.String() != "\a" {
+		t.Errorf("no bell: %q", out.String())
+	}
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+	dir := t.TempDir()
+	path := dir + "/tone"
+	script := dir + "/play"
+	if err := os.WriteFile(script, []byte("#!/bin/sh\necho \"$@\" > "+path+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	v.SetupBeeper(script + " {hz} {ms} {sec}")
+	v.memWrite(MR_BEEPD, 250)
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if data, _ := os.ReadFile(path); len(data) > 0 {
+			if string(data) != "440 250 0.250\n" {
+				t.Errorf("the command got %q", data)
+			}
+			return
+		}
+	}
+	t.Error("the beep command didn't run")
+}
+
+func TestPCM(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the player is a shell script")
+	}
+	dir := t.TempDir()
+	path := dir + "/samples"
+	script := dir + "/play"
+	if err := os.WriteFile(script, []byte("#!/bin/sh\ncat > "+path+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	v := New()
+	if err := v.SetupPCM(1000, script); err != nil {
+		t.Fatal(err)
+	}
+	for _, sample := range []uint16{1, 0x7FFF, 0x8000} {
+		v.memWrite(MR_PCMD, sample)
+	}
+	if fill := v.memRead(MR_PCMF); fill > 3 {
+		t.Errorf("fill level %d", fill)
+	}
+	for deadline := time.Now().Add(5 * time.Second); v.memRead(MR_PCMF) != 0; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the samples were never played")
+		}
+	}
+	v.Close()
+	if data, err := os.ReadFile(path); err != nil || !bytes.Equal(data, []byte{0, 1, 0x7F, 0xFF, 0x80, 0}) {
+		t.Errorf("played % X, %v", data, err)
+	}
+}
+
+func TestGamepad(t *testing.T) {
+	keys := make(chan keyboard.KeyEvent, 10)
+	v := New()
+	v.Keys = keys
+	v.SetupGamepad(true)
+	keys <- keyboard.KeyEvent{Key: keyboard.KeyArrowLeft}
+	keys <- keyboard.KeyEvent{Rune: 'q'}
+	keys <- keyboard.KeyEvent{Rune: 'z'}
+	if pad := v.memRead(MR_PAD); pad != PAD_LEFT|PAD_A {
+		t.Errorf("pad x%04X, want left and A", pad)
+	}
+	if pad := v.memRead(MR_PAD); pad != PAD_LEFT|PAD_A {
+		t.Errorf("pad x%04X, the buttons should still be held", pad)
+	}
+	if char, err := v.readChar(); err != nil || char != 'q' {
+		t.Errorf("the console got %q, %v", char, err)
+	}
+
+	v = New()
+	v.In = strings.NewReader("\x1bz")
+	v.SetupGamepad(true)
+	v.SetupDeterministic(true, 1)
+	if pad := v.memRead(MR_PAD); pad != PAD_A {
+		t.Errorf("deterministic pad x%04X", pad)
+	}
+	if pad := v.memRead(MR_PAD); pad != 0 {
+		t.Errorf("deterministic pad x%04X on the second read", pad)
+	}
+	if char, err := v.readChar(); err != nil || char != 0x1B {
+		t.Errorf("the console got %q, %v", char, err)
+	}
+}
+
+func TestMailbox(t *testing.T) {
+	// the sender sends 1..5 to machine 1 and halts. the receiver waits for the interrupt,
+	// and its service routine adds up that word and then polls for the rest
+	sender, receiver := New(), New()
+	sender.Out, receiver.Out = &bytes.Buffer{}, &bytes.Buffer{}
+	if err := ConnectMailboxes(sender, receiver); err != nil {
+		t.Fatal(err)
+	}
+	load(sender, []uint16{
+		encode.LD(0, 6),
+		encode.STI(0, 6), // MBA = 1
+		encode.ADD(1, 1, encode.Imm(1)),
+		encode.STI(1, 5), // MBD = R1
+		encode.ADD(2, 1, encode.Imm(-5)),
+		encode.BR(decode.CC_N, -4),
+		encode.HALT(),
+		1,
+		MR_MBA,
+		MR_MBD,
+	})
+	load(receiver, []uint16{
+		encode.LD(0, 2),
+		encode.STI(0, 2), // MBS = IE
+		encode.BR(decode.CC_N|decode.CC_Z|decode.CC_P, -1),
+		MBS_IE,
+		MR_MBS,
+	})
+	receiver.Poke(INTERRUPT_TABLE_START+MAILBOX_VECTOR, 0x5000)
+	receiver.LoadWords(0x5000, []uint16{
+		encode.LDI(2, 9), // the word that raised the interrupt
+		encode.ADD(3, 3, encode.Reg(2)),
+		encode.LDI(1, 6), // wait for the next
+		encode.BR(decode.CC_Z|decode.CC_P, -2),
+		encode.LDI(2, 5),
+		encode.ADD(3, 3, encode.Reg(2)),
+		encode.ADD(1, 3, encode.Imm(-15)),
+		encode.BR(decode.CC_N, -6),
+		encode.HALT(),
+		MR_MBS,
+		MR_MBD,
+	})
+	receiver.SetReg(R_R6, 0x4000)
+
+	if err := sender.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if sender.Peek(MR_MBS)&MBS_DROPPED != 0 {
+		t.Error("a word was dropped")
+	}
+	if err := receiver.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if receiver.Reg(R_R3) != 15 || receiver.Peek(MR_MBF) != 0 {
+		t.Errorf("received a total of %d, the last from machine %d", receiver.Reg(R_R3), receiver.Peek(MR_MBF))
+	}
+}
+
+func TestNIC(t *testing.T) {
+	a, b := New(), New()
+	if err := a.SetupNIC("127.0.0.1:0,127.0.0.1:1"); err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	if err := b.SetupNIC("127.0.0.1:0," + a.nicConn.LocalAddr().String()); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	for i := range FRAME_WORDS {
+		b.Poke(0x4000+uint16(i), uint16(i)<<8|uint16(i))
+	}
+	b.memWrite(MR_NICA, 0x4000)
+	b.memWrite(MR_NICC, NICC_SEND)
+	if b.memRead(MR_NICS)&NICS_ERROR != 0 {
+		t.Fatal("send failed")
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); a.memRead(MR_NICS)&NICS_READY == 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("no frame came in")
+		}
+	}
+	a.memWrite(MR_NICA, 0x5000)
+	a.memWrite(MR_NICC, NICC_RECEIVE)
+	for i := range FRAME_WORDS {
+		if got := a.Peek(0x5000 + uint16(i)); got != uint16(i)<<8|uint16(i) {
+			t.Fatalf("word %d came in as x%04X", i, got)
+		}
+	}
+	a.memWrite(MR_NICC, NICC_RECEIVE)
+	if a.memRead(MR_NICS) != NICS_ERROR {
+		t.Errorf("status x%04X after receiving with nothing there", a.Peek(MR_NICS))
+	}
+}
+
+func TestMPR(t *testing.T) {
+	// user mode code loads from x4000, with x4000-x4FFF protected
+	v := New()
+	v.Out = &bytes.Buffer{}
+	load(v, []uint16{
+		encode.LD(2, 2),  // allowed
+		encode.LDI(1, 2), // not
+		encode.HALT(),
+		7,
+		0x4000,
+	})
+	v.Poke(0x4000, 99)
+	v.Poke(MR_MPR, 1<<4)
+	v.Poke(INTERRUPT_TABLE_START+EXC_ACV, 0x6000)
+	v.Poke(0x6000, encode.HALT())
+	v.SetReg(R_R6, 0x7000)
+	v.savedSSP = 0x5000
+	v.user = true
+
+	if err := v.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if v.Reg(R_R2) != 7 || v.Reg(R_R1) != 0 {
+		t.Errorf("R2 = %d, R1 = %d: want the first load done and the second undone", v.Reg(R_R2), v.Reg(R_R1))
+	}
+	if v.user {
+		t.Error("the handler ran in user mode")
+	}
+	if v.Peek(MR_ACVA) != 0x4000 {
+		t.Errorf("ACVA x%04X", v.Peek(MR_ACVA))
+	}
+	if pc, psr := v.Peek(0x4FFE), v.Peek(0x4FFF); pc != PC_START+2 || psr != PSR_USER|FL_POS {
+		t.Errorf("pushed PC x%04X, PSR x%04X", pc, psr)
+	}
+	if v.Reg(R_R6) != 0x4FFE || v.savedUSP != 0x7000 {
+		t.Errorf("R6 x%04X, saved USP x%04X: want the supervisor stack", v.Reg(R_R6), v.savedUSP)
+	}
+}
+
+func TestMPRDeniedPointer(t *testing.T) {
+	// STI and LDI whose pointer sits in a protected page stop at reading it: nothing is stored
+	// through the 0 a denied read gives, and the destination register keeps its value
+	v := New()
+	v.Out = &bytes.Buffer{}
+	v.Poke(0x3F00, encode.STI(3, 0xFF)) // the pointer at x4000
+	v.Poke(0x3F01, encode.LDI(1, 0xFE))
+	v.Poke(0x3F02, encode.HALT())
+	v.Poke(0x4000, 0x0005)
+	v.Poke(0x0005, 42)
+	v.Poke(MR_MPR, 1<<4)
+	v.Poke(INTERRUPT_TABLE_START+EXC_ACV, 0x6000)
+	v.Poke(0x6000, encode.ADD(4, 4, encode.Imm(1)))
+	v.Poke(0x6001, encode.RTI())
+	v.SetReg(R_PC, 0x3F00)
+	v.SetReg(R_R1, 7)
+	v.SetReg(R_R3, 0xBEEF)
+	v.SetReg(R_R6, 0x7000)
+	v.savedSSP = 0x5000
+	v.user = true
+	vector := v.Peek(TRAP_TABLE_START)
+
+	if err := v.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if v.Reg(R_R4) != 2 {
+		t.Errorf("the routine ran %d times, want 2", v.Reg(R_R4))
+	}
+	if v.Peek(TRAP_TABLE_START) != vector || v.Peek(0x0005) != 42 {
+		t.Errorf("STI stored through the pointer: x0000 = x%04X, x0005 = %d", v.Peek(TRAP_TABLE_START), v.Peek(0x0005))
+	}
+	if v.Reg(R_R1) != 7 {
+		t.Errorf("R1 = x%04X after the LDI", v.Reg(R_R1))
+	}
+}
+
+func TestBanks(t *testing.T) {
+	v := New()
+	if err := v.SetupBanks(0x8000, 4); err != nil {
+		t.Fatal(err)
+	}
+	for bank := uint16(0); bank < 4; bank++ {
+		v.memWrite(MR_BANK, bank)
+		v.memWrite(0x8123, 100+bank)
+	}
+	for _, bank := range []uint16{2, 0, 3, 1} {
+		v.memWrite(MR_BANK, bank)
+		if got := v.memRead(0x8123); got != 100+bank {
+			t.Errorf("bank %d holds %d", bank, got)
+		}
+	}
+	if v.memRead(MR_BANK) != 1 {
+		t.Errorf("bank register reads %d", v.Peek(MR_BANK))
+	}
+
+	c := v.Clone()
+	c.memWrite(MR_BANK, 2)
+	if c.Peek(0x8123) != 102 {
+		t.Error("the clone lost the banks")
+	}
+
+	data, err := v.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := New()
+	if err := r.Restore(data); err != nil {
+		t.Fatal(err)
+	}
+	if r.Peek(0x8123) != 101 || r.memRead(MR_BANK) != 1 {
+		t.Errorf("restored with x8123 = %d in the window of bank %d", r.Peek(0x8123), r.Peek(MR_BANK))
+	}
+	r.memWrite(MR_BANK, 3)
+	if r.Peek(0x8123) != 103 {
+		t.Errorf("bank 3 restored with x8123 = %d", r.Peek(0x8123))
+	}
+
+	v.Reset(false)
+	if v.Peek(0x8123) != 100 {
+		t.Errorf("bank 0 isn't in the window after a reset, x8123 = %d", v.Peek(0x8123))
+	}
+
+	if err := v.SetupBanks(0x8100, 4); err == nil {
+		t.Error("a window off a 4K boundary was accepted")
+	}
+}
+
+func TestPerfCounters(t *testing.T) {
+	v := New()
+	v.Out = &bytes.Buffer{}
+	load(v, sumProgram)
+	v.Poke(PC_START+6, 100)
+	if err := v.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if v.memRead(PERF_INSTR_HI) != 0 { // latches all three
+		t.Fatal("the high word of a small count isn't 0")
+	}
+	instr, cycles, mem := uint64(v.memRead(PERF_INSTR_LO)), uint64(v.memRead(PERF_CYCLES_LO)), uint64(v.memRead(PERF_MEM_LO))
+	if instr != v.instrCount || instr != 2+3*100+1 {
+		t.Errorf("%d instructions, the machine ran %d", instr, v.instrCount)
+	}
+	if cycles != instr+mem || mem < instr {
+		t.Errorf("%d cycles, %d memory accesses", cycles, mem)
+	}
+}
+
+func TestBoard(t *testing.T) {
+	v := New()
+	v.Out = &bytes.Buffer{}
+	v.SetupBoard(true, 0x00FF)
+	load(v, []uint16{
+		encode.LDI(0, 2),
+		encode.STI(0, 2),
+		encode.HALT(),
+		MR_SW,
+		MR_LED,
+	})
+	v.SetSwitches(0xA5A5)
+	if err := v.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if v.LEDs() != 0xA5A5 {
+		t.Errorf("LEDs x%04X, want the switches x%04X", v.LEDs(), 0xA5A5)
+	}
+	v.memWrite(MR_SW, 0)
+	if v.memRead(MR_SW) != 0xA5A5 {
+		t.Error("the program flipped a switch")
+	}
+}
+
+func TestSevenSegment(t *testing.T) {
+	v := New()
+	v.SetupBoard(true, 0)
+	v.memWrite(MR_SEGD, 0x19AF)
+	if got, want := v.Segments(), [4]uint16{0x06, 0x6F, 0x77, 0x71}; got != want {
+		t.Errorf("BCD x19AF lights %02X, want %02X", got, want)
+	}
+	v.memWrite(MR_SEGM, SEGM_RAW)
+	v.memWrite(MR_SEG0, SEG_G)
+	v.memWrite(MR_SEG3, SEG_A|SEG_D|SEG_DP|0xFF00)
+	if got, want := v.Segments(), [4]uint16{SEG_G, 0, 0, SEG_A | SEG_D | SEG_DP}; got != want {
+		t.Errorf("raw mode lights %02X, want %02X", got, want)
+	}
+}
+
+func TestArgs(t *testing.T) {
+	// prints the second argument
+	v := New()
+	var out bytes.Buffer
+	v.Out = &out
+	v.SetupArgs([]string{"first", "second"})
+	load(v, []uint16{
+		encode.AND(1, 1, encode.Imm(0)),
+		encode.ADD(1, 1, encode.Imm(1)),
+		encode.STI(1, 5), // ARGN = 1
+		encode.LDI(0, 5), // R0 = ARGD
+		encode.BR(decode.CC_Z, 2),
+		encode.OUT(),
+		encode.BR(decode.CC_N|decode.CC_Z|decode.CC_P, -4),
+		encode.HALT(),
+		MR_ARGN,
+		MR_ARGD,
+	})
+	if err := v.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "secondHALT\n" {
+		t.Errorf("printed %q", out.String())
+	}
+	if v.memRead(MR_ARGC) != 2 {
+		t.Errorf("ARGC %d", v.memRead(MR_ARGC))
+	}
+}
+
+func TestNVRAM(t *testing.T) {
+	path := t.TempDir() + "/save.bin"
+	v := New()
+	if err := v.LoadNVRAM(path, "xE000-xE001"); err != nil {
+		t.Fatal(err)
+	}
+	v.Poke(0xE000, 0x1234)
+	v.Poke(0xE001, 0xBEEF)
+	if err := v.SaveNVRAM(); err != nil {
+		t.Fatal(err)
+	}
+
+	w := New()
+	if err := w.LoadNVRAM(path, "xE000-xE001"); err != nil {
+		t.Fatal(err)
+	}
+	if w.Peek(0xE000) != 0x1234 || w.Peek(0xE001) != 0xBEEF {
+		t.Errorf("loaded x%04X x%04X", w.Peek(0xE000), w.Peek(0xE001))
+	}
+	if _, err := os.Stat(path + ".tmp"); err == nil {
+		t.Error("the temporary file was left behind")
+	}
+}
+
+func TestGPIO(t *testing.T) {
+	// copies the inputs to the outputs until input pin 1 alone is high
+	dir := t.TempDir()
+	os.WriteFile(dir+"/in.csv", []byte("# ms,pins\n0,x0001\n2,x0002\n"), 0644)
+	v := New()
+	v.Out = &bytes.Buffer{}
+	v.SetupDeterministic(true, 1)
+	if err := v.SetupGPIO(dir+"/in.csv", dir+"/out.csv"); err != nil {
+		t.Fatal(err)
+	}
+	load(v, []uint16{
+		encode.LDI(0, 4), // R0 = GPI
+		encode.STI(0, 4), // GPO = R0
+		encode.ADD(1, 0, encode.Imm(-2)),
+		encode.BR(decode.CC_N|decode.CC_P, -4),
+		encode.HALT(),
+		MR_GPI,
+		MR_GPO,
+	})
+	if err := v.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	v.Close()
+	out, _ := os.ReadFile(dir + "/out.csv")
+	if string(out) != "0,x0001\n2,x0002\n" {
+		t.Errorf("logged %q", out)
+	}
+}
+
+func TestPrinter(t *testing.T) {
+	// waits for ready before each character, the K written again straight away is lost
+	path := t.TempDir() + "/printer.txt"
+	v := New()
+	v.Out = &bytes.Buffer{}
+	if err := v.SetupPrinter(path, 5); err != nil {
+		t.Fatal(err)
+	}
+	load(v, []uint16{
+		encode.LDI(2, 9), // R2 = LPS
+		encode.BR(decode.CC_Z|decode.CC_P, -2),
+		encode.LD(0, 9),
+		encode.STI(0, 7), // LPD = 'O'
+		encode.LDI(2, 5),
+		encode.BR(decode.CC_Z|decode.CC_P, -2),
+		encode.LD(0, 6),
+		encode.STI(0, 3), // LPD = 'K'
+		encode.STI(0, 2),
+		encode.HALT(),
+		MR_LPS,
+		MR_LPD,
+		'O',
+		'K',
+	})
+	if err := v.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	v.Close()
+	if out, _ := os.ReadFile(path); string(out) != "OK" {
+		t.Errorf("printed %q", out)
+	}
+}
+
+func TestSensor(t *testing.T) {
+	// waits for the reading to go over 200, which the trace has it do 5ms in
+	path := t.TempDir() + "/trace.csv"
+	os.WriteFile(path, []byte("0,-100\n5,300\n"), 0644)
+	v := New()
+	v.Out = &bytes.Buffer{}
+	v.SetupDeterministic(true, 1)
+	if err := v.SetupSensor(path); err != nil {
+		t.Fatal(err)
+	}
+	v.Poke(INTERRUPT_TABLE_START+SENSOR_VECTOR, 0x5000)
+	v.Poke(0x5000, encode.HALT())
+	v.SetReg(R_R6, 0x4000)
+	load(v, []uint16{
+		encode.LD(0, 5),
+		encode.STI(0, 5), // SENT = 200
+		encode.LD(0, 5),
+		encode.STI(0, 4), // SENS = IE
+		encode.BR(decode.CC_N|decode.CC_Z|decode.CC_P, -1),
+		200,
+		MR_SENT,
+		SENS_IE,
+		MR_SENS,
+	})
+	if err := v.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if v.instrCount < 5000 || v.instrCount > 5000+0x400+10 {
+		t.Errorf("interrupted after %d instructions", v.instrCount)
+	}
+	if int16(v.memRead(MR_SENV)) != 300 || v.memRead(MR_SENS) != SENS_ABOVE|SENS_IE {
+		t.Errorf("reading %d, status x%04X", int16(v.memRead(MR_SENV)), v.memRead(MR_SENS))
+	}
+
+	if err := v.SetupSensor("square:-10,10,4"); err != nil {
+		t.Fatal(err)
+	}
+	if v.sensorWave(1) != 10 || v.sensorWave(3) != -10 {
+		t.Errorf("square wave %d then %d", v.sensorWave(1), v.sensorWave(3))
+	}
+}
+
+func TestRTI(t *testing.T) {
+	// user mode code waits for the service routine to set R2
+	v := New()
+	v.Out = &bytes.Buffer{}
+	load(v, []uint16{
+		encode.ADD(2, 2, encode.Imm(0)),
+		encode.BR(decode.CC_Z, -2),
+		encode.HALT(),
+	})
+	v.Poke(INTERRUPT_TABLE_START+0x81, 0x5000)
+	v.Poke(0x5000, encode.ADD(2, 2, encode.Imm(1)))
+	v.Poke(0x5001, encode.RTI())
+	v.SetReg(R_R6, 0x7000)
+	v.savedSSP = 0x4000
+	v.user = true
+	v.AssertInterrupt(0x81, 4)
+	if err := v.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if v.Reg(R_R2) != 1 {
+		t.Errorf("R2 = %d", v.Reg(R_R2))
+	}
+	if !v.user || v.priority != 0 || v.Reg(R_R6) != 0x7000 || v.savedSSP != 0x4000 {
+		t.Errorf("user %v, PL%d, R6 x%04X, saved SSP x%04X after RTI", v.user, v.priority, v.Reg(R_R6), v.savedSSP)
+	}
+}
+
+func TestPrivilegeViolation(t *testing.T) {
+	v := New()
+	v.Out = &bytes.Buffer{}
+	v.SetupUserMode(true)
+	load(v, []uint16{encode.RTI(), encode.HALT()})
+	v.Poke(INTERRUPT_TABLE_START+EXC_PRIVILEGE, 0x6000)
+	v.Poke(0x6000, encode.HALT())
+	if err := v.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if v.PSR()&PSR_USER != 0 {
+		t.Error("the handler ran in user mode")
+	}
+	if pc, psr := v.Peek(SSP_START-2), v.Peek(SSP_START-1); pc != PC_START+1 || psr&PSR_USER == 0 {
+		t.Errorf("pushed PC x%04X, PSR x%04X", pc, psr)
+	}
+	v.Reset(false)
+	if v.PSR()&PSR_USER == 0 {
+		t.Error("a reset doesn't go back to user mode")
+	}
+}
+
+func TestIllegalOpcode(t *testing.T) {
+	v := New()
+	v.Out = &bytes.Buffer{}
+	load(v, []uint16{0xD000, encode.HALT()})
+	v.Poke(INTERRUPT_TABLE_START+EXC_ILLEGAL, 0x6000)
+	v.Poke(0x6000, encode.HALT())
+	v.SetReg(R_R6, SSP_START)
+	if err := v.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if pc := v.Peek(SSP_START - 2); pc != PC_START+1 {
+		t.Errorf("pushed PC x%04X", pc)
+	}
+
+	// with no handler
+	v = New()
+	v.Out = &bytes.Buffer{}
+	load(v, []uint16{0xD000, encode.HALT()})
+	if err := v.SetupUnhandled("halt"); err != nil {
+		t.Fatal(err)
+	}
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	if err := v.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if v.haltReason != "unhandled exception" || v.Reg(R_PC) != PC_START {
+		t.Errorf("halted over %q at x%04X", v.haltReason, v.Reg(R_PC))
+	}
+}
+
+func TestProtectedACV(t *testing.T) {
+	// the store to ROM is taken to the handler, which looks up where it went
+	v := New()
+	v.Out = &bytes.Buffer{}
+	v.Protect(0x4000, 0x40FF)
+	load(v, []uint16{
+		encode.STI(0, 1),
+		encode.HALT(),
+		0x4000,
+	})
+	v.Poke(INTERRUPT_TABLE_START+EXC_ACV, 0x6000)
+	v.Poke(0x6000, encode.LDI(3, 1))
+	v.Poke(0x6001, encode.HALT())
+	v.Poke(0x6002, MR_ACVA)
+	v.SetReg(R_R0, 99)
+	v.SetReg(R_R6, SSP_START)
+	if err := v.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if v.Reg(R_R3) != 0x4000 || v.Peek(0x4000) != 0 {
+		t.Errorf("ACVA x%04X, ROM x%04X", v.Reg(R_R3), v.Peek(0x4000))
+	}
+}
+
+func TestProtectedIgnore(t *testing.T) {
+	// like ROM, the store does nothing and the program goes on
+	v := New()
+	v.Out = &bytes.Buffer{}
+	v.Protect(0x4000, 0x40FF)
+	if err := v.SetupProtectionAction("ignore"); err != nil {
+		t.Fatal(err)
+	}
+	load(v, []uint16{
+		encode.STI(0, 2),
+		encode.ADD(2, 2, encode.Imm(1)),
+		encode.HALT(),
+		0x4000,
+	})
+	v.Poke(0x4000, 0x1111)
+	v.Poke(INTERRUPT_TABLE_START+EXC_ACV, 0x6000)
+	v.Poke(0x6000, encode.ADD(3, 3, encode.Imm(1)))
+	v.Poke(0x6001, encode.HALT())
+	v.SetReg(R_R0, 99)
+	v.SetReg(R_R6, SSP_START)
+	if err := v.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if v.Peek(0x4000) != 0x1111 {
+		t.Errorf("ROM x%04X, the store went through", v.Peek(0x4000))
+	}
+	if v.Reg(R_R3) != 0 || v.Reg(R_R6) != SSP_START {
+		t.Errorf("R3 %d, R6 x%04X: the ACV was taken", v.Reg(R_R3), v.Reg(R_R6))
+	}
+	if v.Reg(R_R2) != 1 || v.haltReason != "halt" {
+		t.Errorf("R2 %d, halted over %q: the program didn't carry on", v.Reg(R_R2), v.haltReason)
+	}
+	if err := v.SetupProtectionAction("skip"); err == nil {
+		t.Error("action skip accepted")
+	}
+}
+
+func TestPriorities(t *testing.T) {
+	// the key comes in while the program runs at PL3, above the keyboard's PL2, and is only taken
+	// once it drops to PL0
+	v := New()
+	v.Out = &bytes.Buffer{}
+	v.In = strings.NewReader("k")
+	if err := v.SetupPriorities("keyboard=2"); err != nil {
+		t.Fatal(err)
+	}
+	load(v, []uint16{
+		encode.LD(0, 7),
+		encode.STI(0, 7), // PSR = PL3
+		encode.LD(0, 7),
+		encode.STI(0, 7), // KBSR = IE
+		encode.ADD(1, 1, encode.Imm(1)),
+		encode.AND(0, 0, encode.Imm(0)),
+		encode.STI(0, 2), // PSR = PL0
+		encode.BR(decode.CC_N|decode.CC_Z|decode.CC_P, -1),
+		3 << 8,
+		MR_PSR,
+		KBSR_IE,
+		MR_KBSR,
+	})
+	v.Poke(INTERRUPT_TABLE_START+KEYBOARD_VECTOR, 0x5000)
+	v.Poke(0x5000, encode.HALT())
+	v.SetReg(R_R6, 0x4000)
+	if err := v.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if pc, psr := v.Peek(0x3FFE), v.Peek(0x3FFF); pc != PC_START+7 || psr>>8&7 != 0 {
+		t.Errorf("interrupted at x%04X with PSR x%04X", pc, psr)
+	}
+	if v.PSR()>>8&7 != 2 {
+		t.Errorf("the routine runs with PSR x%04X", v.PSR())
+	}
+	if err := v.SetupPriorities("disk=1"); err == nil {
+		t.Error("the disk doesn't interrupt")
+	}
+}
+
+func TestKeyboardInterruptEcho(t *testing.T) {
+	// the textbook way: the service routine echoes each key and returns, the user program waits for two
+	v := New()
+	var out bytes.Buffer
+	v.Out = &out
+	v.In = strings.NewReader("ab")
+	v.SetupUserMode(true)
+	load(v, []uint16{
+		encode.LD(0, 5),
+		encode.STI(0, 5), // KBSR = IE
+		encode.ADD(3, 2, encode.Imm(-2)),
+		encode.BR(decode.CC_N|decode.CC_P, -2),
+		encode.HALT(),
+		0,
+		KBSR_IE,
+		MR_KBSR,
+	})
+	v.Poke(INTERRUPT_TABLE_START+KEYBOARD_VECTOR, 0x0200)
+	for i, word := range []uint16{
+		encode.LDI(0, 3),
+		encode.STI(0, 3), // DDR = KBDR
+		encode.ADD(2, 2, encode.Imm(1)),
+		encode.RTI(),
+		MR_KBDR,
+		MR_DDR,
+	} {
+		v.Poke(0x0200+uint16(i), word)
+	}
+	if err := v.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "abHALT\n" {
+		t.Errorf("printed %q", out.String())
+	}
+	if !v.user || v.savedSSP != SSP_START || v.priority != 0 {
+		t.Errorf("user %v, saved SSP x%04X at PL%d after the routines returned", v.user, v.savedSSP, v.priority)
+	}
+}
+
+func TestNestedInterrupts(t *testing.T) {
+	// a PL5 interrupt comes in while the PL3 one's routine runs, and is taken over it
+	v := New()
+	v.Out = &bytes.Buffer{}
+	load(v, []uint16{
+		encode.ADD(0, 1, encode.Imm(-1)),
+		encode.BR(decode.CC_N|decode.CC_P, -2), // wait for the PL3 routine
+		encode.HALT(),
+	})
+	v.Poke(INTERRUPT_TABLE_START+0x80, 0x0300)
+	v.Poke(0x0300, encode.ADD(1, 1, encode.Imm(1)))
+	v.Poke(0x0301, encode.ADD(3, 2, encode.Imm(0))) // sees the PL5 routine's R2 if it ran in between
+	v.Poke(0x0302, encode.RTI())
+	v.Poke(INTERRUPT_TABLE_START+0x81, 0x0400)
+	v.Poke(0x0400, encode.ADD(2, 2, encode.Imm(1)))
+	v.Poke(0x0401, encode.RTI())
+	v.SetReg(R_R6, 0x4000)
+
+	if err := v.AssertInterrupt(0x80, 3); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := v.Step(); err != nil || info.PC != 0x0300 {
+		t.Fatalf("first step at x%04X: %v", info.PC, err)
+	}
+	if err := v.AssertInterrupt(0x81, 5); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := v.Step(); err != nil || info.PC != 0x0400 {
+		t.Fatalf("second step at x%04X: %v", info.PC, err)
+	}
+	if v.Reg(R_R6) != 0x3FFC || v.Peek(0x3FFC) != 0x0301 || v.Peek(0x3FFD)>>8&7 != 3 {
+		t.Errorf("R6 x%04X, pushed PC x%04X and PSR x%04X over the PL3 routine's",
+			v.Reg(R_R6), v.Peek(0x3FFC), v.Peek(0x3FFD))
+	}
+	if err := v.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if v.Reg(R_R1) != 1 || v.Reg(R_R3) != 1 {
+		t.Errorf("R1 = %d, R3 = %d", v.Reg(R_R1), v.Reg(R_R3))
+	}
+	if v.Reg(R_R6) != 0x4000 || v.priority != 0 {
+		t.Errorf("R6 x%04X at PL%d after both routines returned", v.Reg(R_R6), v.priority)
+	}
+}
+
+func TestSpecTraps(t *testing.T) {
+	// a user program calls a trap routine in memory and a built-in one
+	v := New()
+	var out bytes.Buffer
+	v.Out = &out
+	v.SetupUserMode(true)
+	if err := v.SetupTrapMode("spec"); err != nil {
+		t.Fatal(err)
+	}
+	load(v, []uint16{
+		encode.TRAP(0x40),
+		encode.LD(0, 2),
+		encode.OUT(),
+		encode.HALT(),
+		'!',
+	})
+	v.Poke(TRAP_TABLE_START+0x40, 0x0500)
+	v.Poke(0x0500, encode.ADD(1, 1, encode.Imm(5)))
+	v.Poke(0x0501, encode.RTI())
+	v.SetReg(R_R6, 0x7000)
+	if err := v.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if v.Reg(R_R1) != 5 || v.Reg(R_R7) != 0 || out.String() != "!HALT\n" {
+		t.Errorf("R1 = %d, R7 = x%04X, printed %q", v.Reg(R_R1), v.Reg(R_R7), out.String())
+	}
+	if !v.user || v.Reg(R_R6) != 0x7000 || v.savedSSP != SSP_START {
+		t.Errorf("user %v, R6 x%04X, saved SSP x%04X after the traps", v.user, v.Reg(R_R6), v.savedSSP)
+	}
+	if v.Peek(SSP_START-2) != PC_START+4 { // HALT's return address, the last thing pushed
+		t.Errorf("pushed PC x%04X", v.Peek(SSP_START-2))
+	}
+}
+
+func TestTableTraps(t *testing.T) {
+	// a routine installed in the trap vector table returns with RET, the vectors left at 0 run in Go
+	v := New()
+	var out bytes.Buffer
+	v.Out = &out
+	if err := v.SetupTrapMode("table"); err != nil {
+		t.Fatal(err)
+	}
+	load(v, []uint16{
+		encode.TRAP(0x40),
+		encode.LD(0, 2),
+		encode.OUT(),
+		encode.HALT(),
+		'!',
+	})
+	v.Poke(TRAP_TABLE_START+0x40, 0x0500)
+	v.Poke(0x0500, encode.ADD(1, 1, encode.Imm(5)))
+	v.Poke(0x0501, encode.ADD(2, 7, encode.Imm(0)))
+	v.Poke(0x0502, encode.RET())
+	if err := v.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if v.Reg(R_R1) != 5 || v.Reg(R_R2) != PC_START+1 || out.String() != "!HALT\n" {
+		t.Errorf("R1 = %d, R2 = x%04X, printed %q", v.Reg(R_R1), v.Reg(R_R2), out.String())
+	}
+	if err := v.SetupTrapMode("os"); err == nil {
+		t.Error("took an unknown trap mode")
+	}
+}
+
+func TestSavedStackPointers(t *testing.T) {
+	v := New()
+	v.SetupSupervisorStack(0x2F00)
+	v.SetupUserMode(true)
+	v.SetReg(R_R6, 0x7000)
+	v.AssertInterrupt(0x81, 5)
+	v.Poke(INTERRUPT_TABLE_START+0x81, 0x5000)
+	v.Poke(0x5000, encode.HALT())
+	v.Out = &bytes.Buffer{}
+	if err := v.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if ssp, usp := v.SavedSP(); v.Reg(R_R6) != 0x2EFE || usp != 0x7000 || ssp != 0x2F00 {
+		t.Errorf("R6 x%04X, saved SSP x%04X, saved USP x%04X in the service routine", v.Reg(R_R6), ssp, usp)
+	}
+
+	// the snapshot keeps the mode and the other stack
+	data, err := v.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := New()
+	if err := w.Restore(data); err != nil {
+		t.Fatal(err)
+	}
+	if _, usp := w.SavedSP(); w.PSR() != v.PSR() || usp != 0x7000 {
+		t.Errorf("restored PSR x%04X, saved USP x%04X", w.PSR(), usp)
+	}
+
+	v.Reset(false)
+	if ssp, _ := v.SavedSP(); ssp != 0x2F00 {
+		t.Errorf("saved SSP x%04X after a reset", ssp)
+	}
+}
+
+func TestStackOverflow(t *testing.T) {
+	run := func(action string) (*VM, error) {
+		v := New()
+		v.Out = &bytes.Buffer{}
+		if err := v.SetupStackOverflow(SSP_LIMIT, action); err != nil {
+			t.Fatal(err)
+		}
+		v.Poke(INTERRUPT_TABLE_START+0x81, 0x5000)
+		v.Poke(INTERRUPT_TABLE_START+EXC_STACK, 0x6000)
+		v.Poke(0x6000, encode.HALT())
+		v.SetReg(R_R6, SSP_LIMIT+1) // one word left
+		v.AssertInterrupt(0x81, 4)
+		return v, v.Run(context.Background())
+	}
+
+	if _, err := run("fault"); !errors.Is(err, ErrStackOverflow) {
+		t.Errorf("got %v, want ErrStackOverflow", err)
+	}
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	v, err := run("exception")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Reg(R_R6) != SSP_START-2 || v.Peek(SSP_LIMIT) != 0 || v.Reg(R_PC) != 0x6001 {
+		t.Errorf("R6 x%04X, PC x%04X, x%04X written", v.Reg(R_R6), v.Reg(R_PC), SSP_LIMIT)
+	}
+}
+
+func TestBacktrace(t *testing.T) {
+	call := func(offset int) []uint16 { // what lc3 asm makes of CALL
+		return []uint16{
+			encode.ADD(6, 6, encode.Imm(-1)),
+			encode.STR(7, 6, 0),
+			encode.JSR(offset),
+			encode.LDR(7, 6, 0),
+			encode.ADD(6, 6, encode.Imm(1)),
+		}
+	}
+	var program []uint16
+	program = append(program, encode.LD(6, 6))
+	program = append(program, call(4)...) // to A
+	program = append(program, encode.HALT(), 0x4000)
+	program = append(program, call(3)...) // A at x3008, to B
+	program = append(program, encode.RET())
+	program = append(program, encode.ADD(0, 0, encode.Imm(1)), encode.RET()) // B at x300E
+
+	v := New()
+	load(v, program)
+	for v.Reg(R_PC) != 0x300E {
+		if _, err := v.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []Frame{
+		{PC: 0x300E, Routine: 0x300E},
+		{PC: 0x300B, Routine: 0x3008},
+		{PC: 0x3004, Slot: 0x3FFE},
+	}
+	if got := v.Backtrace(); !slices.Equal(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestHeap(t *testing.T) {
+	v := New()
+	if err := v.SetupHeap("x5000-x5003"); err != nil {
+		t.Fatal(err)
+	}
+	load(v, []uint16{
+		encode.AND(0, 0, encode.Imm(0)),
+		encode.ADD(0, 0, encode.Imm(3)),
+		encode.TRAP(TRAP_MALLOC), // x5000
+		encode.ADD(1, 0, encode.Imm(0)),
+		encode.AND(0, 0, encode.Imm(0)),
+		encode.ADD(0, 0, encode.Imm(2)),
+		encode.TRAP(TRAP_MALLOC), // doesn't fit
+		encode.BR(decode.CC_N, 1),
+		encode.HALT(),
+		encode.ADD(2, 0, encode.Imm(0)),
+		encode.ADD(0, 1, encode.Imm(0)),
+		encode.TRAP(TRAP_FREE),
+		encode.AND(0, 0, encode.Imm(0)),
+		encode.ADD(0, 0, encode.Imm(4)),
+		encode.TRAP(TRAP_MALLOC), // the whole heap again
+		encode.HALT(),
+	})
+	if err := v.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if v.Reg(R_R1) != 0x5000 || v.Reg(R_R2) != HEAP_FAIL || v.Reg(R_R0) != 0x5000 {
+		t.Errorf("R1 x%04X, R2 x%04X, R0 x%04X", v.Reg(R_R1), v.Reg(R_R2), v.Reg(R_R0))
+	}
+
+	var out strings.Builder
+	v.PrintHeap(&out)
+	if !strings.Contains(out.String(), "0x5000-0x5003      4 words  used") {
+		t.Errorf("heap layout:\n%s", out.String())
+	}
+	if err := New().SetupHeap("xF000-xFFFF"); err == nil {
+		t.Error("a heap in the device page was allowed")
+	}
+}
+
+func TestDeviceAddress(t *testing.T) {
+	v := New()
+	v.SetupArgs([]string{"a", "b"})
+	if err := v.SetupDeviceAddress("args", 0xFEC0); err != nil {
+		t.Fatal(err)
+	}
+	load(v, []uint16{
+		encode.LDI(0, 3), // ARGC where it went
+		encode.LDI(1, 3), // and where it was
+		encode.HALT(),
+		0,
+		0xFEC0,
+		MR_ARGC,
+	})
+	if err := v.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if v.Reg(R_R0) != 2 || v.Reg(R_R1) != 0 {
+		t.Errorf("R0 %d, R1 %d", v.Reg(R_R0), v.Reg(R_R1))
+	}
+
+	for _, c := range []struct {
+		name string
+		base uint16
+	}{
+		{"args", 0xFED0},  // already moved
+		{"timer", 0xFEC2}, // onto the moved args
+		{"timer", 0xFEA4}, // onto where args were
+		{"timer", 0x3000}, // out of the device page
+		{"timer", 0xFFFA}, // into the PSR
+		{"radio", 0xFED0},
+	} {
+		if err := v.SetupDeviceAddress(c.name, c.base); err == nil {
+			t.Errorf("%s at x%04X was allowed", c.name, c.base)
+		}
+	}
+}
+
+func TestDeviceInterrupt(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh to run the plugin")
+	}
+	script := filepath.Join(t.TempDir(), "plugin.sh")
+	plugin := `echo "map xFEC0 xFEC0"
+echo "int x90 PL5"
+echo ready
+read request
+echo irq
+echo x0007
+cat >/dev/null
+`
+	if err := os.WriteFile(script, []byte(plugin), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	v := New()
+	if err := v.SetupDevices([]string{"cmd:sh " + script}); err != nil {
+		t.Fatal(err)
+	}
+	defer v.Close()
+	load(v, []uint16{
+		encode.LDI(1, 1),
+		encode.HALT(),
+		0xFEC0,
+	})
+	v.Poke(INTERRUPT_TABLE_START+0x90, 0x5000)
+	v.Poke(0x5000, encode.ADD(2, 2, encode.Imm(1)))
+	v.Poke(0x5001, encode.HALT())
+	v.SetReg(R_R6, 0x4000)
+	if err := v.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if v.Reg(R_R1) != 7 || v.Reg(R_R2) != 1 || v.PSR()>>8&7 != 5 {
+		t.Errorf("R1 %d, R2 %d, PSR x%04X", v.Reg(R_R1), v.Reg(R_R2), v.PSR())
+	}
+
+	for _, line := range []string{"int x05 PL5", "int x80 PL5", "int x90 PL9"} {
+		if err := os.WriteFile(script, []byte("echo '"+line+"'\necho ready\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		v := New()
+		if err := v.SetupDevices([]string{"cmd:sh " + script}); err == nil {
+			t.Errorf("%q was taken", line)
+		}
+		v.Close()
+	}
+}
+
+func TestLoadObjects(t *testing.T) {
+	main := &Object{
+		Origin: 0x3000,
+		Words: []uint16{
+			encode.JSR(0), // to SUB
+			encode.LD(0, 1),
+			encode.HALT(),
+			0, // SUB's address
+		},
+		Symbols:    map[string]uint16{"MAIN": 0},
+		References: []Reference{{"SUB", 0, 11}, {"SUB", 3, 16}},
+	}
+	sub := &Object{
+		Origin:  0x3000,
+		Words:   []uint16{encode.LD(1, 1), encode.RET(), 0x3000}, // the word holding its own address
+		Relocs:  []uint16{2},
+		Symbols: map[string]uint16{"SUB": 0},
+	}
+	var buf bytes.Buffer
+	if _, err := sub.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadObject(buf.Bytes())
+	if err != nil || !slices.Equal(read.Words, sub.Words) || !slices.Equal(read.Relocs, sub.Relocs) || read.Symbols["SUB"] != 0 {
+		t.Fatalf("read back %+v, %v", read, err)
+	}
+	if _, err := ReadObject([]byte{0x30, 0x00, 0xF0, 0x25}); err != ErrNotObject {
+		t.Errorf("a plain image gave %v", err)
+	}
+
+	v := New()
+	symbols, err := v.LoadObjects([]ObjectLoad{{"main", main, -1}, {"sub", read, -1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if symbols["MAIN"] != 0x3000 || symbols["SUB"] != 0x3004 { // SUB is moved past main
+		t.Fatalf("symbols %v", symbols)
+	}
+	if err := v.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if v.Reg(R_R0) != 0x3004 || v.Reg(R_R1) != 0x3004 {
+		t.Errorf("R0 x%04X, R1 x%04X", v.Reg(R_R0), v.Reg(R_R1))
+	}
+
+	for _, loads := range [][]ObjectLoad{
+		{{"main", main, -1}},                       // SUB is undefined
+		{{"main", main, -1}, {"sub", sub, 0x3800}}, // too far for the JSR
+		{{"sub", sub, -1}, {"sub2", sub, -1}},      // SUB twice
+	} {
+		if _, err := New().LoadObjects(loads); err == nil {
+			t.Errorf("%d objects linked", len(loads))
+		}
+	}
+}
+
+func TestMulDivIllegal(t *testing.T) {
+	v := New()
+	v.Out = &bytes.Buffer{}
+	if err := v.SetupExtensions("muldiv"); err != nil {
+		t.Fatal(err)
+	}
+	load(v, []uint16{0xD000 | 1<<9 | 2<<6 | EXT_MUL<<3 | 3, 0xD000 | 7<<3, encode.HALT()})
+	v.SetReg(R_R2, 6)
+	v.SetReg(R_R3, 7)
+	v.Poke(INTERRUPT_TABLE_START+EXC_ILLEGAL, 0x6000)
+	v.Poke(0x6000, encode.HALT())
+	v.SetReg(R_R6, SSP_START)
+	if err := v.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if v.Reg(R_R1) != 42 {
+		t.Errorf("MUL gave %d", v.Reg(R_R1))
+	}
+	if pc := v.Peek(SSP_START - 2); pc != PC_START+2 {
+		t.Errorf("function 7 pushed PC x%04X, want it taken to the handler", pc)
+	}
+}
+
+func TestShiftIllegal(t *testing.T) {
+	v := New()
+	v.Out = &bytes.Buffer{}
+	if err := v.SetupExtensions("shift"); err != nil {
+		t.Fatal(err)
+	}
+	load(v, []uint16{0xD000 | 1<<9 | 2<<6 | EXT_RSHFA<<4 | 2, 0xD000 | 2<<4 | 1, encode.HALT()})
+	v.SetReg(R_R2, 0xFFF0)
+	v.Poke(INTERRUPT_TABLE_START+EXC_ILLEGAL, 0x6000)
+	v.Poke(0x6000, encode.HALT())
+	v.SetReg(R_R6, SSP_START)
+	if err := v.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if v.Reg(R_R1) != 0xFFFC {
+		t.Errorf("RSHFA gave x%04X", v.Reg(R_R1))
+	}
+	if pc := v.Peek(SSP_START - 2); pc != PC_START+2 {
+		t.Errorf("direction 10 pushed PC x%04X, want it taken to the handler", pc)
+	}
+}
+
+func TestRaiseInterrupt(t *testing.T) {
+	v := New()
+	v.Out = &bytes.Buffer{}
+	v.SetupUserMode(true)
+	load(v, []uint16{encode.ADD(1, 1, encode.Imm(1)), encode.ADD(1, 1, encode.Imm(1)), encode.HALT()})
+	v.Poke(INTERRUPT_TABLE_START+0x80, 0x1000)
+	v.Poke(0x1000, encode.ADD(2, 2, encode.Imm(5)))
+	v.Poke(0x1001, encode.RTI())
+	v.SetReg(R_R6, 0xF000) // the user stack, which the interrupt leaves alone
+
+	if _, err := v.Step(); err != nil {
+		t.Fatal(err)
+	}
+	v.RaiseInterrupt(0x80, 5)
+	info, err := v.Step()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.PC != 0x1000 || v.Reg(R_R2) != 5 || v.Reg(R_R1) != 1 {
+		t.Errorf("stepped x%04X, R1 %d, R2 %d: want the handler's first instruction", info.PC, v.Reg(R_R1), v.Reg(R_R2))
+	}
+	if psr := v.PSR(); psr&PSR_USER != 0 || psr>>8&7 != 5 {
+		t.Errorf("PSR x%04X in the handler, want supervisor mode at PL5", psr)
+	}
+	if sp := v.Reg(R_R6); sp != SSP_START-2 || v.Peek(sp) != PC_START+1 || v.Peek(sp+1) != PSR_USER|FL_POS {
+		t.Errorf("supervisor stack at R6=x%04X: PC x%04X, PSR x%04X", sp, v.Peek(sp), v.Peek(sp+1))
+	}
+	if _, usp := v.SavedSP(); usp != 0xF000 {
+		t.Errorf("saved USP x%04X", usp)
+	}
+
+	v.Step() // RTI
+	if v.Reg(R_PC) != PC_START+1 || v.Reg(R_R6) != 0xF000 || v.PSR()&PSR_USER == 0 {
+		t.Errorf("back at x%04X, R6 x%04X, PSR x%04X", v.Reg(R_PC), v.Reg(R_R6), v.PSR())
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("priority 8 didn't panic")
+		}
+	}()
+	v.RaiseInterrupt(0x80, 8)
+}
+
+func TestTermSizeVideo(t *testing.T) {
+	v := New()
+	v.Out = &bytes.Buffer{}
+	if err := v.SetupVideo("xC000,40x12", 30); err != nil {
+		t.Fatal(err)
+	}
+	load(v, []uint16{encode.TRAP(TRAP_TERMSZ), encode.HALT()})
+	if err := v.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if v.Reg(R_R0) != 40 || v.Reg(R_R1) != 12 {
+		t.Errorf("TERMSZ gave %dx%d, want the 40x12 grid", v.Reg(R_R0), v.Reg(R_R1))
+	}
+}
+
+func TestStepInfo(t *testing.T) {
+	tests := []struct {
+		name   string
+		instr  uint16
+		want   StepInfo
+		writes uint16 // the word expected at the write, if there is one
+	}{
+		{"ADD", encode.ADD(1, 1, encode.Imm(-2)), StepInfo{Op: "ADD", Operands: []string{"R1", "R1", "#-2"}}, 0},
+		{"LDR", encode.LDR(3, 2, 5), StepInfo{Op: "LDR", Operands: []string{"R3", "R2", "#5"}, Reads: []uint16{0x4005}}, 0},
+		{"LDI", encode.LDI(3, 1), StepInfo{Op: "LDI", Operands: []string{"R3", "#1"}, Reads: []uint16{PC_START + 2, 0x4005}}, 0},
+		{"STR", encode.STR(1, 2, -1), StepInfo{Op: "STR", Operands: []string{"R1", "R2", "#-1"}, Writes: []uint16{0x3FFF}}, 0x1234},
+		{"HALT", encode.HALT(), StepInfo{Op: "TRAP", Operands: []string{"x25"}, Halted: true}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := New()
+			v.Out = &bytes.Buffer{}
+			load(v, []uint16{tt.instr, encode.HALT(), 0x4005})
+			v.Poke(0x4005, 0xBEEF)
+			v.SetReg(R_R1, 0x1234)
+			v.SetReg(R_R2, 0x4000)
+			info, err := v.Step()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.PC != PC_START || info.Instr != tt.instr || info.Op != tt.want.Op || !slices.Equal(info.Operands, tt.want.Operands) {
+				t.Errorf("x%04X %04X %s %v", info.PC, info.Instr, info.Op, info.Operands)
+			}
+			if !slices.Equal(info.Reads, tt.want.Reads) || !slices.Equal(info.Writes, tt.want.Writes) {
+				t.Errorf("read %04X and wrote %04X, want %04X and %04X", info.Reads, info.Writes, tt.want.Reads, tt.want.Writes)
+			}
+			if info.Halted != tt.want.Halted {
+				t.Errorf("halted %v", info.Halted)
+			}
+			if !info.Halted && info.NextPC != PC_START+1 {
+				t.Errorf("next PC x%04X", info.NextPC)
+			}
+			for _, address := range info.Writes {
+				if v.Peek(address) != tt.writes {
+					t.Errorf("x%04X holds x%04X", address, v.Peek(address))
+				}
+			}
+		})
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	v := New()
+	v.Out = &bytes.Buffer{}
+	load(v, []uint16{encode.ADD(1, 1, encode.Imm(2)), encode.ST(1, 2), encode.HALT(), 0})
+	all := v.Subscribe(64)
+	stalled := v.Subscribe(1) // never read, the machine goes on without it
+	for {
+		info, err := v.Step()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Halted {
+			break
+		}
+	}
+	v.Close()
+
+	want := []Event{
+		{Kind: EVENT_REG, PC: PC_START, Count: 1, Reg: R_R1, Old: 0, New: 2},
+		{Kind: EVENT_FLAGS, PC: PC_START, Count: 1, Old: FL_ZRO, New: FL_POS},
+		{Kind: EVENT_MEM, PC: PC_START + 1, Count: 2, Addr: PC_START + 4, Old: 0, New: 2},
+		{Kind: EVENT_TRAP, PC: PC_START + 2, Count: 3, Addr: 0x25},
+	}
+	var got []Event
+	for ev := range all { // ends once Close closes it
+		got = append(got, ev)
+	}
+	if len(got) < len(want) || !slices.Equal(got[:len(want)], want) {
+		t.Errorf("events\n%+v\nwant them to start\n%+v", got, want)
+	}
+	if n := len(stalled); n != 1 {
+		t.Errorf("the stalled channel holds %d events", n)
+	}
+	if _, ok := <-stalled; !ok {
+		t.Error("the stalled channel lost its event")
+	}
+	if _, ok := <-stalled; ok {
+		t.Error("Close didn't close the stalled channel")
+	}
+	if dropped := v.DroppedEvents(); dropped != uint64(len(got)-1) {
+		t.Errorf("%d events dropped, want %d", dropped, len(got)-1)
+	}
+}
+
+func TestSubscribeWhileRunning(t *testing.T) {
+	v := New(WithMaxInstructions(100_000))
+	load(v, []uint16{encode.ADD(1, 1, encode.Imm(1)), encode.BR(decode.CC_N|decode.CC_Z|decode.CC_P, -2)})
+	done := make(chan error)
+	go func() { done <- v.Run(context.Background()) }()
+	events := v.Subscribe(16)
+	go func() {
+		for range events {
+		}
+	}()
+	if err := <-done; !errors.Is(err, ErrMaxInstructions) {
+		t.Fatal(err)
+	}
+	v.Close()
+}
+
+func TestStackCheckInterrupts(t *testing.T) {
+	// the program pushes and pops on its stack at x2F00-x2FFF while a tick comes in every few
+	// instructions, its service routine pushing on the stack of its own
+	program := []uint16{
+		encode.ADD(6, 6, encode.Imm(-1)), // PUSH R0
+		encode.STR(0, 6, 0),
+		encode.ADD(0, 0, encode.Imm(1)),
+		encode.LDR(0, 6, 0), // POP R0
+		encode.ADD(6, 6, encode.Imm(1)),
+		encode.BR(decode.CC_N|decode.CC_Z|decode.CC_P, -6),
+	}
+	isr := []uint16{
+		encode.ADD(6, 6, encode.Imm(-1)), // PUSH R1
+		encode.STR(1, 6, 0),
+		encode.ADD(1, 1, encode.Imm(1)),
+		encode.LDR(1, 6, 0), // POP R1
+		encode.ADD(6, 6, encode.Imm(1)),
+		encode.ADD(2, 2, encode.Imm(1)), // ticks taken
+		encode.RTI(),
+	}
+	run := func(user bool, sp uint16) (*VM, error) {
+		v := New()
+		v.Out = &bytes.Buffer{}
+		v.SetupUserMode(user)
+		v.SetupSupervisorStack(0x0800)
+		if err := v.SetupStack("x2F00-x2FFF"); err != nil {
+			t.Fatal(err)
+		}
+		load(v, program)
+		for i, word := range isr {
+			v.Poke(0x1000+uint16(i), word)
+		}
+		v.Poke(INTERRUPT_TABLE_START+0x81, 0x1000)
+		v.SetReg(R_R6, sp)
+		for i := 1; i <= 200; i++ {
+			if i%10 == 0 && i < 190 {
+				v.RaiseInterrupt(0x81, 1)
+			}
+			if _, err := v.Step(); err != nil {
+				return v, err
+			}
+		}
+		return v, nil
+	}
+
+	// user mode: the ticks swap R6 to the supervisor stack at x0800 and back
+	v, err := run(true, 0x3000)
+	if err != nil {
+		t.Fatalf("user mode: %v", err)
+	}
+	if v.Reg(R_R2) == 0 || v.Reg(R_R6) != 0x3000 && v.Reg(R_R6) != 0x2FFF {
+		t.Errorf("user mode: %d ticks, R6 x%04X", v.Reg(R_R2), v.Reg(R_R6))
+	}
+
+	// supervisor mode: the ticks push onto the program's own stack, within its limits
+	v, err = run(false, 0x3000)
+	if err != nil {
+		t.Fatalf("supervisor mode: %v", err)
+	}
+	if v.Reg(R_R2) == 0 {
+		t.Error("supervisor mode: no ticks taken")
+	}
+
+	// and a push off the bottom of the stack is still caught, ticks or not
+	if _, err := run(true, 0x2F00); err == nil || !strings.Contains(err.Error(), "stack overflow") {
+		t.Errorf("user mode overflow gave %v", err)
+	}
+}
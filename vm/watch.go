@@ -0,0 +1,34 @@
+package vm
+
+import (
+	"log"
+	"strings"
+)
+
+type watches struct {
+	watched []bool
+}
+
+// SetupWatches logs every change of the comma separated addresses or ranges, without stopping
+func (v *VM) SetupWatches(spec string) error {
+	if spec == "" {
+		return nil
+	}
+	for _, part := range strings.Split(spec, ",") {
+		start, end, err := ParseRange(part)
+		if err != nil {
+			return err
+		}
+		for a := int(start); a <= int(end); a++ {
+			v.watched[a] = true
+		}
+	}
+	return nil
+}
+
+// logWatch runs before a store of value to address lands
+func (v *VM) logWatch(address, value uint16) {
+	if old := v.memory[address]; old != value {
+		log.Printf("watch 0x%04X: 0x%04X -> 0x%04X (PC=0x%04X)", address, old, value, v.reg[R_PC]-1)
+	}
+}
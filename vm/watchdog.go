@@ -0,0 +1,47 @@
+package vm
+
+import "fmt"
+
+const (
+	WATCHDOG_VECTOR   = 0x85
+	WATCHDOG_PRIORITY = 7 // above everything else, like a non-maskable interrupt
+)
+
+type watchdog struct {
+	watchdogLimit  int    // instructions allowed between writes to the watchdog register, 0 = off
+	watchdogLeft   int    // instructions until it fires
+	watchdogAction string // halt, reset or interrupt
+}
+
+// SetupWatchdog arms the watchdog: the program may run limit instructions without writing
+// to the watchdog register before it fires, and then action happens: "halt", "reset", or
+// "interrupt", which raises the interrupt at x85 at PL7 and starts the count over
+func (v *VM) SetupWatchdog(limit int, action string) error {
+	if action != "halt" && action != "reset" && action != "interrupt" {
+		return fmt.Errorf("want halt, reset or interrupt, got %q", action)
+	}
+	v.watchdogLimit = limit
+	v.watchdogAction = action
+	v.petWatchdog()
+	return nil
+}
+
+func (v *VM) petWatchdog() {
+	v.watchdogLeft = v.watchdogLimit
+}
+
+func (v *VM) watchdogRemaining() uint16 {
+	if v.watchdogLeft > 0xFFFF {
+		return 0xFFFF
+	}
+	return uint16(v.watchdogLeft)
+}
+
+// watchdogExpired counts down one instruction
+func (v *VM) watchdogExpired() bool {
+	if v.watchdogLimit <= 0 {
+		return false
+	}
+	v.watchdogLeft--
+	return v.watchdogLeft <= 0
+}
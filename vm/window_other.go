@@ -0,0 +1,9 @@
+//go:build !x11
+
+package vm
+
+import "errors"
+
+func openWindow(title string) (displayWindow, error) {
+	return nil, errors.New("this lc3 was built without windows, build it with -tags x11 for one")
+}
@@ -0,0 +1,323 @@
+//go:build x11
+
+package vm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/*
+the window speaks the X11 protocol itself rather than going through Xlib, so it needs no cgo and
+no libraries, only an X server: $DISPLAY's, over its unix socket or TCP, with the cookie from
+$XAUTHORITY or ~/.Xauthority if there is one. it takes a window, a GC and PutImage, in strips
+small enough for any server, which is all a framebuffer needs. the screen has to be TrueColor at
+24 or 32 bits a pixel, which is every desktop there is
+*/
+
+const DISPLAY_WINDOW_SCALE = 4 // screen pixels to a framebuffer pixel, each way
+
+const (
+	x11CreateWindow  = 1
+	x11MapWindow     = 8
+	x11ChangeProp    = 18
+	x11CreateGC      = 55
+	x11PutImage      = 72
+	x11AtomWMName    = 39
+	x11AtomString    = 31
+	x11EventExposure = 0x8000
+	x11Expose        = 12
+)
+
+type x11Window struct {
+	conn   net.Conn
+	order  binary.ByteOrder // the server's, for pixels
+	window uint32
+	gc     uint32
+	depth  byte
+	maxReq int // bytes in a request
+	red    uint32
+	green  uint32
+	blue   uint32
+
+	mu     sync.Mutex
+	image  []byte // the last frame, to draw again when the window's uncovered
+	closed bool
+}
+
+func openWindow(title string) (displayWindow, error) {
+	conn, number, err := dialX11(os.Getenv("DISPLAY"))
+	if err != nil {
+		return nil, err
+	}
+	return newX11Window(conn, number, title)
+}
+
+// newX11Window opens a window over a connection to display number's server
+func newX11Window(conn net.Conn, number, title string) (*x11Window, error) {
+	w := &x11Window{conn: conn}
+	if err := w.setup(number, title); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	go w.events()
+	return w, nil
+}
+
+// dialX11 connects to the server for a display name like :0, unix:0 or host:0.0
+func dialX11(display string) (net.Conn, string, error) {
+	if display == "" {
+		return nil, "", errors.New("$DISPLAY isn't set, there's no X server to open a window on")
+	}
+	colon := strings.LastIndexByte(display, ':')
+	if colon < 0 {
+		return nil, "", fmt.Errorf("can't read $DISPLAY %q", display)
+	}
+	host, number := display[:colon], display[colon+1:]
+	number, _, _ = strings.Cut(number, ".")
+	n, err := strconv.Atoi(number)
+	if err != nil {
+		return nil, "", fmt.Errorf("can't read $DISPLAY %q", display)
+	}
+	if host == "" || host == "unix" {
+		conn, err := net.Dial("unix", fmt.Sprintf("/tmp/.X11-unix/X%d", n))
+		return conn, number, err
+	}
+	conn, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(6000+n)))
+	return conn, number, err
+}
+
+// x11Cookie finds the MIT-MAGIC-COOKIE-1 for the display number in the Xauthority file, if any
+func x11Cookie(number string) (string, []byte) {
+	path := os.Getenv("XAUTHORITY")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", nil
+		}
+		path = filepath.Join(home, ".Xauthority")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil
+	}
+	hostname, _ := os.Hostname()
+	field := func() []byte {
+		if len(data) < 2 {
+			data = nil
+			return nil
+		}
+		n := int(binary.BigEndian.Uint16(data))
+		if len(data) < 2+n {
+			data = nil
+			return nil
+		}
+		f := data[2 : 2+n]
+		data = data[2+n:]
+		return f
+	}
+	for len(data) >= 2 {
+		family := binary.BigEndian.Uint16(data)
+		data = data[2:]
+		address, num, name, cookie := field(), field(), field(), field()
+		local := family == 256 && string(address) == hostname || family == 0xFFFF
+		if local && (len(num) == 0 || string(num) == number) && string(name) == "MIT-MAGIC-COOKIE-1" {
+			return string(name), cookie
+		}
+	}
+	return "", nil
+}
+
+func pad4(n int) int { return (n + 3) &^ 3 }
+
+// setup does the connection handshake, then makes and maps the window
+func (w *x11Window) setup(number, title string) error {
+	name, cookie := x11Cookie(number)
+	req := make([]byte, 12, 12+pad4(len(name))+pad4(len(cookie)))
+	req[0] = 'l' // little endian requests
+	binary.LittleEndian.PutUint16(req[2:], 11)
+	binary.LittleEndian.PutUint16(req[6:], uint16(len(name)))
+	binary.LittleEndian.PutUint16(req[8:], uint16(len(cookie)))
+	req = append(req, make([]byte, pad4(len(name)))...)
+	copy(req[12:], name)
+	req = append(req, make([]byte, pad4(len(cookie)))...)
+	copy(req[12+pad4(len(name)):], cookie)
+	if _, err := w.conn.Write(req); err != nil {
+		return err
+	}
+
+	head := make([]byte, 8)
+	if _, err := io.ReadFull(w.conn, head); err != nil {
+		return fmt.Errorf("the X server hung up: %v", err)
+	}
+	body := make([]byte, 4*int(binary.LittleEndian.Uint16(head[6:])))
+	if _, err := io.ReadFull(w.conn, body); err != nil {
+		return fmt.Errorf("the X server hung up: %v", err)
+	}
+	if head[0] != 1 {
+		reason := body
+		if head[0] == 0 {
+			reason = body[:min(int(head[1]), len(body))]
+		}
+		return fmt.Errorf("the X server turned us away: %s", strings.TrimSpace(string(reason)))
+	}
+	return w.create(body, title)
+}
+
+// create reads the screen out of the handshake's reply and makes the window on it
+func (w *x11Window) create(info []byte, title string) error {
+	le := binary.LittleEndian
+	if len(info) < 32 {
+		return errors.New("the X server's reply is too short")
+	}
+	idBase, idMask := le.Uint32(info[4:]), le.Uint32(info[8:])
+	vendor, formats := int(le.Uint16(info[16:])), int(info[21])
+	w.maxReq = 4 * int(le.Uint16(info[18:]))
+	w.order = binary.ByteOrder(binary.LittleEndian)
+	if info[22] == 1 {
+		w.order = binary.BigEndian
+	}
+	at := 32 + pad4(vendor)
+	bpp := map[byte]byte{}
+	for i := 0; i < formats && at+8 <= len(info); i++ {
+		bpp[info[at]] = info[at+1]
+		at += 8
+	}
+	if at+40 > len(info) {
+		return errors.New("the X server's reply is too short")
+	}
+	root, visual, depth := le.Uint32(info[at:]), le.Uint32(info[at+32:]), info[at+38]
+	depths := int(info[at+39])
+	at += 40
+	found := false
+	for i := 0; i < depths && at+8 <= len(info) && !found; i++ {
+		visuals := int(le.Uint16(info[at+2:]))
+		at += 8
+		for j := 0; j < visuals && at+24 <= len(info); j++ {
+			if le.Uint32(info[at:]) == visual && info[at+4] == 4 { // TrueColor
+				w.red, w.green, w.blue = le.Uint32(info[at+8:]), le.Uint32(info[at+12:]), le.Uint32(info[at+16:])
+				found = true
+			}
+			at += 24
+		}
+	}
+	if !found || bpp[depth] != 32 {
+		return fmt.Errorf("the screen is %d bits deep, want TrueColor at 24 or 32", depth)
+	}
+
+	next := idBase
+	id := func() uint32 { next += idMask & -idMask; return next }
+	w.window, w.gc, w.depth = id(), id(), depth
+	width, height := DISPLAY_WIDTH*DISPLAY_WINDOW_SCALE, DISPLAY_HEIGHT*DISPLAY_WINDOW_SCALE
+	var out []byte
+	out = x11Request(out, x11CreateWindow, depth, w.window, root, 0, uint32(height)<<16|uint32(width),
+		1<<16, 0, 0x2|0x800, 0, x11EventExposure) // InputOutput, background-pixel black, event-mask
+	out = x11Request(out, x11ChangeProp, 0, w.window, x11AtomWMName, x11AtomString, 8, uint32(len(title)))
+	out = append(out, title...)
+	out = append(out, make([]byte, pad4(len(title))-len(title))...)
+	binary.LittleEndian.PutUint16(out[len(out)-pad4(len(title))-24+2:], uint16(6+pad4(len(title))/4))
+	out = x11Request(out, x11CreateGC, 0, w.gc, w.window, 0)
+	out = x11Request(out, x11MapWindow, 0, w.window)
+	_, err := w.conn.Write(out)
+	return err
+}
+
+// x11Request appends a request made of 32 bit fields, its length taken from them
+func x11Request(out []byte, opcode, data byte, fields ...uint32) []byte {
+	out = append(out, opcode, data)
+	out = binary.LittleEndian.AppendUint16(out, uint16(1+len(fields)))
+	for _, f := range fields {
+		out = binary.LittleEndian.AppendUint32(out, f)
+	}
+	return out
+}
+
+// events reads what the server sends until it hangs up, drawing the frame again when it's exposed.
+// the window's gone after that, closed by the window manager most likely, and frames are dropped
+func (w *x11Window) events() {
+	event := make([]byte, 32)
+	for {
+		if _, err := io.ReadFull(w.conn, event); err != nil {
+			break
+		}
+		if event[0] == 1 { // a reply, which nothing asks for, but skip it
+			extra := make([]byte, 4*int(binary.LittleEndian.Uint32(event[4:])))
+			if _, err := io.ReadFull(w.conn, extra); err != nil {
+				break
+			}
+		}
+		if event[0]&0x7F == x11Expose && binary.LittleEndian.Uint16(event[16:]) == 0 { // the last of a run
+			w.mu.Lock()
+			w.put()
+			w.mu.Unlock()
+		}
+	}
+	w.mu.Lock()
+	w.closed = true
+	w.mu.Unlock()
+}
+
+// channel scales an 8 bit colour channel into the screen's mask for it
+func channel(c uint8, mask uint32) uint32 {
+	width := bits.OnesCount32(mask)
+	return uint32(c) << 24 >> (32 - width) << bits.TrailingZeros32(mask)
+}
+
+func (w *x11Window) draw(pixels []uint16) error {
+	width := DISPLAY_WIDTH * DISPLAY_WINDOW_SCALE
+	image := make([]byte, 0, 4*width*DISPLAY_HEIGHT*DISPLAY_WINDOW_SCALE)
+	row := make([]byte, 4*width)
+	for y := 0; y < DISPLAY_HEIGHT; y++ {
+		for x := 0; x < DISPLAY_WIDTH; x++ {
+			r, g, b := rgb(pixels[y*DISPLAY_WIDTH+x])
+			pixel := channel(r, w.red) | channel(g, w.green) | channel(b, w.blue)
+			for i := range DISPLAY_WINDOW_SCALE {
+				w.order.PutUint32(row[4*(x*DISPLAY_WINDOW_SCALE+i):], pixel)
+			}
+		}
+		for range DISPLAY_WINDOW_SCALE {
+			image = append(image, row...)
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.image = image
+	return w.put()
+}
+
+// put sends the frame in strips of as many rows as fit in a request
+func (w *x11Window) put() error {
+	if w.image == nil {
+		return nil
+	}
+	width := DISPLAY_WIDTH * DISPLAY_WINDOW_SCALE
+	rows := (w.maxReq - 24) / (4 * width)
+	var out bytes.Buffer
+	for y := 0; y < DISPLAY_HEIGHT*DISPLAY_WINDOW_SCALE; y += rows {
+		n := min(rows, DISPLAY_HEIGHT*DISPLAY_WINDOW_SCALE-y)
+		strip := w.image[4*width*y : 4*width*(y+n)]
+		out.Write(x11Request(nil, x11PutImage, 2, w.window, w.gc, uint32(n)<<16|uint32(width), uint32(y)<<16, uint32(w.depth)<<8)) // ZPixmap
+		binary.LittleEndian.PutUint16(out.Bytes()[out.Len()-24+2:], uint16(6+len(strip)/4))
+		out.Write(strip)
+	}
+	_, err := w.conn.Write(out.Bytes())
+	return err
+}
+
+func (w *x11Window) close() {
+	w.conn.Close()
+}
@@ -0,0 +1,135 @@
+//go:build x11
+
+package vm
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+)
+
+// fakeX11 answers the handshake for a 24 bit TrueColor screen and hands back each request after
+func fakeX11(t *testing.T, conn net.Conn, requests chan<- []byte) {
+	le := binary.LittleEndian
+	setup := make([]byte, 12)
+	if _, err := io.ReadFull(conn, setup); err != nil || setup[0] != 'l' || le.Uint16(setup[2:]) != 11 {
+		t.Errorf("handshake %v %v", setup, err)
+		return
+	}
+	io.ReadFull(conn, make([]byte, pad4(int(le.Uint16(setup[6:])))+pad4(int(le.Uint16(setup[8:])))))
+
+	info := make([]byte, 32)
+	le.PutUint32(info[4:], 0x200000) // resource IDs
+	le.PutUint32(info[8:], 0x1FFFFF)
+	le.PutUint16(info[16:], 4)      // vendor
+	le.PutUint16(info[18:], 0xFFFF) // request length
+	info[20], info[21] = 1, 1       // screens, formats
+	info = append(info, "test"...)
+	info = append(info, 24, 32, 32, 0, 0, 0, 0, 0) // depth 24 is 32 bits a pixel
+	screen := make([]byte, 40)
+	le.PutUint32(screen, 0x100)     // root
+	le.PutUint32(screen[32:], 0x21) // visual
+	screen[38], screen[39] = 24, 1
+	info = append(info, screen...)
+	info = append(info, 24, 0, 1, 0, 0, 0, 0, 0)
+	visual := make([]byte, 24)
+	le.PutUint32(visual, 0x21)
+	visual[4] = 4 // TrueColor
+	le.PutUint32(visual[8:], 0xFF0000)
+	le.PutUint32(visual[12:], 0xFF00)
+	le.PutUint32(visual[16:], 0xFF)
+	info = append(info, visual...)
+	head := []byte{1, 0, 11, 0, 0, 0, 0, 0}
+	le.PutUint16(head[6:], uint16(len(info)/4))
+	conn.Write(append(head, info...))
+
+	for {
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			close(requests)
+			return
+		}
+		req := make([]byte, 4*int(le.Uint16(header[2:])))
+		copy(req, header)
+		if _, err := io.ReadFull(conn, req[4:]); err != nil {
+			close(requests)
+			return
+		}
+		requests <- req
+	}
+}
+
+func TestDisplayWindow(t *testing.T) {
+	client, server := net.Pipe()
+	requests := make(chan []byte, 100)
+	go fakeX11(t, server, requests)
+	w, err := newX11Window(client, "0", "lc3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.close()
+
+	le := binary.LittleEndian
+	for _, want := range []byte{x11CreateWindow, x11ChangeProp, x11CreateGC, x11MapWindow} {
+		req := <-requests
+		if req[0] != want {
+			t.Fatalf("request %d, want %d", req[0], want)
+		}
+		switch want {
+		case x11CreateWindow:
+			if le.Uint32(req[8:]) != 0x100 || le.Uint16(req[16:]) != DISPLAY_WIDTH*DISPLAY_WINDOW_SCALE || req[1] != 24 {
+				t.Errorf("CreateWindow %v", req[:32])
+			}
+		case x11ChangeProp:
+			if string(req[24:27]) != "lc3" {
+				t.Errorf("the title is %q", req[24:])
+			}
+		}
+	}
+
+	pixels := make([]uint16, DISPLAY_WIDTH*DISPLAY_HEIGHT)
+	pixels[0], pixels[DISPLAY_WIDTH-1] = 0x7C00, 0x001F // red and blue at the ends of the first row
+	frames := make(chan error)
+	go func() { frames <- w.draw(pixels) }()
+	image := readPutImages(t, requests)
+	if err := <-frames; err != nil {
+		t.Fatal(err)
+	}
+	width := 4 * DISPLAY_WIDTH * DISPLAY_WINDOW_SCALE
+	if len(image) != width*DISPLAY_HEIGHT*DISPLAY_WINDOW_SCALE {
+		t.Fatalf("%d bytes of image", len(image))
+	}
+	if red := le.Uint32(image[4*(DISPLAY_WINDOW_SCALE-1):]); red != 0xFF0000 {
+		t.Errorf("the first pixel is %06X, want FF0000", red)
+	}
+	if blue := le.Uint32(image[width*(DISPLAY_WINDOW_SCALE-1)+width-4:]); blue != 0xFF {
+		t.Errorf("the last pixel of the first row is %06X, want 0000FF", blue)
+	}
+	if black := le.Uint32(image[width*DISPLAY_WINDOW_SCALE:]); black != 0 {
+		t.Errorf("the second row starts %06X, want black", black)
+	}
+
+	expose := make([]byte, 32)
+	expose[0] = x11Expose
+	server.Write(expose)
+	if again := readPutImages(t, requests); string(again) != string(image) {
+		t.Error("an Expose didn't draw the frame again")
+	}
+}
+
+// readPutImages puts a frame back together from its PutImage strips
+func readPutImages(t *testing.T, requests <-chan []byte) []byte {
+	t.Helper()
+	le := binary.LittleEndian
+	var image []byte
+	for rows := 0; rows < DISPLAY_HEIGHT*DISPLAY_WINDOW_SCALE; {
+		req := <-requests
+		if req[0] != x11PutImage || req[1] != 2 || int(le.Uint16(req[18:])) != rows || req[21] != 24 {
+			t.Fatalf("PutImage %v", req[:24])
+		}
+		rows += int(le.Uint16(req[14:]))
+		image = append(image, req[24:]...)
+	}
+	return image
+}